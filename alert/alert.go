@@ -0,0 +1,158 @@
+// Package alert delivers notifications of spoofed email to external sinks such
+// as webhooks and mailboxes without holding up the analysis.
+package alert
+
+import (
+	"context"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/user/email_spoof_detection/models"
+)
+
+// Delivery settings of the Notifier
+const (
+	queueSize   = 100             // Alerts waiting for delivery before new ones are dropped
+	maxAttempts = 3               // Deliveries attempted per alert and sink
+	retryDelay  = 2 * time.Second // Wait before the first retry, doubled for each further one
+	sendTimeout = 10 * time.Second
+)
+
+// Alert describes an analyzed email, normally a spoofed one
+type Alert struct {
+	Time        time.Time        `json:"time"`
+	Path        string           `json:"path"`
+	From        string           `json:"from,omitempty"`
+	Subject     string           `json:"subject,omitempty"`
+	Verdict     models.Verdict   `json:"verdict"`
+	Score       int              `json:"score"`
+	Ruleset     string           `json:"ruleset_version"`
+	Fingerprint string           `json:"fingerprint"`
+	Findings    []models.Finding `json:"findings"`
+}
+
+// New creates the alert for an analyzed email
+func New(path string, email *models.Email, result *models.AnalysisResult) Alert {
+	alert := Alert{
+		Time:        time.Now().UTC(),
+		Path:        path,
+		Subject:     email.Subject,
+		Verdict:     result.Verdict,
+		Score:       result.Score,
+		Ruleset:     result.RulesetVersion,
+		Fingerprint: result.Fingerprint,
+		Findings:    result.Findings,
+	}
+	if email.From != nil {
+		alert.From = email.From.String()
+	}
+	return alert
+}
+
+// Sink delivers alerts to a single destination
+type Sink interface {
+	Send(ctx context.Context, alert Alert) error
+}
+
+// VerdictSink is implemented by sinks that take alerts for other verdicts than
+// spoofed, such as logs recording every analyzed email. Other sinks only get
+// alerts for spoofed email.
+type VerdictSink interface {
+	Sink
+	Accepts(verdict models.Verdict) bool
+}
+
+// accepts checks if a sink takes the alerts of a verdict
+func accepts(sink Sink, verdict models.Verdict) bool {
+	if filter, ok := sink.(VerdictSink); ok {
+		return filter.Accepts(verdict)
+	}
+	return verdict == models.VerdictSpoofed
+}
+
+// Notifier queues alerts and delivers them to its sinks in the background,
+// retrying failed deliveries
+type Notifier struct {
+	sinks []Sink
+	queue chan Alert
+	done  sync.WaitGroup
+}
+
+// NewNotifier creates a Notifier delivering to the given sinks
+func NewNotifier(sinks ...Sink) *Notifier {
+	n := &Notifier{
+		sinks: sinks,
+		queue: make(chan Alert, queueSize),
+	}
+
+	n.done.Add(1)
+	go n.run()
+
+	return n
+}
+
+// Notify queues an alert for the sinks that take its verdict without blocking,
+// dropping it if the queue is full
+func (n *Notifier) Notify(alert Alert) {
+	wanted := false
+	for _, sink := range n.sinks {
+		wanted = wanted || accepts(sink, alert.Verdict)
+	}
+	if !wanted {
+		return
+	}
+
+	select {
+	case n.queue <- alert:
+	default:
+		log.Printf("Alert queue full, dropping alert for %s", alert.Path)
+	}
+}
+
+// Close waits for the queued alerts to be delivered and closes the sinks that
+// hold connections. Notify mustn't be called afterwards.
+func (n *Notifier) Close() {
+	close(n.queue)
+	n.done.Wait()
+
+	for _, sink := range n.sinks {
+		if closer, ok := sink.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+}
+
+// run delivers queued alerts until the queue is closed
+func (n *Notifier) run() {
+	defer n.done.Done()
+
+	for alert := range n.queue {
+		for _, sink := range n.sinks {
+			if accepts(sink, alert.Verdict) {
+				deliver(sink, alert)
+			}
+		}
+	}
+}
+
+// deliver sends an alert to a sink, retrying with exponential backoff
+func deliver(sink Sink, alert Alert) {
+	delay := retryDelay
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+		err := sink.Send(ctx, alert)
+		cancel()
+		if err == nil {
+			return
+		}
+
+		if attempt == maxAttempts {
+			log.Printf("Error sending alert for %s: %v", alert.Path, err)
+			return
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
@@ -0,0 +1,47 @@
+package alert
+
+import (
+	"context"
+	"fmt"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Email sends each alert as a plain text message through an SMTP relay
+type Email struct {
+	Addr string // host:port of the relay
+	From string
+	To   []string
+}
+
+// Send mails an alert. The relay is expected to accept mail without authentication,
+// as internal relays usually do.
+func (e *Email) Send(ctx context.Context, alert Alert) error {
+	var body strings.Builder
+	fmt.Fprintf(&body, "From: %s\r\n", e.From)
+	fmt.Fprintf(&body, "To: %s\r\n", strings.Join(e.To, ", "))
+	fmt.Fprintf(&body, "Subject: Spoofed email detected: %s\r\n", alert.Path)
+	fmt.Fprintf(&body, "Date: %s\r\n", alert.Time.Format(time.RFC1123Z))
+	body.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+
+	fmt.Fprintf(&body, "File:    %s\r\n", alert.Path)
+	fmt.Fprintf(&body, "From:    %s\r\n", alert.From)
+	fmt.Fprintf(&body, "Subject: %s\r\n", alert.Subject)
+	fmt.Fprintf(&body, "Score:   %d (%s)\r\n\r\nFindings:\r\n", alert.Score, alert.Verdict)
+	for _, finding := range alert.Findings {
+		fmt.Fprintf(&body, "  - [%s] %s\r\n", finding.Severity, finding.Message)
+	}
+
+	// net/smtp has no context support; give up waiting at the deadline instead
+	errc := make(chan error, 1)
+	go func() {
+		errc <- smtp.SendMail(e.Addr, nil, e.From, e.To, []byte(body.String()))
+	}()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,226 @@
+package alert
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/user/email_spoof_detection/models"
+)
+
+// Syslog severities (RFC 5424 section 6.2.1) used for verdicts
+const (
+	syslogCritical = 2
+	syslogError    = 3
+	syslogWarning  = 4
+	syslogNotice   = 5
+	syslogInfo     = 6
+)
+
+// syslogFacilities maps facility names to their codes (RFC 5424 section 6.2.1)
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// ParseSyslogFacility returns the code of a facility name such as "mail" or "local0"
+func ParseSyslogFacility(name string) (int, error) {
+	facility, found := syslogFacilities[strings.ToLower(name)]
+	if !found {
+		return 0, fmt.Errorf("unknown syslog facility %q", name)
+	}
+	return facility, nil
+}
+
+// localSyslogSockets are the sockets local syslog daemons listen on
+var localSyslogSockets = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+// Syslog writes each verdict, not only spoofed ones, as an RFC 5424 message with
+// key=value fields, e.g.
+//
+//	<18>1 2024-06-01T10:00:00Z host spoof_detector 4711 verdict - verdict=spoofed score=7 ...
+//
+// The severity follows the verdict: critical, error or warning for spoofed email
+// by its most severe finding, notice for advisory and info for legitimate email.
+type Syslog struct {
+	// Network and Addr locate the syslog server: "udp" or "tcp" and host:port,
+	// or "unixgram" and a socket path. An empty Network uses the local daemon.
+	Network  string
+	Addr     string
+	Facility int    // e.g. 2 for mail; see ParseSyslogFacility
+	Tag      string // APP-NAME of the messages
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// NewSyslog creates a Syslog sink from a destination such as "local",
+// "udp://host:514", "tcp://host:601" or "unix:///dev/log"
+func NewSyslog(destination string, facility int, tag string) (*Syslog, error) {
+	s := &Syslog{Facility: facility, Tag: tag}
+	if destination == "local" {
+		return s, nil
+	}
+
+	target, err := url.Parse(destination)
+	if err != nil {
+		return nil, fmt.Errorf("invalid syslog destination %q: %w", destination, err)
+	}
+	switch target.Scheme {
+	case "udp", "tcp":
+		if target.Port() == "" {
+			target.Host = net.JoinHostPort(target.Hostname(), "514")
+		}
+		s.Network, s.Addr = target.Scheme, target.Host
+	case "unix":
+		s.Network, s.Addr = "unixgram", target.Path
+	default:
+		return nil, fmt.Errorf("invalid syslog destination %q: want local, udp://host:port, tcp://host:port or unix:///path", destination)
+	}
+	return s, nil
+}
+
+// Accepts takes every verdict, so the log records all analyzed email
+func (s *Syslog) Accepts(verdict models.Verdict) bool {
+	return true
+}
+
+// Send writes an alert as a syslog message, reconnecting after a failed write
+func (s *Syslog) Send(ctx context.Context, alert Alert) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		conn, err := s.dial(ctx)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+
+	message := s.format(alert)
+	if s.Network == "tcp" {
+		// Octet counting framing of RFC 6587
+		message = fmt.Sprintf("%d %s", len(message), message)
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		s.conn.SetWriteDeadline(deadline)
+	}
+	if _, err := s.conn.Write([]byte(message)); err != nil {
+		s.conn.Close()
+		s.conn = nil
+		return fmt.Errorf("writing to syslog: %w", err)
+	}
+	return nil
+}
+
+// Close closes the connection to the syslog server
+func (s *Syslog) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// dial connects to the syslog server, or to the first local socket that accepts
+func (s *Syslog) dial(ctx context.Context) (net.Conn, error) {
+	var dialer net.Dialer
+	if s.Network != "" {
+		return dialer.DialContext(ctx, s.Network, s.Addr)
+	}
+
+	for _, socket := range localSyslogSockets {
+		for _, network := range []string{"unixgram", "unix"} {
+			if conn, err := dialer.DialContext(ctx, network, socket); err == nil {
+				return conn, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no local syslog socket found (tried %s)", strings.Join(localSyslogSockets, ", "))
+}
+
+// format builds the RFC 5424 message of an alert
+func (s *Syslog) format(alert Alert) string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+	tag := s.Tag
+	if tag == "" {
+		tag = "-"
+	}
+
+	rules := make([]string, len(alert.Findings))
+	for i, finding := range alert.Findings {
+		rules[i] = finding.Rule
+	}
+
+	fields := []struct{ key, value string }{
+		{"verdict", string(alert.Verdict)},
+		{"score", fmt.Sprint(alert.Score)},
+		{"path", alert.Path},
+		{"from", alert.From},
+		{"subject", alert.Subject},
+		{"rules", strings.Join(rules, ",")},
+		{"ruleset", alert.Ruleset},
+		{"fingerprint", alert.Fingerprint},
+	}
+	var message strings.Builder
+	for i, field := range fields {
+		if i > 0 {
+			message.WriteByte(' ')
+		}
+		message.WriteString(field.key + "=" + syslogValue(field.value))
+	}
+
+	return fmt.Sprintf("<%d>1 %s %s %s %d verdict - %s",
+		s.Facility*8+syslogSeverity(alert), alert.Time.UTC().Format(time.RFC3339), hostname, tag, os.Getpid(), message.String())
+}
+
+// syslogSeverity maps the verdict of an alert, and for spoofed email its most
+// severe finding, to a syslog severity
+func syslogSeverity(alert Alert) int {
+	switch alert.Verdict {
+	case models.VerdictLegitimate:
+		return syslogInfo
+	case models.VerdictAdvisory:
+		return syslogNotice
+	}
+
+	worst := models.SeverityInfo
+	for _, finding := range alert.Findings {
+		if finding.Severity > worst {
+			worst = finding.Severity
+		}
+	}
+	switch worst {
+	case models.SeverityCritical:
+		return syslogCritical
+	case models.SeverityHigh:
+		return syslogError
+	}
+	return syslogWarning
+}
+
+// syslogValue quotes a field value when it is empty or has spaces, quotes or
+// backslashes, and replaces line breaks, which would split the message
+func syslogValue(value string) string {
+	value = strings.NewReplacer("\r", " ", "\n", " ").Replace(value)
+	if value != "" && !strings.ContainsAny(value, " \t\"\\=") {
+		return value
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
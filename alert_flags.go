@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"flag"
+
+	"github.com/user/email_spoof_detection/alert"
+)
+
+// alertOptions holds the command line flags that configure alerts for spoofed email
+type alertOptions struct {
+	webhook   string
+	smtpAddr  string
+	emailFrom string
+	emailTo   string
+
+	syslog         string
+	syslogFacility string
+	syslogTag      string
+}
+
+// register adds the alert flags to a subcommand's flag set
+func (o *alertOptions) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.webhook, "alert-webhook", "", "URL to POST a JSON alert to for each spoofed email")
+	fs.StringVar(&o.smtpAddr, "alert-smtp", "", "SMTP relay (host:port) through which to email an alert for each spoofed email")
+	fs.StringVar(&o.emailFrom, "alert-email-from", "", "Sender address of alert emails")
+	fs.StringVar(&o.emailTo, "alert-email-to", "", "Comma-separated recipients of alert emails")
+	fs.StringVar(&o.syslog, "syslog", "", "Log every verdict to syslog as key=value fields: \"local\", \"udp://host:514\", \"tcp://host:601\" or \"unix:///dev/log\"")
+	fs.StringVar(&o.syslogFacility, "syslog-facility", "mail", "Syslog facility of -syslog messages, e.g. \"mail\" or \"local0\"")
+	fs.StringVar(&o.syslogTag, "syslog-tag", "spoof_detector", "Syslog tag (APP-NAME) of -syslog messages")
+}
+
+// newNotifier creates a Notifier for the configured sinks, or returns nil when
+// no sink is configured. Syslog records every verdict; the other sinks only
+// alert on spoofed email.
+func (o *alertOptions) newNotifier() (*alert.Notifier, error) {
+	var sinks []alert.Sink
+	if o.webhook != "" {
+		sinks = append(sinks, &alert.Webhook{URL: o.webhook})
+	}
+	if o.smtpAddr != "" {
+		to := splitList(o.emailTo)
+		if o.emailFrom == "" || len(to) == 0 {
+			return nil, errors.New("-alert-smtp requires -alert-email-from and -alert-email-to")
+		}
+		sinks = append(sinks, &alert.Email{Addr: o.smtpAddr, From: o.emailFrom, To: to})
+	}
+	if o.syslog != "" {
+		facility, err := alert.ParseSyslogFacility(o.syslogFacility)
+		if err != nil {
+			return nil, err
+		}
+		sink, err := alert.NewSyslog(o.syslog, facility, o.syslogTag)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+
+	if len(sinks) == 0 {
+		return nil, nil
+	}
+	return alert.NewNotifier(sinks...), nil
+}
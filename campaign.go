@@ -0,0 +1,83 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/user/email_spoof_detection/models"
+)
+
+// minCampaignSize is the fewest emails with spoof signals that must share a
+// sending artifact for them to be reported as a campaign
+const minCampaignSize = 2
+
+// campaignArtifact is a value left in an email by the tool that sent it. A MIME
+// boundary is generated afresh for every message, so one shared by several
+// emails points to a phishing kit with a hard-coded boundary.
+type campaignArtifact struct {
+	kind  string // "boundary" or "mailer"
+	value string
+}
+
+// campaign is a group of emails that share a sending artifact
+type campaign struct {
+	artifact   campaignArtifact
+	total      int      // Emails sharing the artifact
+	suspicious []string // Paths of those with a spoofed or advisory verdict
+}
+
+// campaignTracker groups the emails of a batch by the sending artifacts they share
+type campaignTracker struct {
+	groups map[campaignArtifact]*campaign
+}
+
+// newCampaignTracker creates an empty campaignTracker
+func newCampaignTracker() *campaignTracker {
+	return &campaignTracker{groups: make(map[campaignArtifact]*campaign)}
+}
+
+// add records the sending artifacts of an analyzed email
+func (t *campaignTracker) add(path string, email *models.Email, result *models.AnalysisResult) {
+	var artifacts []campaignArtifact
+	if email.Boundary != "" {
+		artifacts = append(artifacts, campaignArtifact{kind: "boundary", value: email.Boundary})
+	}
+	if email.Mailer != "" {
+		artifacts = append(artifacts, campaignArtifact{kind: "mailer", value: email.Mailer})
+	}
+
+	for _, artifact := range artifacts {
+		group := t.groups[artifact]
+		if group == nil {
+			group = &campaign{artifact: artifact}
+			t.groups[artifact] = group
+		}
+		group.total++
+		if result.Verdict != models.VerdictLegitimate {
+			group.suspicious = append(group.suspicious, path)
+		}
+	}
+}
+
+// campaigns returns the artifacts shared by at least minCampaignSize emails with
+// spoof signals, the largest groups first
+func (t *campaignTracker) campaigns() []*campaign {
+	var campaigns []*campaign
+	for _, group := range t.groups {
+		if len(group.suspicious) >= minCampaignSize {
+			campaigns = append(campaigns, group)
+		}
+	}
+
+	sort.Slice(campaigns, func(i, j int) bool {
+		a, b := campaigns[i], campaigns[j]
+		if len(a.suspicious) != len(b.suspicious) {
+			return len(a.suspicious) > len(b.suspicious)
+		}
+		if a.artifact.kind != b.artifact.kind {
+			return a.artifact.kind < b.artifact.kind
+		}
+		return a.artifact.value < b.artifact.value
+	})
+
+	return campaigns
+}
@@ -0,0 +1,477 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"text/template"
+
+	"github.com/user/email_spoof_detection/alert"
+	"github.com/user/email_spoof_detection/detector"
+	"github.com/user/email_spoof_detection/history"
+	"github.com/user/email_spoof_detection/models"
+	"github.com/user/email_spoof_detection/utils"
+)
+
+// analyzeOptions holds the output settings of the analyze subcommand
+type analyzeOptions struct {
+	verbose  bool
+	dump     bool
+	redact   bool
+	template *template.Template
+	format   string          // "text", "jsonl" or "ioc"
+	history  *history.DB     // Records each result when set
+	notifier *alert.Notifier // Alerts on each email its sinks take, by default the spoofed ones, when set
+	sorted   *verdictFiles   // Records the path of each email by verdict when set
+	minScore int             // Results scoring lower aren't printed
+
+	internalDomains []string // Host names under them are masked by -redact
+
+	listSuspicious bool // Print only the paths of spoofed emails
+
+	inputFormat string // One of the utils.Format* input formats
+}
+
+// jsonlRecord is a result printed by -format jsonl
+type jsonlRecord struct {
+	Path string `json:"path"`
+	*models.AnalysisResult
+}
+
+// templateData is the context passed to custom output templates
+type templateData struct {
+	Path     string
+	Result   *models.AnalysisResult
+	Findings []models.Finding
+	Email    *models.Email
+}
+
+// runAnalyze implements the "analyze" subcommand
+func runAnalyze(args []string) error {
+	fs := flag.NewFlagSet("analyze", flag.ExitOnError)
+	filePath := fs.String("file", "", "Path to a single email file to analyze")
+	dirPath := fs.String("dir", "", "Path to a directory of email files to analyze")
+	stdin := fs.Bool("stdin", false, "Read a single email from standard input")
+	comparePath := fs.String("compare", "", "Path to an email to compare side by side with the email given after the flags, e.g. \"-compare good.eml suspicious.eml\"")
+	verbose := fs.Bool("verbose", false, "Enable verbose output")
+	dump := fs.Bool("dump", false, "Print the parsed email as JSON without running the analysis")
+	redact := fs.Bool("redact", false, "Mask recipients, internal IPs and host names under the -my-domains in the output, history and alerts")
+	historyPath := fs.String("history", "", "Path to a SQLite database in which each result is recorded")
+	format := fs.String("format", "text", "Output format: \"text\", \"jsonl\" for one JSON object per line as each email is analyzed, or \"ioc\" for one line of indicators per suspicious email")
+	templateText := fs.String("template", "", "Go text/template string, or path to a template file, used to print each result")
+	inputFormat := fs.String("input-format", utils.FormatAuto, "Input format: \"eml\", \"gmail\" or \"graph\" API JSON, or \"auto\" to detect the format of .json files")
+	minScore := fs.Int("min-score", 0, "Only print results scoring at least this much; the others are still analyzed, recorded and counted")
+	sampleSize := fs.Int("sample", 0, "Only analyze this many files of -dir, picked at random but reproducibly for a given -sample-seed; 0 analyzes all")
+	sampleSeed := fs.Int64("sample-seed", 1, "Seed of the random selection made by -sample")
+	listSuspicious := fs.Bool("list-suspicious", false, "Only print the paths of the emails found spoofed, one per line, e.g. for xargs")
+	spoofedOut := fs.String("spoofed-out", "", "Path to a file to which the paths of the emails found spoofed are appended, one per line")
+	cleanOut := fs.String("clean-out", "", "Path to a file to which the paths of the emails found legitimate are appended, one per line")
+	advisoryOut := fs.String("advisory-out", "", "Path to a file to which the paths of the emails given the advisory verdict are appended, one per line; without it they are written to neither file")
+	timeout := fs.Duration("timeout", 0, "Stop analyzing after this long (e.g. 30s or 5m), reporting the files skipped; 0 means no limit")
+	var detectorOpts detectorOptions
+	detectorOpts.register(fs)
+	var alertOpts alertOptions
+	alertOpts.register(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	// Configure logging
+	if *verbose {
+		log.SetFlags(log.LstdFlags | log.Lshortfile)
+	}
+
+	// Validate input
+	if *comparePath != "" && fs.NArg() != 1 {
+		return errors.New("-compare needs exactly one more email to compare with, e.g. -compare good.eml suspicious.eml")
+	}
+	if *comparePath == "" && *filePath == "" && *dirPath == "" && !*stdin {
+		return errors.New("you must specify one of the -file, -dir, -stdin or -compare flags")
+	}
+
+	opts := analyzeOptions{verbose: *verbose, dump: *dump, redact: *redact, inputFormat: *inputFormat, minScore: *minScore, format: *format, listSuspicious: *listSuspicious}
+	opts.internalDomains = splitList(detectorOpts.myDomains)
+	if *listSuspicious && (*format != "text" || *templateText != "" || *dump || *comparePath != "") {
+		return errors.New("-list-suspicious can't be combined with -format, -template, -dump or -compare")
+	}
+	if (*spoofedOut != "" || *cleanOut != "" || *advisoryOut != "") && (*dump || *comparePath != "") {
+		return errors.New("-spoofed-out, -clean-out and -advisory-out can't be combined with -dump or -compare")
+	}
+	switch *format {
+	case "text":
+	case "jsonl", "ioc":
+		if *templateText != "" {
+			return fmt.Errorf("-format %s can't be combined with -template", *format)
+		}
+	default:
+		return fmt.Errorf("unknown output format %q", *format)
+	}
+	if *templateText != "" {
+		tmpl, err := loadTemplate(*templateText)
+		if err != nil {
+			return err
+		}
+		opts.template = tmpl
+	}
+
+	spfDetector, err := detectorOpts.newDetector()
+	if err != nil {
+		return err
+	}
+
+	if *historyPath != "" {
+		db, err := history.Open(*historyPath)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		opts.history = db
+	}
+
+	sorted, err := openVerdictFiles(map[models.Verdict]string{
+		models.VerdictSpoofed:    *spoofedOut,
+		models.VerdictLegitimate: *cleanOut,
+		models.VerdictAdvisory:   *advisoryOut,
+	})
+	if err != nil {
+		return err
+	}
+	if sorted != nil {
+		defer sorted.Close()
+		opts.sorted = sorted
+	}
+
+	notifier, err := alertOpts.newNotifier()
+	if err != nil {
+		return err
+	}
+	if notifier != nil {
+		// Deliver the queued alerts before exiting
+		defer notifier.Close()
+		opts.notifier = notifier
+	}
+
+	ctx := context.Background()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	// Compare two emails
+	if *comparePath != "" {
+		return runCompare(ctx, spfDetector, *comparePath, fs.Arg(0), opts)
+	}
+
+	// Process standard input
+	if *stdin {
+		emailData, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return fmt.Errorf("reading standard input: %w", err)
+		}
+		printAnalysis(ctx, spfDetector, "<stdin>", emailData, opts)
+		return nil
+	}
+
+	// Process a single file
+	if *filePath != "" {
+		processEmailFile(ctx, spfDetector, *filePath, opts)
+		return nil
+	}
+
+	// Process a directory of files
+	entries, err := os.ReadDir(*dirPath)
+	if err != nil {
+		return fmt.Errorf("reading directory: %w", err)
+	}
+	files := emailFiles(entries)
+	if *sampleSize > 0 {
+		sample := sampleFiles(files, *sampleSize, *sampleSeed)
+		fmt.Fprintf(os.Stderr, "Sampling %d of %d file(s) with seed %d\n", len(sample), len(files), *sampleSeed)
+		files = sample
+	}
+
+	processed, hidden, skipped := 0, 0, 0
+	for _, file := range files {
+		// Don't start on more files once the deadline has passed
+		if ctx.Err() != nil {
+			skipped++
+			continue
+		}
+
+		fullPath := filepath.Join(*dirPath, file.Name())
+		switch processEmailFile(ctx, spfDetector, fullPath, opts) {
+		case analysisShown:
+			processed++
+		case analysisHidden:
+			processed++
+			hidden++
+		case analysisFailed:
+			if ctx.Err() != nil {
+				skipped++
+			}
+		}
+	}
+
+	if *minScore > 0 {
+		fmt.Fprintf(os.Stderr, "%d email(s) analyzed, %d shown, %d scoring below %d not shown\n", processed, processed-hidden, hidden, *minScore)
+	}
+
+	if ctx.Err() != nil {
+		fmt.Fprintf(os.Stderr, "Timeout of %s reached: %d file(s) analyzed, %d skipped\n", *timeout, processed, skipped)
+	}
+
+	return nil
+}
+
+// loadTemplate parses an output template given either inline or as a path to a file
+func loadTemplate(value string) (*template.Template, error) {
+	text := value
+	if content, err := os.ReadFile(value); err == nil {
+		text = string(content)
+	}
+
+	tmpl, err := template.New("output").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parsing template: %w", err)
+	}
+
+	return tmpl, nil
+}
+
+// analysisOutcome tells what became of an email given to printAnalysis
+type analysisOutcome int
+
+const (
+	analysisFailed analysisOutcome = iota // Unreadable, unparsable or interrupted
+	analysisShown
+	analysisHidden // Scored below -min-score
+)
+
+// processEmailFile analyzes an email file and prints the verdict
+func processEmailFile(ctx context.Context, spfDetector *detector.SpoofDetector, filePath string, opts analyzeOptions) analysisOutcome {
+	// Read the email file
+	emailData, err := os.ReadFile(filePath)
+	if err != nil {
+		log.Printf("Error reading file %s: %v\n", filePath, err)
+		return analysisFailed
+	}
+
+	return printAnalysis(ctx, spfDetector, filePath, emailData, opts)
+}
+
+// printAnalysis analyzes an email and prints the verdict. Nothing is printed or
+// recorded for an analysis cut short by ctx, and results scoring below the
+// minimum score are recorded but not printed.
+func printAnalysis(ctx context.Context, spfDetector *detector.SpoofDetector, name string, emailData []byte, opts analyzeOptions) analysisOutcome {
+	emailData, err := utils.ConvertToMIME(name, emailData, opts.inputFormat)
+	if err != nil {
+		log.Printf("Error converting email %s: %v\n", name, err)
+		return analysisFailed
+	}
+
+	// Print what the parser extracted, leaving the detector out of it
+	if opts.dump {
+		email, err := utils.ParseEmail(emailData)
+		if err != nil {
+			log.Printf("Error parsing email %s: %v\n", name, err)
+			return analysisFailed
+		}
+		if opts.redact {
+			email = newRedactor(email, opts.internalDomains).email(email)
+		}
+		if err := printDump(email); err != nil {
+			log.Printf("Error printing email %s: %v\n", name, err)
+		}
+		return analysisShown
+	}
+
+	email, results, err := analyzeEmail(ctx, spfDetector, emailData)
+	if err != nil {
+		log.Printf("Error parsing email %s: %v\n", name, err)
+		return analysisFailed
+	}
+
+	// Lookups abandoned at the deadline would skew the verdict
+	if ctx.Err() != nil {
+		log.Printf("Analysis of %s interrupted: %v\n", name, ctx.Err())
+		return analysisFailed
+	}
+
+	// Recipients are masked before the result is recorded, sent or printed
+	if opts.redact {
+		r := newRedactor(email, opts.internalDomains)
+		email, results = r.email(email), r.result(results)
+	}
+
+	if opts.history != nil {
+		if err := opts.history.Record(name, email, results); err != nil {
+			log.Printf("Error: %v\n", err)
+		}
+	}
+
+	if opts.notifier != nil {
+		opts.notifier.Notify(alert.New(name, email, results))
+	}
+
+	if opts.sorted != nil {
+		if err := opts.sorted.Record(name, results.Verdict); err != nil {
+			log.Printf("Error: %v\n", err)
+		}
+	}
+
+	if results.Score < opts.minScore {
+		return analysisHidden
+	}
+
+	// Print nothing but the path, so the list can be fed to other tools
+	if opts.listSuspicious {
+		if results.IsSpoofed {
+			fmt.Println(name)
+		}
+		return analysisShown
+	}
+
+	// Print results as a line of JSON; os.Stdout is unbuffered, so each line
+	// reaches a consumer as soon as it is written
+	if opts.format == "jsonl" || opts.format == "ioc" {
+		var record interface{} = jsonlRecord{Path: name, AnalysisResult: results}
+		if opts.format == "ioc" {
+			// Only suspicious email yields indicators
+			if results.Verdict == models.VerdictLegitimate {
+				return analysisShown
+			}
+			record = newIOCReport(name, email, results, spfDetector.ImpersonatedBrand(email))
+		}
+
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetEscapeHTML(false)
+		if err := encoder.Encode(record); err != nil {
+			log.Printf("Error writing result for %s: %v\n", name, err)
+		}
+		return analysisShown
+	}
+
+	// Print results using the custom template
+	if opts.template != nil {
+		data := templateData{
+			Path:     name,
+			Result:   results,
+			Findings: results.Findings,
+			Email:    email,
+		}
+		if err := opts.template.Execute(os.Stdout, data); err != nil {
+			log.Printf("Error executing template for %s: %v\n", name, err)
+		}
+		return analysisShown
+	}
+
+	// Print results
+	fmt.Printf("Analyzing email: %s\n", name)
+	switch {
+	case results.Verdict == models.VerdictSpoofed:
+		fmt.Printf("⚠️  SPOOFED EMAIL DETECTED: %s\n", name)
+		printReasons(results)
+	case results.Verdict == models.VerdictAdvisory:
+		fmt.Printf("❔ SUSPICIOUS EMAIL (advisory, score %d): %s\n", results.Score, name)
+		printReasons(results)
+	case opts.verbose:
+		fmt.Printf("✓ Email appears legitimate: %s\n", name)
+	}
+
+	if opts.verbose {
+		fmt.Printf("  Score: %d of threshold %d (ruleset %s)\n", results.Score, results.Threshold, results.RulesetVersion)
+		if results.Action != "" {
+			fmt.Printf("  Action: %s\n", results.Action)
+		}
+		fmt.Printf("  Fingerprint: %s\n", results.Fingerprint)
+		fmt.Printf("  Authentication: %s\n", authenticationSummary(results.Authentication, len(results.NotEvaluated) > 0))
+		if results.Origin != nil {
+			fmt.Printf("  Origin: %s (Received header %d)\n", results.Origin.IP, results.Origin.Hop)
+		}
+		if results.Encryption != "" {
+			fmt.Printf("  Encryption: %s (body content not checked)\n", results.Encryption)
+		}
+		for _, attachment := range results.Attachments {
+			fmt.Printf("  Attachment: %s (%s, %d bytes) sha256 %s\n",
+				attachment.Filename, attachment.ContentType, attachment.Size, attachment.SHA256)
+		}
+	}
+
+	// Resolver problems are shown apart, as they say nothing about the email
+	if len(results.Diagnostics) > 0 {
+		fmt.Println("  Diagnostics (not counted in the score):")
+		for _, diagnostic := range results.Diagnostics {
+			fmt.Printf("    - %s\n", diagnostic)
+		}
+	}
+
+	fmt.Println()
+	return analysisShown
+}
+
+// printReasons lists the reasons of a flagged email, the primary one first
+func printReasons(results *models.AnalysisResult) {
+	reasons := results.Reasons
+	if results.PrimaryReason != "" {
+		fmt.Printf("  Flagged primarily because: %s\n", results.PrimaryReason)
+		reasons = reasons[1:]
+	}
+	for _, reason := range reasons {
+		fmt.Printf("  - %s\n", reason)
+	}
+}
+
+// authenticationSummary describes the SPF, DKIM, DMARC, PGP and BIMI results of an
+// email, noting when checks were skipped in offline mode
+func authenticationSummary(auth models.AuthenticationResults, offline bool) string {
+	describe := func(value string) string {
+		if value == "" && offline {
+			return "not evaluated (offline)"
+		}
+		if value == "" {
+			return "not evaluated"
+		}
+		return value
+	}
+
+	summary := "SPF " + describe(auth.SPF)
+	if len(auth.SPFRedirects) > 0 {
+		summary += " via redirect=" + auth.SPFRedirects[len(auth.SPFRedirects)-1]
+	}
+	summary += ", DKIM " + describe(auth.DKIM) + ", DMARC " + describe(auth.DMARC)
+
+	// SPF is evaluated for the From domain itself, so a pass is aligned
+	switch auth.DMARC {
+	case "reject", "quarantine", "none":
+		summary += " from " + auth.DMARCSource
+		if auth.SPF == string(detector.SPFPass) {
+			summary += " (aligned via SPF)"
+		} else {
+			summary += " (not aligned)"
+		}
+	}
+	if auth.PGP != "" {
+		summary += ", PGP " + auth.PGP
+	}
+	if auth.BIMI != "" {
+		summary += ", BIMI " + auth.BIMI
+	}
+	return summary
+}
+
+// analyzeEmail parses raw email data and runs it through the detector
+func analyzeEmail(ctx context.Context, spfDetector *detector.SpoofDetector, emailData []byte) (*models.Email, *models.AnalysisResult, error) {
+	email, err := utils.ParseEmail(emailData)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return email, spfDetector.AnalyzeContext(ctx, email), nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"time"
+
+	"github.com/user/email_spoof_detection/imap"
+	"github.com/user/email_spoof_detection/utils"
+)
+
+// runIMAP implements the "imap" subcommand
+func runIMAP(args []string) error {
+	fs := flag.NewFlagSet("imap", flag.ExitOnError)
+	server := fs.String("server", "", "IMAP server as host or host:port (port 993 by default)")
+	user := fs.String("user", "", "IMAP user name")
+	password := fs.String("password", "", "IMAP password; taken from the IMAP_PASSWORD environment variable when not set")
+	folder := fs.String("folder", "INBOX", "Folder to analyze, opened read-only")
+	unseen := fs.Bool("unseen", false, "Only analyze unread messages; they stay unread")
+	since := fs.String("since", "", "Only analyze messages received on or after this date (YYYY-MM-DD)")
+	limit := fs.Int("limit", 0, "Only analyze the most recent messages up to this number; 0 analyzes all")
+	plaintext := fs.Bool("no-tls", false, "Connect without TLS, e.g. to a local test server; the password is sent in the clear")
+	verbose := fs.Bool("verbose", false, "Enable verbose output")
+	format := fs.String("format", "text", "Output format: \"text\" or \"jsonl\" for one JSON object per line")
+	var detectorOpts detectorOptions
+	detectorOpts.register(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if *server == "" || *user == "" {
+		return errors.New("you must specify the -server and -user flags")
+	}
+	if *password == "" {
+		*password = os.Getenv("IMAP_PASSWORD")
+	}
+	if *format != "text" && *format != "jsonl" {
+		return fmt.Errorf("unknown output format %q", *format)
+	}
+
+	var criteria []string
+	if *unseen {
+		criteria = append(criteria, "UNSEEN")
+	}
+	if *since != "" {
+		date, err := time.Parse(dateLayout, *since)
+		if err != nil {
+			return fmt.Errorf("invalid -since date %q", *since)
+		}
+		criteria = append(criteria, "SINCE", imap.SearchDate(date))
+	}
+
+	spfDetector, err := detectorOpts.newDetector()
+	if err != nil {
+		return err
+	}
+
+	addr := *server
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "993")
+	}
+
+	client, err := imap.Dial(addr, *plaintext)
+	if err != nil {
+		return err
+	}
+	defer client.Logout()
+
+	if err := client.Login(*user, *password); err != nil {
+		return err
+	}
+	if err := client.Examine(*folder); err != nil {
+		return err
+	}
+
+	uids, err := client.Search(criteria...)
+	if err != nil {
+		return err
+	}
+	if *limit > 0 && len(uids) > *limit {
+		uids = uids[len(uids)-*limit:]
+	}
+
+	opts := analyzeOptions{verbose: *verbose, format: *format, inputFormat: utils.FormatMIME}
+	for _, uid := range uids {
+		// Name messages with an RFC 5092 IMAP URL, leaving out the user
+		name := fmt.Sprintf("imap://%s/%s;UID=%d", *server, *folder, uid)
+
+		data, err := client.Fetch(uid)
+		if err != nil {
+			log.Printf("Error: %v\n", err)
+			continue
+		}
+		printAnalysis(context.Background(), spfDetector, name, data, opts)
+	}
+
+	if *verbose {
+		fmt.Fprintf(os.Stderr, "%d message(s) analyzed in %s\n", len(uids), *folder)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/user/email_spoof_detection/history"
+)
+
+// dateLayout is the format of the -since and -until flags
+const dateLayout = "2006-01-02"
+
+// runQuery implements the "query" subcommand
+func runQuery(args []string) error {
+	fs := flag.NewFlagSet("query", flag.ExitOnError)
+	historyPath := fs.String("history", "", "Path to the SQLite scan history database")
+	domain := fs.String("domain", "", "Only show scans of email from this From domain")
+	since := fs.String("since", "", "Only show scans on or after this date (YYYY-MM-DD)")
+	until := fs.String("until", "", "Only show scans on or before this date (YYYY-MM-DD)")
+	asJSON := fs.Bool("json", false, "Print the matching scans as JSON")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if *historyPath == "" {
+		return errors.New("you must specify the -history flag")
+	}
+
+	filter := history.Filter{Domain: *domain}
+	var err error
+	if *since != "" {
+		if filter.Since, err = time.Parse(dateLayout, *since); err != nil {
+			return fmt.Errorf("invalid -since date %q", *since)
+		}
+	}
+	if *until != "" {
+		if filter.Until, err = time.Parse(dateLayout, *until); err != nil {
+			return fmt.Errorf("invalid -until date %q", *until)
+		}
+		// Include the whole day
+		filter.Until = filter.Until.AddDate(0, 0, 1)
+	}
+
+	db, err := history.Open(*historyPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	entries, err := db.Query(filter)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(entries)
+	}
+
+	spoofed := 0
+	for _, entry := range entries {
+		verdict := "legitimate"
+		if entry.Spoofed {
+			verdict = "SPOOFED"
+			spoofed++
+		}
+		fmt.Printf("%s  %-10s  score %3d  %-30s  %s\n",
+			entry.ScannedAt.Format(time.RFC3339), verdict, entry.Score, entry.FromDomain, entry.Path)
+	}
+	fmt.Printf("\n%d scan(s), %d spoofed\n", len(entries), spoofed)
+
+	return nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/user/email_spoof_detection/detector"
+	"github.com/user/email_spoof_detection/models"
+	"github.com/user/email_spoof_detection/utils"
+)
+
+// runReport implements the "report" subcommand
+func runReport(args []string) error {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	dirPath := fs.String("dir", "", "Path to a directory of email files to summarize")
+	inputFormat := fs.String("input-format", utils.FormatAuto, "Input format: \"eml\", \"gmail\" or \"graph\" API JSON, or \"auto\" to detect the format of .json files")
+	sampleSize := fs.Int("sample", 0, "Only analyze this many files, picked at random but reproducibly for a given -sample-seed; 0 analyzes all")
+	sampleSeed := fs.Int64("sample-seed", 1, "Seed of the random selection made by -sample")
+	var detectorOpts detectorOptions
+	detectorOpts.register(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if *dirPath == "" {
+		return errors.New("you must specify the -dir flag")
+	}
+
+	entries, err := os.ReadDir(*dirPath)
+	if err != nil {
+		return fmt.Errorf("reading directory: %w", err)
+	}
+	files := emailFiles(entries)
+	available := len(files)
+	if *sampleSize > 0 {
+		files = sampleFiles(files, *sampleSize, *sampleSeed)
+	}
+
+	spfDetector, err := detectorOpts.newDetector()
+	if err != nil {
+		return err
+	}
+
+	total, spoofed, advisory, failed := 0, 0, 0, 0
+	ruleCounts := map[string]int{}
+	campaigns := newCampaignTracker()
+
+	for _, file := range files {
+		fullPath := filepath.Join(*dirPath, file.Name())
+		emailData, err := os.ReadFile(fullPath)
+		if err != nil {
+			log.Printf("Error reading file %s: %v\n", fullPath, err)
+			failed++
+			continue
+		}
+
+		emailData, err = utils.ConvertToMIME(fullPath, emailData, *inputFormat)
+		if err != nil {
+			log.Printf("Error converting email %s: %v\n", fullPath, err)
+			failed++
+			continue
+		}
+
+		email, results, err := analyzeEmail(context.Background(), spfDetector, emailData)
+		if err != nil {
+			log.Printf("Error parsing email %s: %v\n", fullPath, err)
+			failed++
+			continue
+		}
+
+		total++
+		switch results.Verdict {
+		case models.VerdictSpoofed:
+			spoofed++
+		case models.VerdictAdvisory:
+			advisory++
+		}
+		for _, finding := range results.Findings {
+			ruleCounts[finding.Rule]++
+		}
+		campaigns.add(fullPath, email, results)
+	}
+
+	if *sampleSize > 0 {
+		fmt.Printf("Sampled:         %d of %d file(s), seed %d\n", len(files), available, *sampleSeed)
+	}
+	fmt.Printf("Ruleset version: %s\n", detector.RulesetVersion)
+	fmt.Printf("Emails analyzed: %d\n", total)
+	fmt.Printf("Spoofed:         %d\n", spoofed)
+	fmt.Printf("Advisory:        %d\n", advisory)
+	fmt.Printf("Legitimate:      %d\n", total-spoofed-advisory)
+	fmt.Printf("Failed:          %d\n", failed)
+
+	if len(ruleCounts) > 0 {
+		fmt.Println("\nMost common findings:")
+		for _, rule := range sortedByCount(ruleCounts) {
+			fmt.Printf("  %4d  %s\n", ruleCounts[rule], rule)
+		}
+	}
+
+	// Emails sharing a sending artifact likely come from the same tool
+	if shared := campaigns.campaigns(); len(shared) > 0 {
+		fmt.Println("\nShared sending artifacts (possible campaigns):")
+		for _, group := range shared {
+			fmt.Printf("  %s %q: %d email(s), %d with spoof signals\n",
+				group.artifact.kind, group.artifact.value, group.total, len(group.suspicious))
+			for _, path := range group.suspicious {
+				fmt.Printf("    %s\n", path)
+			}
+		}
+	}
+
+	return nil
+}
+
+// sortedByCount returns the keys of counts ordered by descending count, then alphabetically
+func sortedByCount(counts map[string]int) []string {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+
+	return keys
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/user/email_spoof_detection/detector"
+	"github.com/user/email_spoof_detection/models"
+)
+
+// ruleInfo is the exported representation of a detection rule
+type ruleInfo struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Weight      int             `json:"weight"`
+	Severity    models.Severity `json:"severity"`
+}
+
+// runRules implements the "rules" subcommand
+func runRules(args []string) error {
+	fs := flag.NewFlagSet("rules", flag.ExitOnError)
+	export := fs.Bool("export", false, "Export the rules as JSON")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	rules := detector.Catalog()
+
+	if *export {
+		infos := make([]ruleInfo, 0, len(rules))
+		for _, rule := range rules {
+			infos = append(infos, ruleInfo{
+				Name:        rule.Name,
+				Description: rule.Description,
+				Weight:      rule.Weight,
+				Severity:    rule.Severity,
+			})
+		}
+
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(infos)
+	}
+
+	width := 0
+	for _, rule := range rules {
+		if len(rule.Name) > width {
+			width = len(rule.Name)
+		}
+	}
+	for _, rule := range rules {
+		fmt.Printf("%-*s %2d  %-8s  %s\n", width, rule.Name, rule.Weight, rule.Severity, rule.Description)
+	}
+
+	return nil
+}
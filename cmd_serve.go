@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/user/email_spoof_detection/alert"
+	"github.com/user/email_spoof_detection/detector"
+)
+
+// maxRequestSize limits the size of emails accepted by the HTTP server
+const maxRequestSize = 25 << 20
+
+// runServe implements the "serve" subcommand
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("addr", ":8080", "Address to listen on")
+	var detectorOpts detectorOptions
+	detectorOpts.register(fs)
+	var alertOpts alertOptions
+	alertOpts.register(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	spfDetector, err := detectorOpts.newDetector()
+	if err != nil {
+		return err
+	}
+
+	notifier, err := alertOpts.newNotifier()
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/analyze", analyzeHandler(spfDetector, notifier))
+
+	log.Printf("Listening on %s", *addr)
+	return http.ListenAndServe(*addr, mux)
+}
+
+// analyzeHandler returns an HTTP handler that analyzes a raw email posted as the request body
+// and responds with the analysis result as JSON, alerting on spoofed email, or logging every
+// verdict to syslog, when notifier is set
+func analyzeHandler(spfDetector *detector.SpoofDetector, notifier *alert.Notifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		emailData, err := io.ReadAll(io.LimitReader(r.Body, maxRequestSize))
+		if err != nil {
+			http.Error(w, "error reading request body", http.StatusBadRequest)
+			return
+		}
+
+		email, results, err := analyzeEmail(r.Context(), spfDetector, emailData)
+		if err != nil {
+			http.Error(w, "error parsing email: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if notifier != nil {
+			notifier.Notify(alert.New(r.RemoteAddr, email, results))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(results); err != nil {
+			log.Printf("Error writing response: %v", err)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/user/email_spoof_detection/detector"
+	"github.com/user/email_spoof_detection/models"
+	"github.com/user/email_spoof_detection/utils"
+)
+
+// compareColumnWidth is the width at which values are cut in the side-by-side comparison
+const compareColumnWidth = 40
+
+// comparedEmail is one of the two emails of a comparison with its analysis
+type comparedEmail struct {
+	name   string
+	email  *models.Email
+	result *models.AnalysisResult
+}
+
+// compareRow is a line of the side-by-side comparison
+type compareRow struct {
+	field string
+	a, b  string
+}
+
+// runCompare analyzes two emails, typically a known-good message and a suspicious
+// one claiming the same sender, and prints their headers, authentication results
+// and findings side by side, marking the rows that differ with "*"
+func runCompare(ctx context.Context, spfDetector *detector.SpoofDetector, pathA, pathB string, opts analyzeOptions) error {
+	a, err := loadCompared(ctx, spfDetector, pathA, opts)
+	if err != nil {
+		return err
+	}
+	b, err := loadCompared(ctx, spfDetector, pathB, opts)
+	if err != nil {
+		return err
+	}
+
+	if opts.redact {
+		ra, rb := newRedactor(a.email, opts.internalDomains), newRedactor(b.email, opts.internalDomains)
+		a.email, a.result = ra.email(a.email), ra.result(a.result)
+		b.email, b.result = rb.email(b.email), rb.result(b.result)
+	}
+
+	printCompareSection("Headers", a, b, compareHeaders(a.email, b.email))
+	printCompareSection("Authentication", a, b, compareAuthentication(a.result, b.result))
+	printCompareSection("Verdict", a, b, []compareRow{
+		{"Verdict", string(a.result.Verdict), string(b.result.Verdict)},
+		{"Score", fmt.Sprintf("%d of %d", a.result.Score, a.result.Threshold), fmt.Sprintf("%d of %d", b.result.Score, b.result.Threshold)},
+	})
+	printCompareSection("Findings", a, b, compareFindings(a.result.Findings, b.result.Findings))
+	return nil
+}
+
+// loadCompared reads, parses and analyzes one email of a comparison
+func loadCompared(ctx context.Context, spfDetector *detector.SpoofDetector, path string, opts analyzeOptions) (comparedEmail, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return comparedEmail{}, fmt.Errorf("reading %s: %w", path, err)
+	}
+	data, err = utils.ConvertToMIME(path, data, opts.inputFormat)
+	if err != nil {
+		return comparedEmail{}, fmt.Errorf("converting %s: %w", path, err)
+	}
+
+	email, result, err := analyzeEmail(ctx, spfDetector, data)
+	if err != nil {
+		return comparedEmail{}, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return comparedEmail{name: filepath.Base(path), email: email, result: result}, nil
+}
+
+// compareHeaders returns the sender headers of both emails, the fields parsed
+// from them and the header fields only one of them has
+func compareHeaders(a, b *models.Email) []compareRow {
+	rows := []compareRow{
+		{"From", formatAddress(a.From), formatAddress(b.From)},
+		{"Reply-To", formatAddress(a.ReplyTo), formatAddress(b.ReplyTo)},
+		{"Return-Path", a.ReturnPath, b.ReturnPath},
+		{"Sender", a.GetHeaderValue("Sender"), b.GetHeaderValue("Sender")},
+		{"Message-ID domain", models.GetMessageIDDomain(a.MessageID), models.GetMessageIDDomain(b.MessageID)},
+		{"Subject", a.Subject, b.Subject},
+		{"Mailer", a.Mailer, b.Mailer},
+		{"Received headers", strconv.Itoa(len(a.GetAllHeaderValues("Received"))), strconv.Itoa(len(b.GetAllHeaderValues("Received")))},
+		{"DKIM signers", dkimSigners(a), dkimSigners(b)},
+		{"Content-Type", a.GetHeaderValue("Content-Type"), b.GetHeaderValue("Content-Type")},
+		{"Links", strconv.Itoa(len(a.Links)), strconv.Itoa(len(b.Links))},
+		{"Attachments", strconv.Itoa(len(a.Attachments())), strconv.Itoa(len(b.Attachments()))},
+	}
+
+	names := map[string]bool{}
+	for name := range a.Headers {
+		names[name] = true
+	}
+	for name := range b.Headers {
+		names[name] = true
+	}
+	var onlyA, onlyB []string
+	for name := range names {
+		switch {
+		case !b.HasHeader(name):
+			onlyA = append(onlyA, name)
+		case !a.HasHeader(name):
+			onlyB = append(onlyB, name)
+		}
+	}
+	sort.Strings(onlyA)
+	sort.Strings(onlyB)
+	for _, name := range onlyA {
+		rows = append(rows, compareRow{name, "present", ""})
+	}
+	for _, name := range onlyB {
+		rows = append(rows, compareRow{name, "", "present"})
+	}
+
+	return rows
+}
+
+// compareAuthentication returns the authentication results and sending server of both emails
+func compareAuthentication(a, b *models.AnalysisResult) []compareRow {
+	origin := func(result *models.AnalysisResult) string {
+		if result.Origin == nil {
+			return ""
+		}
+		value := result.Origin.IP
+		if result.Origin.Country != "" {
+			value += " " + result.Origin.Country
+		}
+		if result.Origin.ASN != 0 {
+			value += fmt.Sprintf(" AS%d", result.Origin.ASN)
+		}
+		return value
+	}
+
+	return []compareRow{
+		{"SPF", a.Authentication.SPF, b.Authentication.SPF},
+		{"DKIM", a.Authentication.DKIM, b.Authentication.DKIM},
+		{"DMARC", a.Authentication.DMARC, b.Authentication.DMARC},
+		{"PGP", a.Authentication.PGP, b.Authentication.PGP},
+		{"BIMI", a.Authentication.BIMI, b.Authentication.BIMI},
+		{"Origin", origin(a), origin(b)},
+	}
+}
+
+// compareFindings returns a row per rule that fired for either email, with the
+// severity and weight of its finding
+func compareFindings(a, b []models.Finding) []compareRow {
+	describe := func(findings []models.Finding) map[string]string {
+		described := map[string]string{}
+		for _, finding := range findings {
+			value := fmt.Sprintf("%s %d", finding.Severity, finding.Weight)
+			if previous, exists := described[finding.Rule]; exists {
+				value = previous + ", " + value
+			}
+			described[finding.Rule] = value
+		}
+		return described
+	}
+	describedA, describedB := describe(a), describe(b)
+
+	var rules []string
+	for rule := range describedA {
+		rules = append(rules, rule)
+	}
+	for rule := range describedB {
+		if _, exists := describedA[rule]; !exists {
+			rules = append(rules, rule)
+		}
+	}
+	sort.Strings(rules)
+
+	rows := make([]compareRow, 0, len(rules))
+	for _, rule := range rules {
+		rows = append(rows, compareRow{rule, describedA[rule], describedB[rule]})
+	}
+	return rows
+}
+
+// printCompareSection prints a titled table of rows side by side, marking the rows whose values differ
+func printCompareSection(title string, a, b comparedEmail, rows []compareRow) {
+	fieldWidth := len("Field")
+	for _, row := range rows {
+		if len(row.field) > fieldWidth {
+			fieldWidth = len(row.field)
+		}
+	}
+
+	fmt.Printf("%s\n", title)
+	fmt.Printf("  %-*s  %-*s  %s\n", fieldWidth, "", compareColumnWidth, cutValue(a.name), cutValue(b.name))
+	for _, row := range rows {
+		marker := " "
+		if row.a != row.b {
+			marker = "*"
+		}
+		fmt.Printf("%s %-*s  %-*s  %s\n", marker, fieldWidth, row.field,
+			compareColumnWidth, orDash(cutValue(row.a)), orDash(cutValue(row.b)))
+	}
+	fmt.Println()
+}
+
+// formatAddress returns an address as it would appear in a header, or an empty string for none
+func formatAddress(address *mail.Address) string {
+	if address == nil {
+		return ""
+	}
+	if address.Name == "" {
+		return address.Address
+	}
+	return address.Name + " <" + address.Address + ">"
+}
+
+// dkimSigners lists the d= domains of the DKIM signatures of an email
+func dkimSigners(email *models.Email) string {
+	var signers []string
+	for _, value := range email.GetAllHeaderValues("DKIM-Signature") {
+		for _, tag := range strings.Split(value, ";") {
+			name, domain, found := strings.Cut(strings.TrimSpace(tag), "=")
+			if found && strings.TrimSpace(name) == "d" {
+				signers = append(signers, strings.ToLower(strings.TrimSpace(domain)))
+			}
+		}
+	}
+	return strings.Join(signers, ", ")
+}
+
+// cutValue shortens a value to fit in a comparison column
+func cutValue(value string) string {
+	value = strings.Join(strings.Fields(value), " ")
+	if len([]rune(value)) <= compareColumnWidth {
+		return value
+	}
+	return string([]rune(value)[:compareColumnWidth-3]) + "..."
+}
+
+// orDash shows a missing value as "-"
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
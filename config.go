@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// parseFlags registers the -config flag on a subcommand's flag set and parses its
+// arguments. Options in the config file take effect unless the same flag is given
+// on the command line.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	configPath := fs.String("config", "", "Path to a JSON config file setting any of these flags; command-line flags override it")
+	if collectFlags != nil {
+		collectFlags(fs)
+		return errFlagsCollected
+	}
+	fs.Parse(args)
+
+	if *configPath == "" {
+		return nil
+	}
+	return applyConfigFile(fs, *configPath)
+}
+
+// collectFlags, when set, is passed the flag set of a subcommand in place of
+// parsing its arguments, which makes the subcommand return errFlagsCollected
+var collectFlags func(fs *flag.FlagSet)
+
+var errFlagsCollected = errors.New("flags collected")
+
+// configKeys returns the flag names of every subcommand, which are the keys a
+// config file may set
+func configKeys() map[string]bool {
+	keys := map[string]bool{}
+	collectFlags = func(fs *flag.FlagSet) {
+		fs.VisitAll(func(f *flag.Flag) {
+			keys[f.Name] = true
+		})
+	}
+	defer func() { collectFlags = nil }()
+
+	for _, cmd := range commands() {
+		cmd.Run(nil)
+	}
+	return keys
+}
+
+// applyConfigFile sets the flags named in a JSON config file that weren't given on
+// the command line. Keys are flag names without the leading dash; values may be
+// strings, numbers, booleans or lists of strings, which are joined with commas.
+// Keys for flags of other subcommands are ignored so one file can serve them all,
+// but a key no subcommand has, such as a misspelled flag, is an error.
+func applyConfigFile(fs *flag.FlagSet, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+
+	var options map[string]json.RawMessage
+	if err := json.Unmarshal(data, &options); err != nil {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	known := configKeys()
+	for name, raw := range options {
+		if !known[name] {
+			return fmt.Errorf("config file %s: unknown option %q", path, name)
+		}
+		if name == "config" || explicit[name] || fs.Lookup(name) == nil {
+			continue
+		}
+
+		value, err := configValue(raw)
+		if err != nil {
+			return fmt.Errorf("config file %s: option %q: %w", path, name, err)
+		}
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("config file %s: option %q: %w", path, name, err)
+		}
+	}
+
+	return nil
+}
+
+// configValue converts a JSON config value to the text form a flag accepts
+func configValue(raw json.RawMessage) (string, error) {
+	var value interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return "", err
+	}
+
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case bool, float64:
+		// Numbers keep their literal form, so 5 doesn't become "5e+00"
+		return strings.TrimSpace(string(raw)), nil
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			text, ok := item.(string)
+			if !ok {
+				return "", fmt.Errorf("list items must be strings")
+			}
+			items[i] = text
+		}
+		return strings.Join(items, ","), nil
+	default:
+		return "", fmt.Errorf("unsupported value %s", raw)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeConfig writes a config file to a temporary directory and returns its path
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestApplyConfigFile(t *testing.T) {
+	fs := flag.NewFlagSet("report", flag.ContinueOnError)
+	dir := fs.String("dir", "", "")
+	var detectorOpts detectorOptions
+	detectorOpts.register(fs)
+
+	// -addr belongs to serve and -export to rules, so the report subcommand skips them
+	path := writeConfig(t, `{"dir": "mail", "threshold": 7, "my-domains": ["example.com", "example.org"], "addr": ":9090", "export": true}`)
+	if err := parseFlags(fs, []string{"-config", path, "-threshold", "4"}); err != nil {
+		t.Fatal(err)
+	}
+	if *dir != "mail" {
+		t.Errorf("-dir = %q, want the config file's", *dir)
+	}
+	if detectorOpts.threshold != 4 {
+		t.Errorf("-threshold = %d, want the command line's", detectorOpts.threshold)
+	}
+	if detectorOpts.myDomains != "example.com,example.org" {
+		t.Errorf("-my-domains = %q, want the list joined", detectorOpts.myDomains)
+	}
+}
+
+func TestApplyConfigFileUnknownOption(t *testing.T) {
+	for _, content := range []string{
+		`{"threshhold": 7}`,
+		`{"dir": "mail", "no-such-flag": true}`,
+	} {
+		fs := flag.NewFlagSet("report", flag.ContinueOnError)
+		fs.String("dir", "", "")
+		var detectorOpts detectorOptions
+		detectorOpts.register(fs)
+
+		err := parseFlags(fs, []string{"-config", writeConfig(t, content)})
+		if err == nil || !strings.Contains(err.Error(), "unknown option") {
+			t.Errorf("%s: error = %v, want an unknown option", content, err)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package detector
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/user/email_spoof_detection/models"
+)
+
+// ActionBand applies an action to email scoring at least MinScore, or, when
+// Severity is set instead, to email with a finding of at least that severity
+type ActionBand struct {
+	Action   models.Action    `json:"action"`
+	MinScore int              `json:"min_score,omitempty"`
+	Severity *models.Severity `json:"severity,omitempty"`
+}
+
+// matches checks if an analysis result falls in the band
+func (b ActionBand) matches(result *models.AnalysisResult) bool {
+	if b.Severity != nil {
+		return result.CountAtLeast(*b.Severity) > 0
+	}
+	return result.Score >= b.MinScore
+}
+
+// ParseActionBands parses a specification such as "tag=3,quarantine=5,reject=critical",
+// mapping each action to the lowest score, or the lowest finding severity, it applies to
+func ParseActionBands(spec string) ([]ActionBand, error) {
+	var bands []ActionBand
+
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		name, value, found := strings.Cut(entry, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid action entry %q, expected action=score or action=severity", entry)
+		}
+
+		action := models.Action(strings.ToLower(strings.TrimSpace(name)))
+		if action.Rank() < 0 {
+			return nil, fmt.Errorf("unknown action %q in entry %q", name, entry)
+		}
+
+		band := ActionBand{Action: action}
+		if score, err := strconv.Atoi(strings.TrimSpace(value)); err == nil {
+			band.MinScore = score
+		} else if severity, err := models.ParseSeverity(value); err == nil {
+			band.Severity = &severity
+		} else {
+			return nil, fmt.Errorf("invalid score or severity in action entry %q", entry)
+		}
+
+		bands = append(bands, band)
+	}
+
+	return bands, nil
+}
+
+// chooseAction records the most severe action whose band an email falls in, or
+// accept when it falls in none. Nothing is recorded without action bands.
+func (d *SpoofDetector) chooseAction(result *models.AnalysisResult) {
+	if len(d.config.Actions) == 0 {
+		return
+	}
+
+	result.Action = models.ActionAccept
+	for _, band := range d.config.Actions {
+		if band.Action.Rank() > result.Action.Rank() && band.matches(result) {
+			result.Action = band.Action
+		}
+	}
+}
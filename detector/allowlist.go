@@ -0,0 +1,94 @@
+package detector
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/user/email_spoof_detection/models"
+)
+
+// AllowlistScope selects the findings suppressed for an allowlisted sender
+type AllowlistScope struct {
+	// Rules limits the suppression to the named rules; every rule is in scope when empty
+	Rules []string `json:"rules,omitempty"`
+
+	// MaxSeverity is the most severe finding suppressed; SeverityLow when unset
+	MaxSeverity *models.Severity `json:"max_severity,omitempty"`
+}
+
+// suppresses checks if a finding is in the scope
+func (s AllowlistScope) suppresses(finding models.Finding) bool {
+	maxSeverity := models.SeverityLow
+	if s.MaxSeverity != nil {
+		maxSeverity = *s.MaxSeverity
+	}
+	if finding.Weight == 0 || finding.Severity > maxSeverity {
+		return false
+	}
+
+	if len(s.Rules) == 0 {
+		return true
+	}
+	for _, rule := range s.Rules {
+		if rule == finding.Rule {
+			return true
+		}
+	}
+	return false
+}
+
+// allowlistScope returns the scoped allowlist entry matching the sender of an email:
+// the From address, or else the most specific matching domain
+func (d *SpoofDetector) allowlistScope(email *models.Email) (string, AllowlistScope, bool) {
+	bestEntry, bestLength := "", -1
+	address := ""
+	if email.From != nil {
+		address = strings.ToLower(email.From.Address)
+	}
+
+	for entry := range d.config.AllowlistScopes {
+		if !allowlistMatches(email, entry) {
+			continue
+		}
+		if strings.ToLower(entry) == address {
+			return entry, d.config.AllowlistScopes[entry], true
+		}
+		if len(entry) > bestLength || len(entry) == bestLength && entry < bestEntry {
+			bestEntry, bestLength = entry, len(entry)
+		}
+	}
+
+	if bestEntry == "" {
+		return "", AllowlistScope{}, false
+	}
+	return bestEntry, d.config.AllowlistScopes[bestEntry], true
+}
+
+// applyAllowlist suppresses the findings in the scope of the allowlist entry of the
+// sender, noting which were suppressed and which, being out of scope, still count
+func (d *SpoofDetector) applyAllowlist(email *models.Email, result *models.AnalysisResult) {
+	entry, scope, found := d.allowlistScope(email)
+	if !found {
+		return
+	}
+
+	suppressed := result.RemoveFindingsFunc(scope.suppresses)
+	if len(suppressed) == 0 {
+		return
+	}
+
+	var kept []string
+	for _, finding := range result.Findings {
+		if finding.Weight > 0 {
+			kept = append(kept, finding.Rule)
+		}
+	}
+
+	message := fmt.Sprintf("Allowlist entry %s suppressed %d finding(s): %s",
+		entry, len(suppressed), strings.Join(suppressed, ", "))
+	if len(kept) > 0 {
+		message = fmt.Sprintf("Allowlist entry %s partially applied: suppressed %s; still counting %s",
+			entry, strings.Join(suppressed, ", "), strings.Join(kept, ", "))
+	}
+	result.AddFinding("allowlist_suppression", models.SeverityInfo, 0, message)
+}
@@ -0,0 +1,67 @@
+package detector
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/user/email_spoof_detection/models"
+)
+
+// LoadHashList reads a list of known-malicious SHA-256 hashes, one per line. Blank
+// lines and "#" comments are skipped, as is anything after the hash on a line, so
+// "hash  filename" listings from sha256sum can be used directly.
+func LoadHashList(path string) (map[string]bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading hash list: %w", err)
+	}
+	defer file.Close()
+
+	hashes := map[string]bool{}
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		hash := strings.ToLower(fields[0])
+		if decoded, err := hex.DecodeString(hash); err != nil || len(decoded) != 32 {
+			return nil, fmt.Errorf("hash list %s line %d: %q isn't a SHA-256 hash", path, line, fields[0])
+		}
+		hashes[hash] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading hash list: %w", err)
+	}
+
+	return hashes, nil
+}
+
+// recordAttachments lists the attachments of an email with their SHA-256 hashes
+// and flags those whose hash is known to be malicious
+func (d *SpoofDetector) recordAttachments(email *models.Email, result *models.AnalysisResult) {
+	var malicious []string
+	for _, part := range email.Attachments() {
+		result.Attachments = append(result.Attachments, models.AttachmentInfo{
+			Filename:    part.Filename,
+			ContentType: part.ContentType,
+			Size:        len(part.Body),
+			SHA256:      part.SHA256,
+		})
+
+		if d.config.MaliciousHashes[part.SHA256] {
+			malicious = append(malicious, part.Filename+" ("+part.SHA256+")")
+		}
+	}
+
+	if len(malicious) == 0 {
+		return
+	}
+
+	result.AddFinding("known_malicious_attachment", models.SeverityCritical, 6,
+		"Email carries attachments with known-malicious hashes: "+strings.Join(malicious, ", "))
+}
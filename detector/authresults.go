@@ -0,0 +1,232 @@
+package detector
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/user/email_spoof_detection/models"
+)
+
+// authResult is one method result of an Authentication-Results header
+type authResult struct {
+	Method     string            // e.g. "spf", "dkim" or "dmarc", lower-cased
+	Result     string            // e.g. "pass" or "fail", lower-cased
+	Properties map[string]string // ptype.property values such as "header.d" and "smtp.mailfrom"
+}
+
+// authResultsHeader holds the parts of an Authentication-Results header
+type authResultsHeader struct {
+	AuthServID string // Receiving server that stamped the results, lower-cased
+	Results    []authResult
+}
+
+// parseAuthenticationResults parses an Authentication-Results header value as
+// described in RFC 8601 section 2.2, e.g.
+// "mx.example.com; spf=pass smtp.mailfrom=example.org; dkim=fail (bad signature) header.d=example.org"
+func parseAuthenticationResults(value string) authResultsHeader {
+	parts := splitAuthResults(value)
+	if len(parts) == 0 {
+		return authResultsHeader{}
+	}
+
+	var header authResultsHeader
+	if fields := strings.Fields(parts[0]); len(fields) > 0 {
+		header.AuthServID = strings.ToLower(fields[0])
+	}
+
+	for _, part := range parts[1:] {
+		fields := strings.Fields(part)
+		if len(fields) == 0 {
+			continue
+		}
+		method, result, found := strings.Cut(fields[0], "=")
+		if !found {
+			// "none" stands for no results at all
+			continue
+		}
+		// The method may carry a version, e.g. "dkim/1"
+		method, _, _ = strings.Cut(method, "/")
+
+		properties := map[string]string{}
+		for _, field := range fields[1:] {
+			if name, value, found := strings.Cut(field, "="); found {
+				properties[strings.ToLower(name)] = strings.Trim(value, "\"")
+			}
+		}
+		header.Results = append(header.Results, authResult{
+			Method:     strings.ToLower(method),
+			Result:     strings.ToLower(strings.Trim(result, "\"")),
+			Properties: properties,
+		})
+	}
+
+	return header
+}
+
+// splitAuthResults splits an Authentication-Results header value at the semicolons
+// between its results, dropping comments and leaving quoted strings intact
+func splitAuthResults(value string) []string {
+	var parts []string
+	var part strings.Builder
+	depth, quoted := 0, false
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c == '\\' && i+1 < len(value):
+			if depth == 0 {
+				part.WriteByte(value[i+1])
+			}
+			i++
+		case quoted:
+			part.WriteByte(c)
+			if c == '"' {
+				quoted = false
+			}
+		case c == '(':
+			depth++
+		case c == ')' && depth > 0:
+			depth--
+		case depth > 0:
+		case c == '"':
+			quoted = true
+			part.WriteByte(c)
+		case c == ';':
+			parts = append(parts, part.String())
+			part.Reset()
+		default:
+			part.WriteByte(c)
+		}
+	}
+	return append(parts, part.String())
+}
+
+// authVerdict reduces a result to "pass" or "fail", or returns an empty string for
+// results such as none, neutral or temperror that don't take a side
+func authVerdict(result string) string {
+	switch result {
+	case "pass":
+		return "pass"
+	case "fail", "softfail", "hardfail", "permerror":
+		return "fail"
+	}
+	return ""
+}
+
+// authStamp is a result stamped by one of the Authentication-Results headers of an email
+type authStamp struct {
+	Position   int // Header position, counting from the most recent (1)
+	AuthServID string
+	Result     string
+}
+
+// describe formats a stamp for a finding, e.g. "spf=pass from mx.example.com (header 2)"
+func (s authStamp) describe(method string) string {
+	return fmt.Sprintf("%s=%s from %s (header %d)", method, s.Result, s.AuthServID, s.Position)
+}
+
+// checkConflictingAuthResults flags Authentication-Results headers that disagree
+// on the outcome of a method, such as one stamping spf=pass and another spf=fail.
+// Receiving servers add their stamp on top, so a disagreeing stamp further down
+// was likely put there by the sender to pass as a trusted verdict. The first stamp
+// of a configured trusted authserv-id, or else the most recent stamp, is taken as
+// the verdict the others are compared with.
+func (d *SpoofDetector) checkConflictingAuthResults(email *models.Email, result *models.AnalysisResult) {
+	headers := email.GetAllHeaderValues("Authentication-Results")
+	if len(headers) < 2 {
+		return
+	}
+
+	var methods []string
+	stamps := map[string][]authStamp{}
+	for i, value := range headers {
+		header := parseAuthenticationResults(value)
+		for _, r := range header.Results {
+			if authVerdict(r.Result) == "" {
+				continue
+			}
+			// A message may carry several DKIM signatures, each with its own result
+			method := r.Method
+			if r.Method == "dkim" && r.Properties["header.d"] != "" {
+				method += " header.d=" + strings.ToLower(r.Properties["header.d"])
+				if selector := r.Properties["header.s"]; selector != "" {
+					method += " header.s=" + strings.ToLower(selector)
+				}
+			}
+			if _, seen := stamps[method]; !seen {
+				methods = append(methods, method)
+			}
+			stamps[method] = append(stamps[method], authStamp{Position: i + 1, AuthServID: header.AuthServID, Result: r.Result})
+		}
+	}
+
+	var conflicts []string
+	for _, method := range methods {
+		reference, trusted := stamps[method][0], false
+		for _, stamp := range stamps[method] {
+			if d.isTrustedAuthServID(stamp.AuthServID) {
+				reference, trusted = stamp, true
+				break
+			}
+		}
+
+		var disagreeing []string
+		for _, stamp := range stamps[method] {
+			if authVerdict(stamp.Result) != authVerdict(reference.Result) {
+				disagreeing = append(disagreeing, stamp.describe(method))
+			}
+		}
+		if len(disagreeing) == 0 {
+			continue
+		}
+
+		conflict := reference.describe(method)
+		if trusted {
+			conflict += ", the trusted receiving server,"
+		}
+		conflicts = append(conflicts, conflict+" contradicted by "+strings.Join(disagreeing, ", "))
+	}
+
+	if len(conflicts) == 0 {
+		return
+	}
+
+	result.AddFinding("conflicting_authentication_results", models.SeverityHigh, 3,
+		"Authentication-Results headers disagree: "+strings.Join(conflicts, "; "))
+}
+
+// isTrustedAuthServID checks if an authserv-id is one of the organization's receiving servers
+func (d *SpoofDetector) isTrustedAuthServID(id string) bool {
+	for _, trusted := range d.config.TrustedAuthServIDs {
+		if strings.EqualFold(id, trusted) {
+			return true
+		}
+	}
+	return false
+}
+
+// trustedAuthentication returns the DKIM or DMARC result that authenticates the
+// From domain in the Authentication-Results headers stamped by a trusted receiving
+// server, such as "dmarc=pass", or an empty string. Only the most recent trusted
+// header is read; stamps of other servers are ignored, as anyone can add them.
+func (d *SpoofDetector) trustedAuthentication(email *models.Email, fromDomain string) string {
+	for _, value := range email.GetAllHeaderValues("Authentication-Results") {
+		header := parseAuthenticationResults(value)
+		if !d.isTrustedAuthServID(header.AuthServID) {
+			continue
+		}
+
+		for _, r := range header.Results {
+			if r.Result != "pass" {
+				continue
+			}
+			switch {
+			case r.Method == "dmarc" && isRelatedDomain(r.Properties["header.from"], fromDomain):
+				return "dmarc=pass"
+			case r.Method == "dkim" && isRelatedDomain(r.Properties["header.d"], fromDomain):
+				return "dkim=pass header.d=" + strings.ToLower(r.Properties["header.d"])
+			}
+		}
+		return ""
+	}
+	return ""
+}
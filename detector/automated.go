@@ -0,0 +1,52 @@
+package detector
+
+import (
+	"strings"
+
+	"github.com/user/email_spoof_detection/models"
+)
+
+// bulkPrecedences are Precedence values used by mailing lists and automated senders
+var bulkPrecedences = map[string]bool{"bulk": true, "list": true, "junk": true}
+
+// automatedHeader returns the header marking an email as automated mail, such as
+// "Auto-Submitted: auto-generated" or "Precedence: bulk", or an empty string
+func automatedHeader(email *models.Email) string {
+	if value := strings.TrimSpace(email.GetHeaderValue("Auto-Submitted")); value != "" {
+		// RFC 3834: "no" marks mail written by a person; parameters may follow a semicolon
+		keyword := strings.ToLower(strings.TrimSpace(strings.SplitN(value, ";", 2)[0]))
+		if keyword != "no" {
+			return "Auto-Submitted: " + value
+		}
+	}
+
+	if value := strings.TrimSpace(email.GetHeaderValue("Precedence")); bulkPrecedences[strings.ToLower(value)] {
+		return "Precedence: " + value
+	}
+
+	return ""
+}
+
+// checkAutomatedBrandMail flags email from, or impersonating, a brand that claims
+// to be automated system mail without an aligned DKIM signature. Brands send their
+// notifications through signed mail platforms, so an unsigned "automated
+// notification" is more likely a phishing email mimicking one.
+func (d *SpoofDetector) checkAutomatedBrandMail(email *models.Email, result *models.AnalysisResult) {
+	dkim := result.Authentication.DKIM
+	if email.From == nil || dkim == "" || dkim == DKIMUnverified {
+		return
+	}
+
+	header := automatedHeader(email)
+	if header == "" {
+		return
+	}
+
+	brand := d.brandOf(email)
+	if brand == "" {
+		return
+	}
+
+	result.AddFinding("unauthenticated_automated_mail", models.SeverityMedium, 2,
+		"Email from "+brand+" claims to be automated mail ("+header+") but has no valid aligned DKIM signature (DKIM: "+dkim+")")
+}
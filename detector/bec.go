@@ -0,0 +1,82 @@
+package detector
+
+import (
+	"strings"
+
+	"github.com/user/email_spoof_detection/models"
+)
+
+// DefaultUrgencyKeywords are the subject keywords that signal pressure to act quickly
+var DefaultUrgencyKeywords = []string{
+	"urgent",
+	"immediately",
+	"asap",
+	"wire transfer",
+	"payment",
+	"invoice",
+	"overdue",
+	"confidential",
+	"gift card",
+	"action required",
+}
+
+// freeMailDomains lists free email providers that anyone can register an address with
+var freeMailDomains = map[string]bool{
+	"gmail.com":      true,
+	"googlemail.com": true,
+	"yahoo.com":      true,
+	"outlook.com":    true,
+	"hotmail.com":    true,
+	"live.com":       true,
+	"msn.com":        true,
+	"aol.com":        true,
+	"icloud.com":     true,
+	"gmx.com":        true,
+	"mail.com":       true,
+	"proton.me":      true,
+	"protonmail.com": true,
+	"yandex.com":     true,
+	"zoho.com":       true,
+}
+
+// IsFreeMailDomain checks if a domain belongs to a free email provider
+func IsFreeMailDomain(domain string) bool {
+	return freeMailDomains[strings.ToLower(domain)]
+}
+
+// checkUrgentFreeMailReplyTo flags replies redirected to a free mail address, escalating
+// when the subject also pushes for urgency as business email compromise does
+func (d *SpoofDetector) checkUrgentFreeMailReplyTo(email *models.Email, result *models.AnalysisResult) {
+	if !d.config.CheckUrgentReplyTo || email.ReplyTo == nil {
+		return
+	}
+
+	replyToDomain := strings.ToLower(models.GetDomain(email.ReplyTo))
+	fromDomain := strings.ToLower(models.GetDomain(email.From))
+	// Free mail users replying to themselves are expected
+	if !freeMailDomains[replyToDomain] || replyToDomain == fromDomain {
+		return
+	}
+
+	keywords := d.config.UrgencyKeywords
+	if len(keywords) == 0 {
+		keywords = DefaultUrgencyKeywords
+	}
+
+	subject := strings.ToLower(cleanText(email.Subject))
+	var matched []string
+	for _, keyword := range keywords {
+		if strings.Contains(subject, strings.ToLower(keyword)) {
+			matched = append(matched, keyword)
+		}
+	}
+
+	if len(matched) == 0 {
+		result.AddFinding("freemail_reply_to", models.SeverityLow, 1,
+			"Reply-To uses free mail provider "+replyToDomain+" instead of the From domain")
+		return
+	}
+
+	result.AddFinding("urgent_freemail_reply_to", models.SeverityHigh, 4,
+		"Reply-To uses free mail provider "+replyToDomain+" and the subject is urgent ("+strings.Join(matched, ", ")+")")
+}
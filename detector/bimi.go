@@ -0,0 +1,108 @@
+package detector
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/user/email_spoof_detection/models"
+)
+
+// BIMI states recorded in the authentication results
+const (
+	BIMIPresent       = "present"      // Valid record, with the DMARC enforcement BIMI requires
+	BIMIAbsent        = "absent"       // No record for the selector
+	BIMIInvalid       = "invalid"      // Record without a logo (l=) or evidence (a=) location
+	BIMINotEnforced   = "not-enforced" // Record published, but DMARC isn't at quarantine or reject
+	BIMILookupFailure = "error"        // The record couldn't be looked up
+)
+
+// checkBIMI looks up the BIMI record of a From domain belonging to a brand and
+// notes, without adding to the score, when the record is absent, invalid or
+// published without the DMARC enforcement BIMI depends on. It runs after the
+// DMARC check, whose policy it reuses.
+func (d *SpoofDetector) checkBIMI(ctx context.Context, email *models.Email, result *models.AnalysisResult) {
+	domain := strings.ToLower(strings.TrimSuffix(models.GetDomain(email.From), "."))
+	if domain == "" || !d.isBrandSender(domain) || !d.selected("bimi") {
+		return
+	}
+	if d.config.Offline {
+		result.NotEvaluated = append(result.NotEvaluated, "bimi")
+		return
+	}
+
+	selector := bimiSelector(email)
+	record, location, err := d.lookupBIMI(ctx, selector, domain)
+	if err != nil {
+		log.Printf("BIMI lookup error for %s: %v", location, err)
+		result.Authentication.BIMI = BIMILookupFailure
+		result.AddDiagnostic("BIMI lookup failed for " + location + ": " + err.Error())
+		return
+	}
+
+	state, message := BIMIPresent, ""
+	tags := parseDMARCTags(record)
+	switch dmarc := result.Authentication.DMARC; {
+	case record == "":
+		state, message = BIMIAbsent, "Brand domain "+domain+" doesn't publish a BIMI record ("+location+")"
+	case tags["l"] == "" && tags["a"] == "":
+		state, message = BIMIInvalid, "BIMI record "+location+" has neither a logo (l=) nor an evidence (a=) location"
+	case dmarc != "quarantine" && dmarc != "reject":
+		state, message = BIMINotEnforced, "BIMI record "+location+" is published, but the DMARC policy ("+dmarc+") "+
+			"isn't at the quarantine or reject enforcement BIMI requires"
+	}
+
+	result.Authentication.BIMI = state
+	if message != "" {
+		result.AddFinding("bimi", models.SeverityInfo, 0, message)
+	}
+}
+
+// isBrandSender checks if a domain belongs to one of the brands
+func (d *SpoofDetector) isBrandSender(domain string) bool {
+	for _, legitimate := range d.config.BrandDomains {
+		if isBrandDomain(domain, legitimate) {
+			return true
+		}
+	}
+	return false
+}
+
+// bimiSelector returns the selector named by the BIMI-Selector header, or "default"
+func bimiSelector(email *models.Email) string {
+	tags := parseDMARCTags(email.GetHeaderValue("BIMI-Selector"))
+	if selector := strings.ToLower(tags["s"]); selector != "" && strings.EqualFold(tags["v"], "BIMI1") {
+		return selector
+	}
+	return "default"
+}
+
+// lookupBIMI returns the BIMI record of a domain, falling back to its
+// organizational domain, along with the name it was looked up at. The record is
+// empty if neither publishes one.
+func (d *SpoofDetector) lookupBIMI(ctx context.Context, selector, domain string) (string, string, error) {
+	domains := []string{domain}
+	if orgDomain := baseDomain(domain); orgDomain != domain {
+		domains = append(domains, orgDomain)
+	}
+
+	location := ""
+	for _, candidate := range domains {
+		location = selector + "._bimi." + candidate
+		txtRecords, err := d.resolver.LookupTXT(ctx, location)
+		if err != nil {
+			if isNotFound(err) {
+				continue
+			}
+			return "", location, err
+		}
+
+		for _, record := range txtRecords {
+			if strings.HasPrefix(record, "v=BIMI1") {
+				return record, location, nil
+			}
+		}
+	}
+
+	return "", selector + "._bimi." + domain, nil
+}
@@ -0,0 +1,122 @@
+package detector
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/user/email_spoof_detection/models"
+)
+
+// blocklistStatus is the outcome of querying a domain blocklist zone
+type blocklistStatus int
+
+const (
+	blocklistNotListed blocklistStatus = iota
+	blocklistListed
+	blocklistFailed
+)
+
+// blocklistCache remembers blocklist answers per domain and zone, as blocklists
+// rate limit their users
+type blocklistCache struct {
+	mu      sync.Mutex
+	results map[string]blocklistStatus
+}
+
+// checkDomainBlocklists queries the From domain against the configured domain
+// blocklist (RHSBL) zones, flagging listings and noting lookups that failed
+func (d *SpoofDetector) checkDomainBlocklists(ctx context.Context, email *models.Email, result *models.AnalysisResult) {
+	domain := strings.ToLower(models.GetDomain(email.From))
+	zones := d.config.DomainBlocklists
+	if domain == "" || len(zones) == 0 || !d.selected("blocklisted_from_domain") {
+		return
+	}
+	if d.config.Offline {
+		result.NotEvaluated = append(result.NotEvaluated, "domain_blocklist")
+		return
+	}
+
+	statuses := make([]blocklistStatus, len(zones))
+	var group errgroup.Group
+	for i, zone := range zones {
+		i, zone := i, strings.ToLower(strings.Trim(zone, "."))
+		group.Go(func() error {
+			statuses[i] = d.queryBlocklist(ctx, domain, zone)
+			return nil
+		})
+	}
+	group.Wait()
+
+	var listed, failed []string
+	for i, status := range statuses {
+		switch status {
+		case blocklistListed:
+			listed = append(listed, zones[i])
+		case blocklistFailed:
+			failed = append(failed, zones[i])
+		}
+	}
+
+	if len(listed) > 0 {
+		result.AddFinding("blocklisted_from_domain", models.SeverityHigh, 4,
+			"From domain "+domain+" is listed on "+strings.Join(listed, ", "))
+	}
+	if len(failed) > 0 {
+		result.AddDiagnostic("Couldn't query " + strings.Join(failed, ", ") + " for From domain " + domain)
+	}
+}
+
+// queryBlocklist looks up domain.zone, using the cached answer when there is one
+func (d *SpoofDetector) queryBlocklist(ctx context.Context, domain, zone string) blocklistStatus {
+	key := domain + "." + zone
+
+	d.blocklists.mu.Lock()
+	status, cached := d.blocklists.results[key]
+	d.blocklists.mu.Unlock()
+	if cached {
+		return status
+	}
+
+	ips, err := d.resolver.LookupIP(ctx, "ip4", key)
+	switch {
+	case err != nil && isNotFound(err):
+		status = blocklistNotListed
+	case err != nil:
+		status = blocklistFailed
+	default:
+		status = blocklistStatusOf(ips)
+	}
+
+	// Failures aren't cached so a later email can retry
+	if status != blocklistFailed {
+		d.blocklists.mu.Lock()
+		if d.blocklists.results == nil {
+			d.blocklists.results = map[string]blocklistStatus{}
+		}
+		d.blocklists.results[key] = status
+		d.blocklists.mu.Unlock()
+	}
+
+	return status
+}
+
+// blocklistStatusOf interprets the A records of a blocklist answer: 127.0.0.0/8
+// means listed, except 127.255.255.0/24, which blocklists such as Spamhaus use
+// to report errors like queries through public resolvers
+func blocklistStatusOf(ips []net.IP) blocklistStatus {
+	for _, ip := range ips {
+		ip4 := ip.To4()
+		if ip4 == nil || ip4[0] != 127 {
+			continue
+		}
+		if ip4[1] == 255 && ip4[2] == 255 {
+			return blocklistFailed
+		}
+		return blocklistListed
+	}
+	return blocklistNotListed
+}
@@ -0,0 +1,51 @@
+package detector
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/user/email_spoof_detection/models"
+)
+
+// Bounce handling modes
+const (
+	BounceRelaxed = "relaxed" // Drop the bounceRelaxedRules findings of bounce messages
+	BounceStrict  = "strict"  // Treat bounce messages like any other email
+)
+
+// bounceRelaxedRules lists the rules that misfire on bounces: delivery status
+// notifications come from the mail system rather than the From address, and
+// quote the original message with its links, subject and thread headers
+var bounceRelaxedRules = []string{
+	"inconsistent_from_reply_to",
+	"forged_thread_headers",
+	"brand_mention",
+	"suspicious_links",
+	"body_brand_contact",
+	"content_type_mismatch",
+}
+
+// isBounce checks if an email is a delivery status notification or was sent
+// with the null return path used for bounces
+func isBounce(email *models.Email) bool {
+	return email.DeliveryReport || email.NullReturnPath
+}
+
+// relaxBounce drops the findings of rules that misfire on bounce messages and
+// notes that bounce handling was applied, unless bounces are handled strictly
+func (d *SpoofDetector) relaxBounce(email *models.Email, result *models.AnalysisResult) {
+	if d.config.BounceHandling == BounceStrict || !isBounce(email) {
+		return
+	}
+
+	kind := "null return path"
+	if email.DeliveryReport {
+		kind = "delivery status notification"
+	}
+
+	message := "Bounce message (" + kind + "); relaxed rule set applied"
+	if dropped := result.RemoveFindings(bounceRelaxedRules...); len(dropped) > 0 {
+		message += fmt.Sprintf(", ignoring %s", strings.Join(dropped, ", "))
+	}
+	result.AddFinding("bounce_message", models.SeverityInfo, 0, message)
+}
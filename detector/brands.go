@@ -0,0 +1,419 @@
+package detector
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/idna"
+
+	"github.com/user/email_spoof_detection/models"
+)
+
+// DefaultBrandDomains maps frequently impersonated brands to the domains they
+// legitimately send email from
+var DefaultBrandDomains = map[string][]string{
+	"amazon":          {"amazon.com", "amazon.co.uk", "amazon.de", "amazon.fr", "amazon.ca", "amazon.co.jp", "amazonses.com", "amazonaws.com"},
+	"paypal":          {"paypal.com", "paypal.me", "paypal.co.uk", "paypal.de"},
+	"microsoft":       {"microsoft.com", "microsoftonline.com", "office.com", "office365.com", "outlook.com", "live.com", "hotmail.com"},
+	"apple":           {"apple.com", "icloud.com", "me.com", "mac.com"},
+	"google":          {"google.com", "gmail.com", "googlemail.com", "youtube.com"},
+	"facebook":        {"facebook.com", "facebookmail.com", "meta.com", "fb.com"},
+	"chase":           {"chase.com", "jpmorgan.com", "jpmchase.com"},
+	"wells fargo":     {"wellsfargo.com", "wf.com"},
+	"bank of america": {"bankofamerica.com", "bofa.com"},
+}
+
+// DefaultBrandKeywords maps brands to the product and service names that
+// impersonators put in display names in place of the brand name itself
+var DefaultBrandKeywords = map[string][]string{
+	"amazon":    {"amazon prime", "aws"},
+	"microsoft": {"office 365", "microsoft 365", "outlook", "onedrive", "sharepoint"},
+	"apple":     {"icloud", "itunes", "apple id", "app store"},
+	"google":    {"gmail", "google drive", "google workspace"},
+	"facebook":  {"instagram", "whatsapp"},
+	"chase":     {"jpmorgan"},
+}
+
+// DefaultCommonWordBrands lists the brands whose names are also common words or
+// names, such as the first name Chase, so that the name alone in a display name
+// doesn't show that an email impersonates them
+var DefaultCommonWordBrands = []string{"amazon", "apple", "chase"}
+
+// brandPattern matches a brand name as whole words, allowing the words to be
+// run together or separated by whitespace
+func brandPattern(brand string) *regexp.Regexp {
+	words := strings.Fields(regexp.QuoteMeta(strings.ToLower(brand)))
+	return regexp.MustCompile(`(?i)\b` + strings.Join(words, `\s*`) + `\b`)
+}
+
+// compileBrandPatterns compiles the pattern of every configured brand name and
+// keyword, by its name in lower case
+func compileBrandPatterns(config Config) map[string]*regexp.Regexp {
+	patterns := map[string]*regexp.Regexp{}
+	add := func(keyword string) {
+		if key := strings.ToLower(keyword); patterns[key] == nil {
+			patterns[key] = brandPattern(keyword)
+		}
+	}
+	for brand := range config.BrandDomains {
+		add(brand)
+	}
+	for _, keywords := range config.BrandKeywords {
+		for _, keyword := range keywords {
+			add(keyword)
+		}
+	}
+	return patterns
+}
+
+// mentionsBrand checks if text contains a brand name or keyword as whole words
+func (d *SpoofDetector) mentionsBrand(text, keyword string) bool {
+	pattern, found := d.brandPatterns[strings.ToLower(keyword)]
+	if !found {
+		pattern = brandPattern(keyword)
+	}
+	return pattern.MatchString(text)
+}
+
+// isCommonWordName checks if a keyword found in a display name is the bare name of
+// a brand that is also a common word or name, which doesn't show impersonation on
+// its own, as in "Chase Miller"
+func (d *SpoofDetector) isCommonWordName(keyword, brand string) bool {
+	if keyword != strings.ToLower(brand) {
+		return false
+	}
+	for _, common := range d.config.CommonWordBrands {
+		if strings.EqualFold(common, brand) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkBrandImpersonation flags email that presents itself as a brand through the
+// display name, a lookalike domain or the subject while not being sent from any of
+// the brand's legitimate domains. The name of a brand that is also a common word
+// or name only counts along with a lookalike domain or the subject; alone it is
+// reported as a mention.
+func (d *SpoofDetector) checkBrandImpersonation(email *models.Email, result *models.AnalysisResult) {
+	fromDomain := strings.ToLower(models.GetDomain(email.From))
+	if fromDomain == "" {
+		return
+	}
+	registrable := baseDomain(fromDomain)
+
+	for _, brand := range d.sortedBrands() {
+		legitimate := d.config.BrandDomains[brand]
+		if isBrandDomain(fromDomain, legitimate) {
+			continue
+		}
+
+		var signals []string
+		strong, commonName := false, false
+		mention := "Subject"
+		if keyword := d.brandKeywordIn(email.From.Name, brand); keyword != "" {
+			signal := "display name \"" + cleanText(email.From.Name) + "\""
+			if keyword != brand {
+				signal += " contains \"" + keyword + "\""
+			}
+			signals = append(signals, signal)
+			commonName = d.isCommonWordName(keyword, brand)
+			strong = !commonName
+			mention = "Display name \"" + cleanText(email.From.Name) + "\""
+		}
+		if isBrandLookalike(registrable, brand, legitimate) {
+			signals = append(signals, "lookalike domain "+fromDomain)
+			strong = true
+		}
+		if d.mentionsBrand(cleanText(email.Subject), brand) {
+			signals = append(signals, "subject")
+			strong = strong || commonName
+		}
+		if len(signals) == 0 {
+			continue
+		}
+
+		domains := strings.Join(legitimate, ", ")
+		if strong {
+			result.AddFinding("brand_impersonation", models.SeverityHigh, 4,
+				"Email impersonates "+brand+" ("+strings.Join(signals, ", ")+") but is sent from "+fromDomain+", not one of its domains ("+domains+")")
+		} else {
+			result.AddFinding("brand_mention", models.SeverityLow, 1,
+				mention+" mentions "+brand+" but the email is sent from "+fromDomain+", not one of its domains ("+domains+")")
+		}
+		return
+	}
+}
+
+// checkLookalikeReplyTo flags a Reply-To on the same lookalike domain of a brand as
+// the From address. Spoofers keep the two consistent so the mismatch rule stays
+// quiet, while replies still go to them rather than the brand.
+func (d *SpoofDetector) checkLookalikeReplyTo(email *models.Email, result *models.AnalysisResult) {
+	fromDomain := strings.ToLower(models.GetDomain(email.From))
+	replyDomain := strings.ToLower(models.GetDomain(email.ReplyTo))
+	if fromDomain == "" || replyDomain == "" || !isRelatedDomain(fromDomain, replyDomain) {
+		return
+	}
+
+	registrable := baseDomain(fromDomain)
+	for _, brand := range d.sortedBrands() {
+		legitimate := d.config.BrandDomains[brand]
+		if isBrandDomain(fromDomain, legitimate) || !isBrandLookalike(registrable, brand, legitimate) {
+			continue
+		}
+
+		result.AddFinding("lookalike_reply_to", models.SeverityMedium, 2,
+			"From ("+fromDomain+") and Reply-To ("+replyDomain+") both use "+registrable+", a lookalike of "+brand)
+		return
+	}
+}
+
+// checkBrandSigner flags email impersonating a brand whose DKIM signatures that
+// match the body are all made by domains that aren't the brand's. A valid signature
+// only proves who signed, so one by an unrelated domain on email claiming to be the
+// brand is a spoof signed with the attacker's own key. Signers of email from the
+// brand's own domains are checked by checkOnBehalfSending.
+func (d *SpoofDetector) checkBrandSigner(email *models.Email, result *models.AnalysisResult) {
+	brand := d.ImpersonatedBrand(email)
+	if brand == "" {
+		return
+	}
+
+	legitimate := d.config.BrandDomains[brand]
+	var signers []string
+	seen := map[string]bool{}
+	for _, value := range email.GetAllHeaderValues("DKIM-Signature") {
+		signature := parseDKIMSignature(value)
+		if signature.Domain == "" || email.HasBody && !dkimBodyHashMatches(signature, []byte(email.Body)) {
+			continue
+		}
+		if isBrandDomain(signature.Domain, legitimate) {
+			return
+		}
+		if !seen[signature.Domain] {
+			seen[signature.Domain] = true
+			signers = append(signers, "d="+signature.Domain)
+		}
+	}
+
+	if len(signers) == 0 {
+		return
+	}
+
+	result.AddFinding("unexpected_brand_signer", models.SeverityMedium, 2,
+		"Email presenting itself as "+brand+" is DKIM signed by "+strings.Join(signers, ", ")+", not by one of "+brand+"'s domains")
+}
+
+// ImpersonatedBrand returns the brand whose name, product keyword or lookalike
+// domain the sender of an email uses without sending from one of the brand's
+// domains, or an empty string
+func (d *SpoofDetector) ImpersonatedBrand(email *models.Email) string {
+	if email.From == nil {
+		return ""
+	}
+	return d.impersonatedBrand(email.From.Name, strings.ToLower(models.GetDomain(email.From)))
+}
+
+// impersonatedBrand returns the brand a display name or domain presents itself as
+// when the domain isn't one of that brand's, or an empty string. The bare name of
+// a brand that is also a common word or name only counts with a lookalike domain.
+func (d *SpoofDetector) impersonatedBrand(name, domain string) string {
+	for _, brand := range d.sortedBrands() {
+		legitimate := d.config.BrandDomains[brand]
+		if isBrandDomain(domain, legitimate) {
+			continue
+		}
+		if keyword := d.brandKeywordIn(name, brand); keyword != "" && !d.isCommonWordName(keyword, brand) ||
+			isBrandLookalike(baseDomain(domain), brand, legitimate) {
+			return brand
+		}
+	}
+	return ""
+}
+
+// brandOf returns the brand an email is from, going by its From domain, or
+// presents itself as, or an empty string
+func (d *SpoofDetector) brandOf(email *models.Email) string {
+	domain := strings.ToLower(models.GetDomain(email.From))
+	for _, brand := range d.sortedBrands() {
+		if isBrandDomain(domain, d.config.BrandDomains[brand]) {
+			return brand
+		}
+	}
+	return d.impersonatedBrand(email.From.Name, domain)
+}
+
+// sortedBrands returns the configured brands in alphabetical order, so the brand
+// reported when several match is deterministic
+func (d *SpoofDetector) sortedBrands() []string {
+	brands := make([]string, 0, len(d.config.BrandDomains))
+	for brand := range d.config.BrandDomains {
+		brands = append(brands, brand)
+	}
+	sort.Strings(brands)
+	return brands
+}
+
+// brandKeywordIn returns the brand name or first brand keyword found in a display
+// name, ignoring invisible characters splitting its words, or an empty string. A
+// keyword is preferred to the name of a brand that is also a common word, so that
+// "Apple ID" is found as the keyword "apple id".
+func (d *SpoofDetector) brandKeywordIn(name, brand string) string {
+	name = cleanText(name)
+	if name == "" {
+		return ""
+	}
+
+	found := ""
+	for _, keyword := range append([]string{brand}, d.config.BrandKeywords[brand]...) {
+		if !d.mentionsBrand(name, keyword) {
+			continue
+		}
+		keyword = strings.ToLower(keyword)
+		if !d.isCommonWordName(keyword, brand) {
+			return keyword
+		}
+		found = keyword
+	}
+	return found
+}
+
+// isBrandDomain checks if a domain is, or is a subdomain of, one of a brand's legitimate domains
+func isBrandDomain(domain string, legitimate []string) bool {
+	for _, candidate := range legitimate {
+		candidate = strings.ToLower(candidate)
+		if domain == candidate || strings.HasSuffix(domain, "."+candidate) {
+			return true
+		}
+	}
+	return false
+}
+
+// isBrandLookalike checks if a registrable domain carries the brand name as one of
+// its labels or hyphen-separated words, or if its main label or one of its words
+// is drawn like the brand name or the main label of one of the legitimate domains.
+// Only whole labels are compared, so ordinary names that happen to contain a short
+// brand domain, such as somecompany.com containing "me.com", don't match.
+func isBrandLookalike(registrable, brand string, legitimate []string) bool {
+	compact := strings.ReplaceAll(strings.ToLower(brand), " ", "")
+	for _, token := range strings.FieldsFunc(registrable, func(r rune) bool { return r == '.' || r == '-' }) {
+		if token == compact {
+			return true
+		}
+	}
+
+	targets := []string{compact}
+	for _, candidate := range legitimate {
+		targets = append(targets, mainLabel(strings.ToLower(candidate)))
+	}
+
+	label := mainLabel(registrable)
+	words := append([]string{label}, strings.Split(label, "-")...)
+	for _, word := range words {
+		for _, target := range targets {
+			if isLookalikeLabel(word, target) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// mainLabel returns the label of a domain in front of its public suffix, such
+// as "paypal" for paypal.co.uk, decoded from punycode
+func mainLabel(domain string) string {
+	label, _, _ := strings.Cut(baseDomain(domain), ".")
+	if decoded, err := idna.ToUnicode(label); err == nil {
+		label = decoded
+	}
+	return label
+}
+
+// lookalikeDigraphs are the letter pairs and digits drawn like a single letter
+var lookalikeDigraphs = strings.NewReplacer("rn", "m", "vv", "w", "cl", "d", "0", "o", "1", "l", "3", "e", "5", "s", "8", "b")
+
+// minLookalikeLength is the length below which a label is too short to tell a
+// lookalike from an unrelated name; minTypoLength the length from which a single
+// typo of the label is taken for a lookalike rather than another word
+const (
+	minLookalikeLength = 5
+	minTypoLength      = 7
+)
+
+// isLookalikeLabel checks if a label is drawn like a different target label:
+// written with lookalike letters of other scripts, digits or letter pairs, or,
+// for long targets, with a single letter added, removed, changed or swapped
+func isLookalikeLabel(label, target string) bool {
+	if len(target) < minLookalikeLength || label == target {
+		return false
+	}
+
+	if lookalikeDigraphs.Replace(confusableSkeleton(label)) == lookalikeDigraphs.Replace(target) {
+		return true
+	}
+	return len(target) >= minTypoLength && editDistance(label, target) == 1
+}
+
+// editDistance returns the number of runes to add, remove or change, or of
+// adjacent runes to swap, to turn one string into the other
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	rows := make([][]int, len(ra)+1)
+	for i := range rows {
+		rows[i] = make([]int, len(rb)+1)
+		rows[i][0] = i
+	}
+	for j := range rows[0] {
+		rows[0][j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			distance := rows[i-1][j-1] + cost
+			if insertion := rows[i][j-1] + 1; insertion < distance {
+				distance = insertion
+			}
+			if deletion := rows[i-1][j] + 1; deletion < distance {
+				distance = deletion
+			}
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] && rows[i-2][j-2]+1 < distance {
+				distance = rows[i-2][j-2] + 1
+			}
+			rows[i][j] = distance
+		}
+	}
+	return rows[len(ra)][len(rb)]
+}
+
+// checkBodyBrandContacts flags contact addresses in the body that impersonate a
+// brand and belong neither to that brand nor to the sender
+func (d *SpoofDetector) checkBodyBrandContacts(email *models.Email, result *models.AnalysisResult) {
+	fromDomain := strings.ToLower(models.GetDomain(email.From))
+
+	var impersonating []string
+	for _, address := range email.BodyAddresses {
+		if fromDomain != "" && isRelatedDomain(address.Domain, fromDomain) {
+			continue
+		}
+
+		for _, brand := range d.sortedBrands() {
+			legitimate := d.config.BrandDomains[brand]
+			if !isBrandDomain(address.Domain, legitimate) && isBrandLookalike(baseDomain(address.Domain), brand, legitimate) {
+				impersonating = append(impersonating, address.Address+" ("+brand+")")
+				break
+			}
+		}
+	}
+
+	if len(impersonating) == 0 {
+		return
+	}
+
+	result.AddFinding("body_brand_contact", models.SeverityMedium, 3,
+		"Body directs replies to addresses impersonating a brand: "+strings.Join(impersonating, ", "))
+}
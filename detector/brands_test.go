@@ -0,0 +1,167 @@
+package detector
+
+import (
+	"testing"
+
+	"github.com/user/email_spoof_detection/utils"
+)
+
+func TestIsBrandLookalike(t *testing.T) {
+	tests := []struct {
+		domain string
+		brand  string // Brand the domain looks like, empty for none
+	}{
+		// Brand names as labels or words
+		{"paypal-secure.com", "paypal"},
+		{"secure.paypal.top", "paypal"},
+		{"account-microsoft.net", "microsoft"},
+
+		// Lookalike letters, digits and letter pairs
+		{"paypa1.com", "paypal"},
+		{"arnazon.com", "amazon"},
+		{"micros0ft.com", "microsoft"},
+		{"app1e.com", "apple"},
+		{"xn--pypal-4ve.com", "paypal"}, // Cyrillic a
+		{"icl0ud-support.com", "apple"},
+		{"g00gle.co.uk", "google"},
+
+		// A single typo of a long label
+		{"micrsoft.com", "microsoft"},
+		{"faceboook.com", "facebook"},
+		{"hotmial.com", "microsoft"},
+		{"welsfargo.com", "wells fargo"},
+
+		// Ordinary domains containing short brand domains or labels
+		{"somecompany.com", ""},
+		{"acmecomputers.com", ""},
+		{"theofficecompany.com", ""},
+		{"livecomfort.net", ""},
+		{"homecomfort.org", ""},
+		{"fbcommunity.org", ""},
+		{"wfcomputing.com", ""},
+		{"macomb.edu", ""},
+
+		// Short words a single letter away from a brand
+		{"apply.com", ""},
+		{"ample.com", ""},
+		{"chaser.com", ""},
+		{"offices.com", ""},
+		{"example.com", ""},
+	}
+
+	d := NewSpoofDetector()
+	for _, tt := range tests {
+		registrable := baseDomain(tt.domain)
+		var found []string
+		for _, brand := range d.sortedBrands() {
+			legitimate := d.config.BrandDomains[brand]
+			if !isBrandDomain(tt.domain, legitimate) && isBrandLookalike(registrable, brand, legitimate) {
+				found = append(found, brand)
+			}
+		}
+
+		switch {
+		case tt.brand == "" && len(found) > 0:
+			t.Errorf("%s looks like %v, want no brand", tt.domain, found)
+		case tt.brand != "" && (len(found) != 1 || found[0] != tt.brand):
+			t.Errorf("%s looks like %v, want %s", tt.domain, found, tt.brand)
+		}
+	}
+}
+
+// TestOrdinaryDomainsNotImpersonating checks that unsigned email from ordinary
+// domains containing short brand domains isn't flagged as impersonating them
+func TestOrdinaryDomainsNotImpersonating(t *testing.T) {
+	for _, domain := range []string{"somecompany.com", "acmecomputers.com", "theofficecompany.com", "livecomfort.net"} {
+		result := analyzeOffline(t, "From: Sales <sales@"+domain+">\r\n"+
+			"To: bob@example.org\r\n"+
+			"Subject: Your quote\r\n"+
+			"Date: Mon, 3 Jun 2024 10:00:00 +0000\r\n"+
+			"Message-ID: <1@"+domain+">\r\n"+
+			"Received: from mail."+domain+" (mail."+domain+" [192.0.2.10]) by mx.example.org with ESMTP; Mon, 3 Jun 2024 10:00:01 +0000\r\n"+
+			"\r\n"+
+			"Please find the quote attached.\r\n")
+
+		for _, name := range []string{"brand_impersonation", "suspicious_from_domain"} {
+			if hasFinding(result, name) {
+				t.Errorf("%s reports %s: %v", domain, name, result.Findings)
+			}
+		}
+		if result.IsSpoofed {
+			t.Errorf("%s is marked spoofed with score %d: %v", domain, result.Score, result.Findings)
+		}
+	}
+}
+
+// brandEmail is an unsigned email with the given From and subject
+func brandEmail(from, subject string) string {
+	return "From: " + from + "\r\n" +
+		"To: bob@example.org\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"Date: Mon, 3 Jun 2024 10:00:00 +0000\r\n" +
+		"Message-ID: <1@example.net>\r\n" +
+		"Received: from mail.example.net (mail.example.net [192.0.2.10]) by mx.example.org with ESMTP; Mon, 3 Jun 2024 10:00:01 +0000\r\n" +
+		"\r\n" +
+		"See you at noon.\r\n"
+}
+
+func TestCommonWordBrandNames(t *testing.T) {
+	tests := []struct {
+		from    string
+		subject string
+		finding string // brand_impersonation, brand_mention or empty for neither
+	}{
+		// Names that are also brands aren't impersonation on their own
+		{"Chase Miller <chase@example.org>", "Lunch on Friday", "brand_mention"},
+		{"Apple Orchard Farm <info@appleorchard.example>", "Fresh cider", "brand_mention"},
+		{"Amazon Rivera <amazon.rivera@example.org>", "Slides", "brand_mention"},
+
+		// A second signal makes them impersonation
+		{"Chase <alerts@example.org>", "Your Chase account is locked", "brand_impersonation"},
+		{"Chase Support <support@chase-alerts.example>", "Lunch on Friday", "brand_impersonation"},
+
+		// Product names and brands that aren't common words count on their own
+		{"Apple ID <id@example.org>", "Lunch on Friday", "brand_impersonation"},
+		{"PayPal <service@example.org>", "Lunch on Friday", "brand_impersonation"},
+
+		{"Bob Miller <bob@example.org>", "Lunch on Friday", ""},
+	}
+
+	for _, tt := range tests {
+		result := analyzeOffline(t, brandEmail(tt.from, tt.subject))
+		for _, name := range []string{"brand_impersonation", "brand_mention"} {
+			if reported := hasFinding(result, name); reported != (name == tt.finding) {
+				t.Errorf("%s (%q): %s reported %v, want %v: %v", tt.from, tt.subject, name, reported, name == tt.finding, result.Findings)
+			}
+		}
+		if tt.finding != "brand_impersonation" && result.IsSpoofed {
+			t.Errorf("%s is marked spoofed with score %d: %v", tt.from, result.Score, result.Findings)
+		}
+	}
+
+	// Names aren't taken for the brand by the checks that build on impersonation
+	d := NewSpoofDetector()
+	for _, from := range []string{"Chase Miller <chase@example.org>", "Apple Orchard Farm <info@appleorchard.example>"} {
+		email, err := utils.ParseEmail([]byte(brandEmail(from, "Lunch")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if brand := d.ImpersonatedBrand(email); brand != "" {
+			t.Errorf("%s impersonates %s", from, brand)
+		}
+	}
+}
+
+// TestBrandPatternsCompiled checks that the patterns of the brand names and
+// keywords are compiled with the detector rather than for each email
+func TestBrandPatternsCompiled(t *testing.T) {
+	d := NewSpoofDetector()
+	for brand, keywords := range d.config.BrandKeywords {
+		for _, keyword := range append([]string{brand}, keywords...) {
+			if d.brandPatterns[keyword] == nil {
+				t.Errorf("pattern of %q isn't compiled", keyword)
+			}
+		}
+	}
+
+}
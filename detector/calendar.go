@@ -0,0 +1,135 @@
+package detector
+
+import (
+	"net/mail"
+	"strings"
+
+	"github.com/user/email_spoof_detection/models"
+)
+
+// calendarOrganizer is the ORGANIZER property of an iCalendar event
+type calendarOrganizer struct {
+	Name    string // CN parameter, the name shown by calendar clients
+	Address string
+}
+
+// String formats the organizer like an address header
+func (o calendarOrganizer) String() string {
+	if o.Name == "" {
+		return o.Address
+	}
+	return o.Name + " <" + o.Address + ">"
+}
+
+// isCalendarPart checks if a MIME part holds an iCalendar object, either as a
+// text/calendar part or as an attached .ics file
+func isCalendarPart(part models.Part) bool {
+	switch part.ContentType {
+	case "text/calendar", "application/ics", "text/x-vcalendar":
+		return true
+	}
+	return strings.HasSuffix(strings.ToLower(part.Filename), ".ics")
+}
+
+// parseCalendarOrganizers returns the ORGANIZER properties of an iCalendar object
+// (RFC 5545), e.g. `ORGANIZER;CN="Jane Doe":mailto:jane@example.com`
+func parseCalendarOrganizers(data string) []calendarOrganizer {
+	// Unfold the content lines continued with a leading space or tab
+	data = strings.ReplaceAll(data, "\r\n", "\n")
+	data = strings.NewReplacer("\n ", "", "\n\t", "").Replace(data)
+
+	var organizers []calendarOrganizer
+	for _, line := range strings.Split(data, "\n") {
+		if len(line) < len("ORGANIZER:") || !strings.EqualFold(line[:len("ORGANIZER")], "ORGANIZER") {
+			continue
+		}
+		rest := line[len("ORGANIZER"):]
+		if rest[0] != ';' && rest[0] != ':' {
+			continue
+		}
+
+		// The value follows the first colon outside a quoted parameter value
+		params, value, quoted := rest, "", false
+		for i, c := range rest {
+			if c == '"' {
+				quoted = !quoted
+			} else if c == ':' && !quoted {
+				params, value = rest[:i], rest[i+1:]
+				break
+			}
+		}
+
+		var organizer calendarOrganizer
+		for _, param := range strings.Split(params, ";") {
+			if name, paramValue, found := strings.Cut(param, "="); found && strings.EqualFold(name, "CN") {
+				organizer.Name = strings.Trim(paramValue, "\"")
+			}
+		}
+		value = strings.TrimSpace(value)
+		if len(value) > len("mailto:") && strings.EqualFold(value[:len("mailto:")], "mailto:") {
+			value = value[len("mailto:"):]
+		}
+		if address, err := mail.ParseAddress(value); err == nil {
+			organizer.Address = address.Address
+		}
+		if organizer.Address != "" {
+			organizers = append(organizers, organizer)
+		}
+	}
+	return organizers
+}
+
+// checkCalendarOrganizers flags calendar invites whose organizer, the person
+// calendar clients show as having sent the invitation, isn't the sender of the
+// email. Invites spoofing an executive or a brand as the organizer otherwise
+// pass unnoticed, as the headers only name the real sender.
+func (d *SpoofDetector) checkCalendarOrganizers(email *models.Email, result *models.AnalysisResult) {
+	fromDomain := strings.ToLower(models.GetDomain(email.From))
+
+	seen := map[string]bool{}
+	for _, part := range email.Parts {
+		if !isCalendarPart(part) {
+			continue
+		}
+
+		for _, organizer := range parseCalendarOrganizers(string(part.Body)) {
+			key := strings.ToLower(organizer.Address)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			domain := strings.ToLower(organizer.Address[strings.LastIndex(organizer.Address, "@")+1:])
+			if fromDomain != "" && isRelatedDomain(domain, fromDomain) {
+				continue
+			}
+
+			sender := fromDomain
+			if sender == "" {
+				sender = "an email without a From domain"
+			}
+			authenticated := " (SPF: " + orNotEvaluated(result.Authentication.SPF) +
+				", DKIM: " + orNotEvaluated(result.Authentication.DKIM) + ")"
+
+			switch brand := d.impersonatedBrand(organizer.Name, domain); {
+			case brand != "":
+				result.AddFinding("calendar_organizer_impersonation", models.SeverityHigh, 4,
+					"Calendar invite organizer "+organizer.String()+" impersonates "+brand+" in an email from "+sender+authenticated)
+			case d.isInternalDomain(domain):
+				result.AddFinding("calendar_organizer_impersonation", models.SeverityHigh, 4,
+					"Calendar invite organizer "+organizer.String()+" claims internal domain "+domain+" in an email from "+sender+authenticated)
+			default:
+				result.AddFinding("calendar_organizer_mismatch", models.SeverityMedium, 2,
+					"Calendar invite organizer "+organizer.String()+" doesn't match the sender domain "+sender+authenticated)
+			}
+		}
+	}
+}
+
+// orNotEvaluated shows an empty authentication result as "not evaluated"
+func orNotEvaluated(value string) string {
+	if value == "" {
+		return "not evaluated"
+	}
+	return value
+}
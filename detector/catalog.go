@@ -0,0 +1,378 @@
+package detector
+
+import (
+	"github.com/user/email_spoof_detection/models"
+)
+
+// authenticationChecks describes the rules built for each email by heloRule,
+// authenticationRules and auxiliaryDomainRules
+var authenticationChecks = []Rule{
+	{
+		Name:        "suspicious_helo",
+		Description: "HELO/EHLO identity of the sending server is an IP literal, doesn't resolve, or falsely claims the From domain",
+		Weight:      2,
+		Severity:    models.SeverityMedium,
+		Network:     true,
+	},
+	{
+		Name:        "spf",
+		Description: "From domain fails SPF for the sending server, or has no or an invalid SPF record",
+		Weight:      3,
+		Severity:    models.SeverityMedium,
+		Network:     true,
+	},
+	{
+		Name:        "dkim",
+		Description: "Email has no DKIM signature of the From domain that matches its body",
+		Weight:      3,
+		Severity:    models.SeverityMedium,
+	},
+	{
+		Name:        "dmarc",
+		Description: "From domain has no DMARC record, or a monitoring-only or unknown policy",
+		Weight:      2,
+		Severity:    models.SeverityLow,
+		Network:     true,
+	},
+	{
+		Name:        "reply_to_spf",
+		Description: "Reply-To domain fails SPF or has no SPF record (with -check-aux-domains)",
+		Weight:      1,
+		Severity:    models.SeverityLow,
+		Network:     true,
+	},
+	{
+		Name:        "reply_to_dmarc",
+		Description: "Reply-To domain has no enforcing DMARC policy (with -check-aux-domains)",
+		Weight:      1,
+		Severity:    models.SeverityLow,
+		Network:     true,
+	},
+	{
+		Name:        "return_path_spf",
+		Description: "Return-Path domain fails SPF or has no SPF record (with -check-aux-domains)",
+		Weight:      1,
+		Severity:    models.SeverityLow,
+		Network:     true,
+	},
+	{
+		Name:        "return_path_dmarc",
+		Description: "Return-Path domain has no enforcing DMARC policy (with -check-aux-domains)",
+		Weight:      1,
+		Severity:    models.SeverityLow,
+		Network:     true,
+	},
+}
+
+// analysisChecks describes the checks analyze runs on its own rather than as
+// rules, by the name of the findings they report. Checks that weigh a finding
+// depending on what they found are listed with the highest weight.
+var analysisChecks = []Rule{
+	{
+		Name:        "internal_domain_spoof",
+		Description: "Email claims to be from one of the -my-domains but didn't pass SPF",
+		Weight:      6,
+		Severity:    models.SeverityCritical,
+	},
+	{
+		Name:        "bimi",
+		Description: "Brand From domain has no valid BIMI record, or one without DMARC enforcement (noted, not scored)",
+		Weight:      0,
+		Severity:    models.SeverityInfo,
+		Network:     true,
+	},
+	{
+		Name:        "dkim_key_not_found",
+		Description: "Selector record of a DKIM signature doesn't exist",
+		Weight:      2,
+		Severity:    models.SeverityMedium,
+		Network:     true,
+	},
+	{
+		Name:        "dkim_key_invalid",
+		Description: "Selector record of a DKIM signature has no p= tag",
+		Weight:      1,
+		Severity:    models.SeverityLow,
+		Network:     true,
+	},
+	{
+		Name:        "dkim_key_revoked",
+		Description: "Selector record of a DKIM signature revokes the key with an empty p= tag",
+		Weight:      2,
+		Severity:    models.SeverityMedium,
+		Network:     true,
+	},
+	{
+		Name:        "too_few_received_headers",
+		Description: "Email has fewer Received headers than -min-received, as if injected directly",
+		Weight:      2,
+		Severity:    models.SeverityLow,
+	},
+	{
+		Name:        "header_limit_exceeded",
+		Description: "A header field occurs too often or has an overly long value",
+		Weight:      2,
+		Severity:    models.SeverityMedium,
+	},
+	{
+		Name:        "high_risk_origin_country",
+		Description: "Sending server is in one of the -high-risk-countries (with -geoip-db)",
+		Weight:      2,
+		Severity:    models.SeverityMedium,
+	},
+	{
+		Name:        "origin_country_mismatch",
+		Description: "Sending server is outside the country of the From domain's country code TLD (with -geoip-db)",
+		Weight:      1,
+		Severity:    models.SeverityLow,
+	},
+	{
+		Name:        "brand_origin_country",
+		Description: "Email from or impersonating a brand was sent from a country the brand doesn't send from",
+		Weight:      3,
+		Severity:    models.SeverityMedium,
+	},
+	{
+		Name:        "brand_from_suspicious_asn",
+		Description: "Email from or impersonating a brand was sent from one of the -suspicious-asns",
+		Weight:      4,
+		Severity:    models.SeverityHigh,
+	},
+	{
+		Name:        "suspicious_origin_asn",
+		Description: "Sending server is in one of the -suspicious-asns (with -asn-db)",
+		Weight:      2,
+		Severity:    models.SeverityMedium,
+	},
+	{
+		Name:        "spf_ptr_mechanism",
+		Description: "SPF record of the From domain relies on the deprecated ptr mechanism",
+		Weight:      1,
+		Severity:    models.SeverityLow,
+	},
+	{
+		Name:        "spf_redirect",
+		Description: "SPF policy of the From domain was taken from another domain through redirect= (noted, not scored)",
+		Weight:      0,
+		Severity:    models.SeverityInfo,
+	},
+	{
+		Name:        "conflicting_authentication_results",
+		Description: "Authentication-Results headers disagree on the outcome of SPF, DKIM or DMARC",
+		Weight:      3,
+		Severity:    models.SeverityHigh,
+	},
+	{
+		Name:        "spf_subdomain_only",
+		Description: "From domain has no SPF record while a subdomain used by the email has one (noted, not scored)",
+		Weight:      0,
+		Severity:    models.SeverityInfo,
+		Network:     true,
+	},
+	{
+		Name:        "dmarc_subdomain_only",
+		Description: "From domain has no DMARC record while a subdomain used by the email has one (noted, not scored)",
+		Weight:      0,
+		Severity:    models.SeverityInfo,
+		Network:     true,
+	},
+	{
+		Name:        "freemail_reply_to",
+		Description: "Replies go to a free mail address (with -check-urgent-reply-to)",
+		Weight:      1,
+		Severity:    models.SeverityLow,
+	},
+	{
+		Name:        "urgent_freemail_reply_to",
+		Description: "Replies go to a free mail address and the subject pushes for urgency (with -check-urgent-reply-to)",
+		Weight:      4,
+		Severity:    models.SeverityHigh,
+	},
+	{
+		Name:        "brand_impersonation",
+		Description: "Display name, lookalike domain or subject presents a brand the email isn't sent from",
+		Weight:      4,
+		Severity:    models.SeverityHigh,
+	},
+	{
+		Name:        "brand_mention",
+		Description: "Subject, or a display name using a brand name that is also a common word, mentions a brand the email isn't sent from",
+		Weight:      1,
+		Severity:    models.SeverityLow,
+	},
+	{
+		Name:        "lookalike_reply_to",
+		Description: "Reply-To is on the same lookalike domain of a brand as the From address",
+		Weight:      2,
+		Severity:    models.SeverityMedium,
+	},
+	{
+		Name:        "unauthenticated_automated_mail",
+		Description: "Email from or impersonating a brand claims to be automated without an aligned DKIM signature",
+		Weight:      2,
+		Severity:    models.SeverityMedium,
+	},
+	{
+		Name:        "unexpected_brand_signer",
+		Description: "Email impersonating a brand is only DKIM signed by domains that aren't the brand's",
+		Weight:      2,
+		Severity:    models.SeverityMedium,
+	},
+	{
+		Name:        "body_brand_contact",
+		Description: "Body gives contact addresses that impersonate a brand and belong neither to it nor to the sender",
+		Weight:      3,
+		Severity:    models.SeverityMedium,
+	},
+	{
+		Name:        "unexpected_language",
+		Description: "Email from or impersonating a brand or domain isn't in one of its expected languages",
+		Weight:      1,
+		Severity:    models.SeverityLow,
+	},
+	{
+		Name:        "resent_header_mismatch",
+		Description: "Resent-* headers impersonate a brand, or their domain has no DKIM signature",
+		Weight:      4,
+		Severity:    models.SeverityHigh,
+	},
+	{
+		Name:        "calendar_organizer_impersonation",
+		Description: "Calendar invite names a brand or an internal address as organizer that isn't the sender",
+		Weight:      4,
+		Severity:    models.SeverityHigh,
+	},
+	{
+		Name:        "calendar_organizer_mismatch",
+		Description: "Calendar invite organizer isn't the sender of the email",
+		Weight:      2,
+		Severity:    models.SeverityMedium,
+	},
+	{
+		Name:        "unexpected_on_behalf",
+		Description: "Email is sent on behalf of the From domain by a service that isn't an email service provider",
+		Weight:      4,
+		Severity:    models.SeverityHigh,
+	},
+	{
+		Name:        "suspicious_links",
+		Description: "Links point to bare IP addresses, URL shorteners, or lookalikes of known brands",
+		Weight:      2,
+		Severity:    models.SeverityMedium,
+	},
+	{
+		Name:        "high_link_density",
+		Description: "Body of an unauthenticated sender is mostly links and images (see -max-link-density)",
+		Weight:      2,
+		Severity:    models.SeverityMedium,
+	},
+	{
+		Name:        "suspicious_qr_code",
+		Description: "QR code in an image links to a suspicious or brand lookalike site (with -decode-qr)",
+		Weight:      4,
+		Severity:    models.SeverityHigh,
+	},
+	{
+		Name:        "qr_code_link",
+		Description: "QR code in an image contains a link (with -decode-qr)",
+		Weight:      1,
+		Severity:    models.SeverityLow,
+	},
+	{
+		Name:        "risky_tld",
+		Description: "From or link domain is on a high-risk TLD, weighted higher when impersonating a brand",
+		Weight:      2,
+		Severity:    models.SeverityMedium,
+	},
+	{
+		Name:        "unrelated_unsubscribe",
+		Description: "List-Unsubscribe points to a domain unrelated to the sender, a bare IP address or a lookalike",
+		Weight:      4,
+		Severity:    models.SeverityHigh,
+	},
+	{
+		Name:        "blocklisted_from_domain",
+		Description: "From domain is listed on one of the -domain-blocklists",
+		Weight:      4,
+		Severity:    models.SeverityHigh,
+		Network:     true,
+	},
+	{
+		Name:        "dangling_cname",
+		Description: "From or Reply-To domain is a CNAME to a name that doesn't exist (with -check-takeover)",
+		Weight:      3,
+		Severity:    models.SeverityHigh,
+		Network:     true,
+	},
+	{
+		Name:        "pgp_signature",
+		Description: "PGP signature wasn't verified, or was made by a key that isn't in the keyring",
+		Weight:      1,
+		Severity:    models.SeverityLow,
+	},
+	{
+		Name:        "pgp_signature_invalid",
+		Description: "PGP signature is invalid",
+		Weight:      4,
+		Severity:    models.SeverityHigh,
+	},
+	{
+		Name:        "pgp_signer_mismatch",
+		Description: "PGP signing key doesn't belong to the From address",
+		Weight:      4,
+		Severity:    models.SeverityHigh,
+	},
+	{
+		Name:        "known_malicious_attachment",
+		Description: "Attachment hash is on the -bad-hashes list",
+		Weight:      6,
+		Severity:    models.SeverityCritical,
+	},
+	{
+		Name:        "dkim_length_limit",
+		Description: "DKIM signature of the From domain covers only part of the body with the l= tag (noted, not scored)",
+		Weight:      0,
+		Severity:    models.SeverityInfo,
+	},
+	{
+		Name:        "dkim_unsigned_content",
+		Description: "Content was appended after the length a DKIM l= tag signs",
+		Weight:      4,
+		Severity:    models.SeverityHigh,
+	},
+	{
+		Name:        "smtp_smuggling",
+		Description: "Raw message has a dot line with foreign line endings or followed by SMTP commands",
+		Weight:      5,
+		Severity:    models.SeverityCritical,
+	},
+	{
+		Name:        "mixed_line_endings",
+		Description: "Raw message mixes CRLF and bare LF line endings",
+		Weight:      1,
+		Severity:    models.SeverityLow,
+	},
+}
+
+// Catalog returns every rule and check of the detector by the name of the
+// findings it reports: the Rules(), the authentication checks built for each
+// email and the checks run as part of the analysis. Only the Rules() have a
+// CheckFunc. Notes on how an email was analyzed, such as offline_mode or
+// allowlist_suppression, aren't checks and aren't listed.
+func Catalog() []Rule {
+	rules := Rules()
+	catalog := make([]Rule, 0, len(rules)+len(authenticationChecks)+len(analysisChecks))
+	catalog = append(catalog, rules...)
+	catalog = append(catalog, authenticationChecks...)
+	return append(catalog, analysisChecks...)
+}
+
+// inCatalog checks if a name is that of a rule or check listed by Catalog
+func inCatalog(name string) bool {
+	for _, rule := range Catalog() {
+		if rule.Name == name {
+			return true
+		}
+	}
+	return false
+}
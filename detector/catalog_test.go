@@ -0,0 +1,230 @@
+package detector
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/user/email_spoof_detection/models"
+	"github.com/user/email_spoof_detection/utils"
+)
+
+// analysisNotes are the findings that note how an email was analyzed rather
+// than report a check, and so aren't in the Catalog
+var analysisNotes = map[string]bool{
+	"allowlist_suppression": true,
+	"bounce_message":        true,
+	"encrypted_body":        true,
+	"offline_mode":          true,
+	"dns_unavailable":       true,
+	"domain_threshold":      true,
+}
+
+func TestCatalogNamesUnique(t *testing.T) {
+	seen := map[string]bool{}
+	for _, rule := range Catalog() {
+		if seen[rule.Name] {
+			t.Errorf("%s is listed twice", rule.Name)
+		}
+		if rule.Description == "" {
+			t.Errorf("%s has no description", rule.Name)
+		}
+		seen[rule.Name] = true
+	}
+}
+
+// TestCatalogCoversFindings checks that every finding the detector reports by
+// name is listed by the Catalog, or is a note on the analysis
+func TestCatalogCoversFindings(t *testing.T) {
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addFinding := regexp.MustCompile(`AddFinding\("([a-z_]+)"`)
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		source, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, match := range addFinding.FindAllSubmatch(source, -1) {
+			name := string(match[1])
+			if !inCatalog(name) && !analysisNotes[name] {
+				t.Errorf("%s reports %s, which the Catalog doesn't list", file, name)
+			}
+		}
+	}
+}
+
+// TestCatalogMatchesAuthenticationRules checks the Catalog entries of the rules
+// built for each email against the rules themselves
+func TestCatalogMatchesAuthenticationRules(t *testing.T) {
+	email, err := utils.ParseEmail([]byte("From: a@example.com\r\nReply-To: b@example.org\r\n" +
+		"Return-Path: <c@example.net>\r\nSubject: test\r\n\r\nbody\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := DefaultConfig()
+	config.CheckAuxiliaryDomains = true
+	d := NewSpoofDetectorWithConfig(config)
+	built := append([]Rule{d.heloRule(context.Background(), &diagnosticLog{})},
+		d.authenticationRules(context.Background(), email, "example.com", &models.AuthenticationResults{}, &diagnosticLog{})...)
+
+	if len(built) != len(authenticationChecks) {
+		t.Fatalf("%d rules built for an email, %d listed", len(built), len(authenticationChecks))
+	}
+	for i, rule := range built {
+		listed := authenticationChecks[i]
+		if rule.Name != listed.Name || rule.Weight != listed.Weight || rule.Severity != listed.Severity || rule.Network != listed.Network {
+			t.Errorf("built rule %s (%d, %s, network %v) is listed as %s (%d, %s, network %v)",
+				rule.Name, rule.Weight, rule.Severity, rule.Network, listed.Name, listed.Weight, listed.Severity, listed.Network)
+		}
+	}
+}
+
+// selectionEmail triggers the inconsistent_from_reply_to rule and the risky_tld
+// and brand_impersonation checks without DNS
+const selectionEmail = "From: PayPal Security <security@paypal-account.top>\r\n" +
+	"Reply-To: help@example.org\r\n" +
+	"To: victim@example.com\r\n" +
+	"Subject: Your PayPal account is limited\r\n" +
+	"Date: Mon, 3 Jun 2024 10:00:00 +0000\r\n" +
+	"Message-ID: <1@paypal-account.top>\r\n" +
+	"Received: from mail.paypal-account.top (mail.paypal-account.top [203.0.113.9]) by mx.example.com with ESMTP; Mon, 3 Jun 2024 10:00:01 +0000\r\n" +
+	"\r\n" +
+	"Please verify your account.\r\n"
+
+// analyzeSelected analyzes selectionEmail offline with the given rule selection,
+// returning the names of the findings reported
+func analyzeSelected(t *testing.T, enable, disable []string) map[string]bool {
+	t.Helper()
+	email, err := utils.ParseEmail([]byte(selectionEmail))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := DefaultConfig()
+	config.Offline = true
+	config.EnabledRules, config.DisabledRules = enable, disable
+	result := NewSpoofDetectorWithConfig(config).Analyze(email)
+
+	names := map[string]bool{}
+	score := 0
+	for _, finding := range result.Findings {
+		names[finding.Rule] = true
+		score += finding.Weight
+	}
+	if score != result.Score {
+		t.Errorf("score %d isn't the sum %d of the findings", result.Score, score)
+	}
+	return names
+}
+
+func TestRuleSelection(t *testing.T) {
+	all := analyzeSelected(t, nil, nil)
+	for _, name := range []string{"inconsistent_from_reply_to", "risky_tld", "brand_impersonation", "dkim"} {
+		if !all[name] {
+			t.Fatalf("selection email doesn't trigger %s: %v", name, all)
+		}
+	}
+
+	disabled := analyzeSelected(t, nil, []string{"risky_tld", "dkim", "inconsistent_from_reply_to"})
+	for _, name := range []string{"risky_tld", "dkim", "inconsistent_from_reply_to"} {
+		if disabled[name] {
+			t.Errorf("disabled %s is still reported", name)
+		}
+	}
+	if !disabled["brand_impersonation"] {
+		t.Errorf("brand_impersonation isn't reported with other checks disabled")
+	}
+
+	enabled := analyzeSelected(t, []string{"brand_impersonation"}, nil)
+	for name := range enabled {
+		if name != "brand_impersonation" && !analysisNotes[name] {
+			t.Errorf("%s is reported with only brand_impersonation enabled", name)
+		}
+	}
+	if !enabled["brand_impersonation"] {
+		t.Errorf("enabled brand_impersonation isn't reported")
+	}
+}
+
+func TestValidateRuleNames(t *testing.T) {
+	if err := ValidateRuleNames([]string{"spf", "risky_tld", "suspicious_helo", "return_path_dmarc", "header_injection"}); err != nil {
+		t.Errorf("names of rules and checks rejected: %v", err)
+	}
+	for _, name := range []string{"offline_mode", "no_such_rule", ""} {
+		if err := ValidateRuleNames([]string{name}); err == nil {
+			t.Errorf("%q accepted", name)
+		}
+	}
+}
+
+// The Catalog and defaults digests pinned to the RulesetVersion they were taken
+// at. Changing the name, weight or severity of a rule or check, or a default that
+// rules read such as the threshold or the brand lists, changes verdicts, so it
+// fails TestRulesetVersionPinned until RulesetVersion is bumped and all three are
+// updated. Changes to what a rule does aren't caught and still need a bump.
+const (
+	pinnedRulesetVersion = "23"
+	pinnedCatalogDigest  = "e84b75e50d133e6dec67eaf49c69ed7e86e35f884cb7773a44eff9000527de87"
+	pinnedDefaultsDigest = "057ff5eb4c66fcafad98e77e9d4d4e6d06c35096fe1a59bf754f6b01798f809d"
+)
+
+// catalogDigest hashes the name, weight and severity of every rule and check
+func catalogDigest() string {
+	var entries []string
+	for _, rule := range Catalog() {
+		entries = append(entries, fmt.Sprintf("%s %d %s", rule.Name, rule.Weight, rule.Severity))
+	}
+	sort.Strings(entries)
+
+	sum := sha256.Sum256([]byte(strings.Join(entries, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// defaultsDigest hashes the defaults that rules fall back on when they aren't
+// configured. fmt prints maps sorted by key, so the digest is stable.
+func defaultsDigest() string {
+	defaults := []interface{}{
+		DefaultConfig(),
+		DefaultGroupDecay,
+		DefaultUrgencyKeywords,
+		DefaultURLShorteners,
+		DefaultRiskyTLDs,
+		DefaultESPDomains,
+	}
+
+	var entries []string
+	for _, value := range defaults {
+		entries = append(entries, fmt.Sprintf("%+v", value))
+	}
+	sum := sha256.Sum256([]byte(strings.Join(entries, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestRulesetVersionPinned(t *testing.T) {
+	digest, defaults := catalogDigest(), defaultsDigest()
+	switch {
+	case RulesetVersion != pinnedRulesetVersion:
+		t.Errorf("RulesetVersion is %s but the digests are pinned to %s: set pinnedRulesetVersion = %q, pinnedCatalogDigest = %q and pinnedDefaultsDigest = %q",
+			RulesetVersion, pinnedRulesetVersion, RulesetVersion, digest, defaults)
+	case digest != pinnedCatalogDigest:
+		t.Errorf("rules or checks changed without bumping RulesetVersion %s: bump it, then set pinnedRulesetVersion to it and pinnedCatalogDigest = %q",
+			RulesetVersion, digest)
+	case defaults != pinnedDefaultsDigest:
+		t.Errorf("defaults changed without bumping RulesetVersion %s: bump it, then set pinnedRulesetVersion to it and pinnedDefaultsDigest = %q",
+			RulesetVersion, defaults)
+	}
+}
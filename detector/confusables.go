@@ -0,0 +1,112 @@
+package detector
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+	"golang.org/x/text/unicode/norm"
+
+	"github.com/user/email_spoof_detection/models"
+)
+
+// confusableLetters maps non-Latin letters to the ASCII letters they are drawn
+// like, after the compatibility decomposition has handled fullwidth and styled forms
+var confusableLetters = map[rune]rune{
+	// Cyrillic
+	'\u0430': 'a', '\u0432': 'b', '\u0435': 'e', '\u04BB': 'h', '\u0456': 'i', '\u0458': 'j', '\u043A': 'k', '\u04CF': 'l',
+	'\u043C': 'm', '\u043D': 'h', '\u043E': 'o', '\u0440': 'p', '\u051B': 'q', '\u0455': 's', '\u0442': 't',
+	'\u051D': 'w', '\u0445': 'x', '\u0443': 'y', '\u04AF': 'y', '\u0501': 'd', '\u0441': 'c', '\u0261': 'g',
+	// Greek
+	'\u03B1': 'a', '\u03B2': 'b', '\u03B5': 'e', '\u03B7': 'n', '\u03B9': 'i', '\u03BA': 'k', '\u03BD': 'v', '\u03BF': 'o',
+	'\u03C1': 'p', '\u03C4': 't', '\u03C5': 'u', '\u03C7': 'x', '\u03B3': 'y',
+	// Armenian
+	'\u0578': 'n', '\u057D': 'u', '\u0570': 'h', '\u0585': 'o',
+	// Latin letters outside ASCII
+	'\u0131': 'i', '\u0251': 'a', '\u029F': 'l', '\u0269': 'i',
+}
+
+// confusableSkeleton returns the ASCII text a string is drawn like: accents and
+// invisible characters are removed, fullwidth and styled letters are replaced by
+// their plain forms, and lookalike letters of other scripts by the Latin ones
+func confusableSkeleton(text string) string {
+	var skeleton strings.Builder
+	for _, r := range norm.NFKD.String(cleanText(text)) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		r = unicode.ToLower(r)
+		if latin, found := confusableLetters[r]; found {
+			r = latin
+		}
+		skeleton.WriteRune(r)
+	}
+	return skeleton.String()
+}
+
+// sensitiveLocalParts are the mailbox names of accounts that users trust to
+// speak for an organization, and that impersonators mimic
+var sensitiveLocalParts = map[string]bool{
+	"admin": true, "administrator": true, "billing": true, "security": true,
+	"support": true, "help": true, "helpdesk": true, "service": true, "accounts": true,
+	"account": true, "payments": true, "payroll": true, "noreply": true, "postmaster": true,
+	"hr": true, "it": true, "ceo": true, "finance": true, "invoices": true,
+}
+
+// checkConfusableLocalPart checks for a From local part that uses non-ASCII
+// lookalike letters, or a punycode-like xn-- form, to read as a sensitive
+// mailbox such as "support", written with a Cyrillic dze (U+0455) for the "s",
+// while being a different address
+func checkConfusableLocalPart(email *models.Email) (bool, string) {
+	if email.From == nil {
+		return false, ""
+	}
+	at := strings.LastIndex(email.From.Address, "@")
+	if at <= 0 {
+		return false, ""
+	}
+	local := email.From.Address[:at]
+
+	// Local parts aren't IDNA encoded, so an xn-- label only serves to look like one
+	shown := local
+	if strings.HasPrefix(strings.ToLower(local), "xn--") {
+		if decoded, err := idna.Punycode.ToUnicode(strings.ToLower(local)); err == nil && decoded != strings.ToLower(local) {
+			shown = decoded
+		}
+	}
+	if isASCII(shown) {
+		return false, ""
+	}
+
+	for _, word := range strings.FieldsFunc(confusableSkeleton(shown), func(r rune) bool {
+		return strings.ContainsRune(".-_+", r)
+	}) {
+		if !sensitiveLocalParts[word] {
+			continue
+		}
+
+		var foreign []rune
+		for _, r := range shown {
+			if r >= utf8.RuneSelf {
+				foreign = append(foreign, r)
+			}
+		}
+		message := "From local part \"" + local + "\" imitates \"" + word + "\" with " + describeRunes(foreign)
+		if shown != local {
+			message = "From local part \"" + local + "\" reads as \"" + shown + "\" and imitates \"" + word + "\" with " + describeRunes(foreign)
+		}
+		return true, message
+	}
+	return false, ""
+}
+
+// isASCII checks if a string has only ASCII characters
+func isASCII(text string) bool {
+	for i := 0; i < len(text); i++ {
+		if text[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
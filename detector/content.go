@@ -0,0 +1,42 @@
+package detector
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/user/email_spoof_detection/models"
+)
+
+// htmlTagPattern matches the HTML tags that make a renderer treat content as a web page
+var htmlTagPattern = regexp.MustCompile(`(?i)<\s*(html|head|body|a\s+href|script|iframe|img|div|table|form|style|meta)\b`)
+
+// markupPattern matches any tag, comment or entity of a markup language
+var markupPattern = regexp.MustCompile(`<[a-zA-Z!/][^>]*>|&[a-zA-Z]+;|&#[0-9]+;`)
+
+// checkContentTypeMismatch checks for text parts whose content doesn't match their
+// declared type, such as HTML declared as text/plain to slip past content scanners
+func checkContentTypeMismatch(email *models.Email) (bool, string) {
+	for i, part := range email.Parts {
+		if part.IsAttachment() || len(strings.TrimSpace(string(part.Body))) == 0 {
+			continue
+		}
+
+		switch {
+		case part.ContentType == "text/plain" && looksLikeHTML(part.Body):
+			return true, fmt.Sprintf("Part %d is declared as text/plain but contains HTML (sniffed as %s)",
+				i+1, http.DetectContentType(part.Body))
+		case part.ContentType == "text/html" && !markupPattern.Match(part.Body):
+			return true, fmt.Sprintf("Part %d is declared as text/html but contains no markup (sniffed as %s)",
+				i+1, http.DetectContentType(part.Body))
+		}
+	}
+
+	return false, ""
+}
+
+// looksLikeHTML checks if content would be rendered as a web page
+func looksLikeHTML(body []byte) bool {
+	return strings.HasPrefix(http.DetectContentType(body), "text/html") || htmlTagPattern.Match(body)
+}
@@ -1,177 +1,981 @@
 package detector
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net"
+	"net/mail"
+	"regexp"
+	"strconv"
 	"strings"
 
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"golang.org/x/sync/errgroup"
+
 	"github.com/user/email_spoof_detection/models"
+	"github.com/user/email_spoof_detection/utils"
 )
 
+// RulesetVersion identifies the rule set behind a verdict. It is bumped whenever
+// rules are added or removed or their weights or behavior change, as results of
+// different versions can't be compared. TestRulesetVersionPinned fails when the
+// rules and checks listed by Catalog or the defaults they read, such as the brand
+// lists, change without a bump; changes to what a rule does must be bumped by hand.
+const RulesetVersion = "23"
+
+// DefaultThreshold is the score at or above which an email is considered spoofed
+const DefaultThreshold = 5
+
+// DefaultAdvisoryBand is how far below the threshold a score still gets the advisory verdict
+const DefaultAdvisoryBand = 2
+
+// DefaultMinReceivedHeaders is the number of Received headers below which an
+// email is considered directly injected
+const DefaultMinReceivedHeaders = 1
+
+// EscalationRule marks an email as spoofed when at least Count findings
+// have a severity of Severity or higher
+type EscalationRule struct {
+	Severity models.Severity `json:"severity"`
+	Count    int             `json:"count"`
+}
+
+// ParseEscalationTable parses a specification such as "critical=1,medium=2"
+// into escalation rules
+func ParseEscalationTable(spec string) ([]EscalationRule, error) {
+	var table []EscalationRule
+
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		name, countText, found := strings.Cut(entry, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid escalation entry %q, expected severity=count", entry)
+		}
+
+		severity, err := models.ParseSeverity(name)
+		if err != nil {
+			return nil, err
+		}
+
+		count, err := strconv.Atoi(strings.TrimSpace(countText))
+		if err != nil || count < 1 {
+			return nil, fmt.Errorf("invalid count in escalation entry %q", entry)
+		}
+
+		table = append(table, EscalationRule{Severity: severity, Count: count})
+	}
+
+	return table, nil
+}
+
+// Config holds the tunable settings of a SpoofDetector
+type Config struct {
+	// Threshold is the score at or above which an email is considered spoofed
+	Threshold int
+
+	// DomainThresholds overrides Threshold for From domains. Keys are domains,
+	// matching the domain itself or its registrable domain, or wildcards such as
+	// "*.example.com" matching example.com and its subdomains.
+	DomainThresholds map[string]int
+
+	// AdvisoryBand gives scores in [Threshold-AdvisoryBand, Threshold) the advisory
+	// verdict; 0 disables it. It doesn't apply with an escalation table.
+	AdvisoryBand int
+
+	// Allowlist lists sender addresses and domains that never get the advisory
+	// verdict. They can still be flagged as spoofed, as their identity is what
+	// spoofers fake.
+	Allowlist []string
+
+	// AllowlistScopes allowlists senders, keyed like Allowlist, and additionally
+	// suppresses the findings in each entry's scope. Findings above the scope's
+	// severity are always kept, so a compromised allowlisted sender is still caught.
+	AllowlistScopes map[string]AllowlistScope
+
+	// Escalation replaces the numeric threshold when set: an email is spoofed
+	// if any of the rules matches the collected findings
+	Escalation []EscalationRule
+
+	// Actions maps score bands and finding severities to the action a mail filter
+	// should take; the most severe matching action is recorded in each result, or
+	// accept when none matches. No action is recorded when empty.
+	Actions []ActionBand
+
+	// CheckAuxiliaryDomains enables SPF and DMARC checks for the Reply-To and
+	// Return-Path domains when they differ from the From domain
+	CheckAuxiliaryDomains bool
+
+	// BrandCountries maps brands, by lower-case name, to the ISO country codes
+	// their email is sent from. Brand email sent from any other country is flagged
+	// when a GeoIP database is configured; brands without an entry aren't checked.
+	BrandCountries map[string][]string
+
+	// ExpectedLanguages maps brands and domains, in lower case, to the language
+	// codes ("en", "de", ...) of the email they send. Email whose Content-Language,
+	// or body language detected from common words, is none of them is flagged.
+	// Senders without an entry aren't checked.
+	ExpectedLanguages map[string][]string
+
+	// Offline skips every check that performs DNS lookups, for analysis without
+	// network access. The threshold is scaled down to the weight of the rules
+	// that remain.
+	Offline bool
+
+	// Resolver performs DNS lookups; net.DefaultResolver is used when nil.
+	// Concurrent identical lookups share a single query.
+	Resolver Resolver
+
+	// DNSProbeHost is looked up to check that the resolver answers at all when
+	// lookups of an analysis fail; DefaultDNSProbeHost is used when empty. Set it
+	// to a name the resolver answers for when it only serves internal names.
+	DNSProbeHost string
+
+	// GeoIP resolves the country of the sending IP; location checks are skipped when nil
+	GeoIP GeoIPLookup
+
+	// HighRiskCountries lists ISO country codes whose senders are flagged
+	HighRiskCountries []string
+
+	// ASN resolves the autonomous system of the sending IP; ASN checks are skipped when nil
+	ASN GeoIPLookup
+
+	// SuspiciousASNs lists hosting and bulletproof networks whose senders are flagged
+	SuspiciousASNs []uint
+
+	// RulesFile customizes rule weights, severities and score grouping when set
+	RulesFile *RulesFile
+
+	// InternalDomains lists the organization's own domains; unauthenticated email
+	// claiming to be from them is flagged as critical
+	InternalDomains []string
+
+	// MinReceivedHeaders is the minimum number of Received headers expected on
+	// email that crossed the internet; 0 disables the check. Email between internal
+	// domains only needs one.
+	MinReceivedHeaders int
+
+	// MaxHeaderCount and MaxHeaderLength are the number of occurrences of a header
+	// field and the length of its value above which it is flagged; 0 disables
+	// either check
+	MaxHeaderCount  int
+	MaxHeaderLength int
+
+	// MaxLinkDensity is the number of links and images per 100 characters of
+	// visible body text above which email from an unauthenticated sender is
+	// flagged; 0 disables the check
+	MaxLinkDensity float64
+
+	// CheckUrgentReplyTo enables flagging a free mail Reply-To, escalated when the
+	// subject contains one of UrgencyKeywords (DefaultUrgencyKeywords when empty)
+	CheckUrgentReplyTo bool
+	UrgencyKeywords    []string
+
+	// DomainBlocklists lists RHSBL zones, such as dbl.spamhaus.org, that the From
+	// domain is looked up in; no lookups are made when empty
+	DomainBlocklists []string
+
+	// CheckDanglingCNAME enables looking up the CNAME chains of the From and
+	// Reply-To domains to flag targets that no longer exist and could be claimed
+	CheckDanglingCNAME bool
+
+	// BrandDomains maps brand names to their legitimate sending domains; email
+	// presenting itself as a brand from any other domain is flagged
+	BrandDomains map[string][]string
+
+	// BrandKeywords maps brands to product names, such as "office 365" for
+	// microsoft, that count as the brand when they appear in a display name
+	BrandKeywords map[string][]string
+
+	// CommonWordBrands lists the brands whose names are also common words or
+	// names, such as chase; their name in a display name only counts as
+	// impersonation along with a lookalike domain or the brand in the subject
+	CommonWordBrands []string
+
+	// URLShorteners lists the link shortening services whose links are flagged;
+	// DefaultURLShorteners is used when empty
+	URLShorteners []string
+
+	// RiskyTLDs lists the top-level domains, without the leading dot, whose From
+	// and link domains are flagged; DefaultRiskyTLDs is used when empty
+	RiskyTLDs []string
+
+	// ESPDomains lists the email service providers allowed to host unsubscribe
+	// endpoints and send on behalf of any sender; DefaultESPDomains is used when empty
+	ESPDomains []string
+
+	// BounceHandling is BounceRelaxed (the default when empty) to ignore rules that
+	// misfire on bounce messages, or BounceStrict to apply every rule
+	BounceHandling string
+
+	// MaliciousHashes holds lower-case hex SHA-256 hashes of known-malicious
+	// attachments, which are flagged as critical
+	MaliciousHashes map[string]bool
+
+	// EnabledRules limits the rules and checks whose findings are reported to
+	// those named when not empty, and DisabledRules leaves out those named, by
+	// the names listed by Catalog; see ValidateRuleNames. Rules() and checks
+	// that look up DNS aren't run when left out. The SPF, DKIM and DMARC checks
+	// of the From domain always run, as other checks rely on their outcome.
+	EnabledRules  []string
+	DisabledRules []string
+
+	// TrustedRelays lists the IPs, CIDR ranges and hostnames of the organization's
+	// own relays, whose Received hops are skipped when locating the sending server.
+	// Hostnames are matched against the reverse DNS name recorded for each hop.
+	TrustedRelays []string
+
+	// TrustedAuthServIDs lists the authserv-ids that the organization's receiving
+	// servers stamp in Authentication-Results headers. Their verdict is preferred
+	// when the headers of an email disagree, and their DKIM or DMARC pass
+	// authenticates email from the InternalDomains.
+	TrustedAuthServIDs []string
+
+	// QRDecoder reads QR codes from image parts, whose links are then checked;
+	// images aren't decoded when nil
+	QRDecoder QRDecoder
+
+	// PGPKeyring holds the trusted public keys used to verify PGP signed email
+	PGPKeyring openpgp.KeyRing
+}
+
+// DefaultConfig returns the configuration used by NewSpoofDetector
+func DefaultConfig() Config {
+	return Config{
+		Threshold:          DefaultThreshold,
+		AdvisoryBand:       DefaultAdvisoryBand,
+		MinReceivedHeaders: DefaultMinReceivedHeaders,
+		MaxHeaderCount:     DefaultMaxHeaderCount,
+		MaxHeaderLength:    DefaultMaxHeaderLength,
+		MaxLinkDensity:     DefaultMaxLinkDensity,
+		BrandDomains:       DefaultBrandDomains,
+		BrandKeywords:      DefaultBrandKeywords,
+		CommonWordBrands:   DefaultCommonWordBrands,
+	}
+}
+
 // SpoofDetector implements email spoofing detection logic
 type SpoofDetector struct {
-	rules []Rule
+	rules      []Rule
+	config     Config
+	resolver   *coalescingResolver
+	blocklists blocklistCache
+	dns        dnsStatus
+
+	// brandPatterns are the compiled patterns of the brand names and keywords
+	brandPatterns map[string]*regexp.Regexp
+
+	// withoutDNS is set on the offline copy analyzing email while DNS is down
+	withoutDNS bool
 }
 
 // NewSpoofDetector creates a new instance of SpoofDetector
 func NewSpoofDetector() *SpoofDetector {
-	return &SpoofDetector{
-		rules: Rules(),
+	return NewSpoofDetectorWithConfig(DefaultConfig())
+}
+
+// NewSpoofDetectorWithConfig creates a new instance of SpoofDetector with the given configuration
+func NewSpoofDetectorWithConfig(config Config) *SpoofDetector {
+	resolver := config.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
 	}
+
+	d := &SpoofDetector{
+		config:        config,
+		resolver:      newCoalescingResolver(resolver),
+		brandPatterns: compileBrandPatterns(config),
+	}
+	d.rules = d.selectRules(Rules())
+	return d
 }
 
 // Analyze checks an email for signs of spoofing
 func (d *SpoofDetector) Analyze(email *models.Email) *models.AnalysisResult {
+	return d.AnalyzeContext(context.Background(), email)
+}
+
+// AnalyzeMessage checks a message already parsed with net/mail for signs of
+// spoofing, consuming its body
+func (d *SpoofDetector) AnalyzeMessage(msg *mail.Message) (*models.AnalysisResult, error) {
+	email, err := utils.ParseMessage(msg)
+	if err != nil {
+		return nil, err
+	}
+	return d.Analyze(email), nil
+}
+
+// batchWorkers is the number of emails AnalyzeBatch analyzes at once. Analysis
+// mostly waits on DNS, so it exceeds the number of CPUs.
+const batchWorkers = 16
+
+// AnalyzeBatch checks many emails for signs of spoofing concurrently, returning
+// the results in the order of the emails. The emails share the detector's DNS
+// lookups: identical lookups in flight at the same time are made once, and
+// blocklist answers are cached for the life of the detector. Other lookups
+// aren't cached; the system resolver or a caching Config.Resolver can do so.
+func (d *SpoofDetector) AnalyzeBatch(emails []*models.Email) []*models.AnalysisResult {
+	return d.AnalyzeBatchContext(context.Background(), emails)
+}
+
+// AnalyzeBatchContext is AnalyzeBatch with DNS lookups abandoned when ctx is
+// cancelled; see AnalyzeContext
+func (d *SpoofDetector) AnalyzeBatchContext(ctx context.Context, emails []*models.Email) []*models.AnalysisResult {
+	results := make([]*models.AnalysisResult, len(emails))
+
+	var group errgroup.Group
+	group.SetLimit(batchWorkers)
+	for i, email := range emails {
+		i, email := i, email
+		group.Go(func() error {
+			results[i] = d.AnalyzeContext(ctx, email)
+			return nil
+		})
+	}
+	group.Wait()
+
+	return results
+}
+
+// AnalyzeContext checks an email for signs of spoofing, abandoning DNS lookups
+// still in progress when ctx is cancelled. Checks whose lookups were abandoned
+// report temporary errors, so the result should be discarded if ctx.Err() is set.
+//
+// When lookups of an analysis fail, the resolver is checked with a lookup of
+// Config.DNSProbeHost, a name the email has no say over, so that a sender's
+// domain with failing DNS can't take DNS checks away from other email. If the
+// resolver doesn't answer that either, as when it is unreachable, the failed
+// lookups would each add to the score. The email is then analyzed again as in
+// offline mode, and so is the email of the following dnsRetryInterval, with a
+// single dns_unavailable finding.
+func (d *SpoofDetector) AnalyzeContext(ctx context.Context, email *models.Email) *models.AnalysisResult {
+	if d.config.Offline {
+		return d.analyze(ctx, email)
+	}
+
+	if !d.dns.isDown() {
+		tally := &lookupTally{}
+		result := d.analyze(withLookupTally(ctx, tally), email)
+		if ctx.Err() != nil || tally.failed.Load() == 0 || d.dns.probe(ctx, d.resolver, d.dnsProbeHost()) {
+			return result
+		}
+	}
+
+	config := d.config
+	config.Offline = true
+	offline := &SpoofDetector{rules: d.rules, config: config, resolver: d.resolver, brandPatterns: d.brandPatterns, withoutDNS: true}
+	return offline.analyze(ctx, email)
+}
+
+// dnsProbeHost returns the host looked up to check that the resolver answers
+func (d *SpoofDetector) dnsProbeHost() string {
+	if d.config.DNSProbeHost != "" {
+		return d.config.DNSProbeHost
+	}
+	return DefaultDNSProbeHost
+}
+
+// analyze runs the rules and checks against an email
+func (d *SpoofDetector) analyze(ctx context.Context, email *models.Email) *models.AnalysisResult {
 	result := &models.AnalysisResult{
-		IsSpoofed: false,
-		Reasons:   []string{},
-		Score:     0,
+		IsSpoofed:      false,
+		Reasons:        []string{},
+		Findings:       []models.Finding{},
+		Score:          0,
+		RulesetVersion: RulesetVersion,
+		Fingerprint:    email.Fingerprint(),
+	}
+
+	// Locate the server that sent the email
+	d.locateOrigin(email, result)
+
+	// Apply each rule, followed by the SPF, DKIM, and DMARC checks if the From domain is available
+	diagnostics := &diagnosticLog{}
+	rules := append([]Rule{}, d.rules...)
+	if d.selected("suspicious_helo") {
+		rules = append(rules, d.heloRule(ctx, diagnostics))
+	}
+	if fromDomain := models.GetDomain(email.From); fromDomain != "" {
+		rules = append(rules, d.authenticationRules(ctx, email, fromDomain, &result.Authentication, diagnostics)...)
+	}
+	evaluated, total := d.applyRules(email, rules, result)
+	diagnostics.flush(result)
+	d.checkBIMI(ctx, email, result)
+	d.checkDKIMKeys(ctx, email, result)
+
+	d.checkReceivedCount(email, result)
+	d.checkHeaderLimits(email, result)
+	d.checkOriginCountry(email, result)
+	d.checkBrandOriginCountry(email, result)
+	d.checkOriginASN(email, result)
+	d.checkSPFPTR(email, result)
+	d.checkSPFRedirect(email, result)
+	d.checkConflictingAuthResults(email, result)
+	d.checkSubdomainOnlyAuth(ctx, email, result)
+	d.checkInternalDomainSpoof(email, result)
+	d.checkUrgentFreeMailReplyTo(email, result)
+	d.checkBrandImpersonation(email, result)
+	d.checkLookalikeReplyTo(email, result)
+	d.checkAutomatedBrandMail(email, result)
+	d.checkBrandSigner(email, result)
+	d.checkBodyBrandContacts(email, result)
+	d.checkContentLanguage(email, result)
+	d.checkResentHeaders(email, result)
+	d.checkCalendarOrganizers(email, result)
+	d.checkOnBehalfSending(email, result)
+	d.checkSuspiciousLinks(email, result)
+	d.checkLinkDensity(email, result)
+	d.checkQRCodes(email, result)
+	d.checkRiskyTLDs(email, result)
+	d.checkUnsubscribeTargets(email, result)
+	d.checkDomainBlocklists(ctx, email, result)
+	d.checkDanglingCNAME(ctx, email, result)
+	d.checkPGPSignature(email, result)
+	d.recordAttachments(email, result)
+	checkDKIMLengthLimit(email, result)
+	checkSMTPSmuggling(email, result)
+	d.dropUnselected(result)
+	d.overrideFindings(result)
+
+	d.skipEncryptedBody(email, result)
+	d.applyAllowlist(email, result)
+	d.relaxBounce(email, result)
+	d.applyDomainThreshold(email, result)
+	d.applyOffline(evaluated, total, result)
+	result.SortFindings()
+
+	result.Score = d.score(result.Findings)
+	result.IsSpoofed = d.isSpoofed(result)
+	result.Verdict = d.verdict(email, result)
+	d.chooseAction(result)
+
+	return result
+}
+
+// applyRules runs the rules against an email and records the triggered ones in
+// rule order. Rules that perform DNS lookups run concurrently, or are recorded as
+// not evaluated in offline mode. It returns the total weight of the rules
+// evaluated and of all enabled rules.
+func (d *SpoofDetector) applyRules(email *models.Email, rules []Rule, result *models.AnalysisResult) (int, int) {
+	type outcome struct {
+		triggered bool
+		reason    string
+	}
+
+	// Apply the rules file; disabled rules aren't selected
+	for i, rule := range rules {
+		rules[i] = d.overrideRule(rule)
+	}
+
+	outcomes := make([]outcome, len(rules))
+	evaluated, total := 0, 0
+
+	var group errgroup.Group
+	for i, rule := range rules {
+		// The authentication rules of the From domain run even when left out, as
+		// other checks rely on their outcome, but their weight doesn't count
+		weight := rule.Weight
+		if !d.selected(rule.Name) {
+			weight = 0
+		}
+
+		total += weight
+		if rule.Network && d.config.Offline {
+			result.NotEvaluated = append(result.NotEvaluated, rule.Name)
+			continue
+		}
+		evaluated += weight
+
+		if !rule.Network {
+			triggered, reason := rule.CheckFunc(email)
+			outcomes[i] = outcome{triggered, reason}
+			continue
+		}
+
+		i, rule := i, rule
+		group.Go(func() error {
+			triggered, reason := rule.CheckFunc(email)
+			outcomes[i] = outcome{triggered, reason}
+			return nil
+		})
 	}
+	group.Wait()
 
-	// Apply each rule
-	for _, rule := range d.rules {
-		triggered, reason := rule.CheckFunc(email)
-		if triggered {
-			result.Score += rule.Weight
-			result.Reasons = append(result.Reasons, reason)
+	for i, rule := range rules {
+		if outcomes[i].triggered && d.selected(rule.Name) {
+			result.AddFinding(rule.Name, rule.Severity, rule.Weight, outcomes[i].reason)
 		}
 	}
 
-	// Check SPF, DKIM, and DMARC if From domain is available
-	if email.From != nil {
-		fromDomain := models.GetDomain(email.From)
-		if fromDomain != "" {
-			// Check SPF
-			spfResult := d.checkSPF(email, fromDomain)
-			if spfResult != "" {
-				result.Score += 3
-				result.Reasons = append(result.Reasons, spfResult)
-			}
+	return evaluated, total
+}
 
-			// Check DKIM
-			dkimResult := d.checkDKIM(email, fromDomain)
-			if dkimResult != "" {
-				result.Score += 3
-				result.Reasons = append(result.Reasons, dkimResult)
-			}
+// authenticationRules returns the SPF, DKIM, and DMARC checks for the From domain
+// and, when enabled, the SPF and DMARC checks for the Reply-To and Return-Path domains.
+// The outcome of each From domain check is recorded in auth, and lookups that
+// failed are recorded in diagnostics instead of as findings.
+func (d *SpoofDetector) authenticationRules(ctx context.Context, email *models.Email, fromDomain string, auth *models.AuthenticationResults, diagnostics *diagnosticLog) []Rule {
+	rules := []Rule{
+		{
+			Name:     "spf",
+			Weight:   3,
+			Severity: models.SeverityMedium,
+			Network:  true,
+			CheckFunc: func(email *models.Email) (bool, string) {
+				result, reason, trace := d.checkSPF(ctx, email, fromDomain)
+				auth.SPF = string(result)
+				auth.SPFUsedPTR = trace.usedPTR
+				auth.SPFRedirects = trace.redirects
+				if result == SPFTempError {
+					diagnostics.add(reason)
+					return false, ""
+				}
+				return reason != "", reason
+			},
+		},
+		{
+			Name:     "dkim",
+			Weight:   3,
+			Severity: models.SeverityMedium,
+			CheckFunc: func(email *models.Email) (bool, string) {
+				result, reason := d.checkDKIM(email, fromDomain)
+				auth.DKIM = result
+				return reason != "", reason
+			},
+		},
+		{
+			Name:     "dmarc",
+			Weight:   2,
+			Severity: models.SeverityLow,
+			Network:  true,
+			CheckFunc: func(email *models.Email) (bool, string) {
+				policy, source, reason := d.checkDMARC(ctx, email, fromDomain)
+				auth.DMARC = policy
+				auth.DMARCSource = source
+				if policy == DMARCLookupFailure {
+					diagnostics.add(reason)
+					return false, ""
+				}
+				return reason != "", reason
+			},
+		},
+	}
+
+	if d.config.CheckAuxiliaryDomains {
+		rules = append(rules, d.auxiliaryDomainRules(ctx, email, fromDomain, diagnostics)...)
+	}
+
+	return rules
+}
+
+// checkInternalDomainSpoof flags email claiming to be from one of the organization's
+// own domains that isn't authenticated: it didn't pass SPF, and a trusted receiving
+// server didn't stamp an aligned DKIM or a DMARC pass, as it does for internal
+// email forwarded from elsewhere. The detector's own DKIM check only compares body
+// hashes, which a spoofer can compute, so it doesn't count.
+func (d *SpoofDetector) checkInternalDomainSpoof(email *models.Email, result *models.AnalysisResult) {
+	fromDomain := strings.ToLower(models.GetDomain(email.From))
+	if fromDomain == "" || !d.isInternalDomain(fromDomain) {
+		return
+	}
+
+	// Without SPF, no internal email would count as authenticated
+	if d.config.Offline {
+		result.NotEvaluated = append(result.NotEvaluated, "internal_domain_spoof")
+		return
+	}
+
+	auth := result.Authentication
+	if auth.SPF == string(SPFPass) || d.trustedAuthentication(email, fromDomain) != "" {
+		return
+	}
+
+	spf := auth.SPF
+	if spf == "" {
+		spf = "not evaluated"
+	}
+	result.AddFinding("internal_domain_spoof", models.SeverityCritical, 6,
+		"Email claims to be from internal domain "+fromDomain+" but isn't authenticated (SPF: "+spf+", DKIM: "+auth.DKIM+", DMARC: "+auth.DMARC+")")
+}
+
+// isInternalDomain checks if a domain is, or is a subdomain of, one of the internal domains
+func (d *SpoofDetector) isInternalDomain(domain string) bool {
+	for _, internal := range d.config.InternalDomains {
+		internal = strings.ToLower(internal)
+		if domain == internal || strings.HasSuffix(domain, "."+internal) {
+			return true
+		}
+	}
+	return false
+}
 
-			// Check DMARC
-			dmarcResult := d.checkDMARC(email, fromDomain)
-			if dmarcResult != "" {
-				result.Score += 2
-				result.Reasons = append(result.Reasons, dmarcResult)
+// isSpoofed determines the verdict from the collected findings, using the escalation
+// table when one is configured and the numeric score threshold otherwise
+func (d *SpoofDetector) isSpoofed(result *models.AnalysisResult) bool {
+	if len(d.config.Escalation) > 0 {
+		for _, rule := range d.config.Escalation {
+			if rule.Count > 0 && result.CountAtLeast(rule.Severity) >= rule.Count {
+				return true
 			}
 		}
+		return false
 	}
 
-	// Determine if the email is spoofed based on the score
-	// A score of 5 or higher indicates spoofing
-	if result.Score >= 5 {
-		result.IsSpoofed = true
+	return result.Score >= result.Threshold
+}
+
+// verdict classifies an analyzed email, giving scores just below the threshold
+// the advisory verdict unless the sender is allowlisted
+func (d *SpoofDetector) verdict(email *models.Email, result *models.AnalysisResult) models.Verdict {
+	if result.IsSpoofed {
+		return models.VerdictSpoofed
 	}
 
-	return result
+	if len(d.config.Escalation) == 0 && d.config.AdvisoryBand > 0 &&
+		result.Score >= result.Threshold-d.config.AdvisoryBand && !d.isAllowlisted(email) {
+		return models.VerdictAdvisory
+	}
+
+	return models.VerdictLegitimate
 }
 
-// checkSPF verifies if the email passes SPF checks
-func (d *SpoofDetector) checkSPF(email *models.Email, domain string) string {
-	// In a real implementation, this would check the sending IP against the domain's SPF record
-	// For this example, we'll just check if the domain has an SPF record
-	
-	txtRecords, err := net.LookupTXT(domain)
-	if err != nil {
-		log.Printf("SPF lookup error for domain %s: %v", domain, err)
-		return "SPF lookup failed for domain " + domain
+// isAllowlisted checks if the From address, or its domain or a parent domain, is allowlisted
+func (d *SpoofDetector) isAllowlisted(email *models.Email) bool {
+	for _, entry := range d.config.Allowlist {
+		if allowlistMatches(email, entry) {
+			return true
+		}
+	}
+	for entry := range d.config.AllowlistScopes {
+		if allowlistMatches(email, entry) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowlistMatches checks if an allowlist entry names the From address, its domain or a parent domain
+func allowlistMatches(email *models.Email, entry string) bool {
+	if email.From == nil {
+		return false
 	}
 
-	// Check if any of the TXT records is an SPF record
-	spfRecord := ""
-	for _, record := range txtRecords {
-		if strings.HasPrefix(record, "v=spf1") {
-			spfRecord = record
-			break
+	address := strings.ToLower(email.From.Address)
+	domain := strings.ToLower(models.GetDomain(email.From))
+	entry = strings.ToLower(entry)
+	return entry == address || entry == domain || strings.HasSuffix(domain, "."+entry)
+}
+
+// auxiliaryDomainRules returns the SPF and DMARC checks for the Reply-To and Return-Path
+// domains, with findings scoped by the header the domain came from
+func (d *SpoofDetector) auxiliaryDomainRules(ctx context.Context, email *models.Email, fromDomain string, diagnostics *diagnosticLog) []Rule {
+	auxiliary := []struct {
+		header string
+		rule   string
+		domain string
+	}{
+		{"Reply-To", "reply_to", models.GetDomain(email.ReplyTo)},
+		{"Return-Path", "return_path", returnPathDomain(email)},
+	}
+
+	var rules []Rule
+	checked := map[string]bool{strings.ToLower(fromDomain): true}
+	for _, aux := range auxiliary {
+		domain := strings.ToLower(aux.domain)
+		if domain == "" || checked[domain] {
+			continue
 		}
+		checked[domain] = true
+
+		domain, prefix := domain, aux.header+" domain: "
+		rules = append(rules,
+			Rule{
+				Name:     aux.rule + "_spf",
+				Weight:   1,
+				Severity: models.SeverityLow,
+				Network:  true,
+				CheckFunc: func(email *models.Email) (bool, string) {
+					result, reason, _ := d.checkSPF(ctx, email, domain)
+					if result == SPFTempError {
+						diagnostics.add(prefix + reason)
+						return false, ""
+					}
+					return reason != "", prefix + reason
+				},
+			},
+			Rule{
+				Name:     aux.rule + "_dmarc",
+				Weight:   1,
+				Severity: models.SeverityLow,
+				Network:  true,
+				CheckFunc: func(email *models.Email) (bool, string) {
+					policy, _, reason := d.checkDMARC(ctx, email, domain)
+					if policy == DMARCLookupFailure {
+						diagnostics.add(prefix + reason)
+						return false, ""
+					}
+					return reason != "", prefix + reason
+				},
+			},
+		)
 	}
 
-	if spfRecord == "" {
-		return "Domain " + domain + " doesn't have an SPF record"
+	return d.selectRules(rules)
+}
+
+// checkSPF verifies if the email passes SPF checks, returning the SPF result (empty
+// when the sending IP is unknown), the reason if the check failed, and how the
+// evaluation reached the result: the redirects followed and whether it relied on
+// the deprecated ptr mechanism
+func (d *SpoofDetector) checkSPF(ctx context.Context, email *models.Email, domain string) (SPFResult, string, spfTrace) {
+	// Evaluate the SPF record against the sending IP when it can be determined
+	if ip := d.originIP(email); ip != nil {
+		return d.evaluateSPF(ctx, ip, domain, spfSender(email, domain))
+	}
+
+	// Without a sending IP, we can only check if the domain has a restrictive SPF
+	// policy, following redirects to the record that holds it
+	var trace spfTrace
+	name := domain
+	var spfRecord string
+	for {
+		txtRecords, err := d.resolver.LookupTXT(ctx, name)
+		if err != nil && !isNotFound(err) {
+			log.Printf("SPF lookup error for domain %s: %v", name, err)
+			return SPFTempError, "SPF lookup failed for domain " + name + ": " + err.Error(), trace
+		}
+
+		spfRecord, err = spfRecordOf(txtRecords)
+		if err != nil {
+			return SPFPermError, "SPF record of domain " + name + " is invalid: " + err.Error(), trace
+		}
+
+		if spfRecord == "" {
+			if name != domain {
+				return SPFPermError, "SPF record of domain " + domain + " is invalid: redirect=" + name + " has no SPF record", trace
+			}
+			return SPFNone, "Domain " + domain + " doesn't have an SPF record (queried TXT " + domain + ")", trace
+		}
+
+		redirect, err := spfRedirect(spfRecord)
+		if err != nil {
+			return SPFPermError, "SPF record of domain " + name + " is invalid: " + err.Error(), trace
+		}
+		if redirect == "" || hasSPFAll(spfRecord) {
+			break
+		}
+		if strings.Contains(redirect, "%") {
+			// Macros can't be expanded without the sending IP, so the policy is unknown
+			return "", "", trace
+		}
+		if len(trace.redirects) == spfLookupLimit {
+			return SPFPermError, "SPF record of domain " + domain + " is invalid: " + errSPFLookupLimit.Error(), trace
+		}
+		trace.redirects = append(trace.redirects, redirect)
+		name = redirect
 	}
 
-	// In a real implementation, we would check if the sending IP is allowed by the SPF record
-	// For this example, we'll just check if the SPF record has a restrictive policy
 	if strings.Contains(spfRecord, "-all") {
 		// Domain has a strict SPF policy
-		// In a real implementation, we would check if the sending IP is allowed
-		return ""
+		return "", "", trace
 	} else if strings.Contains(spfRecord, "~all") {
 		// Domain has a soft-fail SPF policy
-		return ""
+		return "", "", trace
 	} else if strings.Contains(spfRecord, "?all") {
 		// Domain has a neutral SPF policy
-		return "Domain " + domain + " has a neutral SPF policy"
+		return "", "Domain " + domain + " has a neutral SPF policy", trace
 	} else {
 		// Domain has a permissive SPF policy
-		return "Domain " + domain + " has a permissive SPF policy"
+		return "", "Domain " + domain + " has a permissive SPF policy", trace
+	}
+}
+
+// checkSPFPTR notes when the From domain's SPF record relied on the deprecated ptr
+// mechanism, which is slow, unreliable and discouraged by RFC 7208
+func (d *SpoofDetector) checkSPFPTR(email *models.Email, result *models.AnalysisResult) {
+	if !result.Authentication.SPFUsedPTR {
+		return
+	}
+
+	result.AddFinding("spf_ptr_mechanism", models.SeverityLow, 1,
+		"SPF record of domain "+models.GetDomain(email.From)+" uses the deprecated ptr mechanism")
+}
+
+// checkSPFRedirect notes when the SPF policy of the From domain was taken from
+// another domain's record through a redirect= modifier
+func (d *SpoofDetector) checkSPFRedirect(email *models.Email, result *models.AnalysisResult) {
+	redirects := result.Authentication.SPFRedirects
+	if len(redirects) == 0 {
+		return
+	}
+
+	result.AddFinding("spf_redirect", models.SeverityInfo, 0,
+		"SPF policy of domain "+models.GetDomain(email.From)+" was evaluated from redirect="+strings.Join(redirects, " -> redirect="))
+}
+
+// spfSender returns the envelope sender used when evaluating the SPF record of a
+// domain: the Return-Path if it belongs to the domain, otherwise postmaster@domain
+func spfSender(email *models.Email, domain string) string {
+	if strings.EqualFold(returnPathDomain(email), domain) {
+		return email.ReturnPath
+	}
+	return "postmaster@" + domain
+}
+
+// evaluateSPF checks if the sending IP is authorized by the domain's SPF record,
+// also reporting how the evaluation reached its result
+func (d *SpoofDetector) evaluateSPF(ctx context.Context, ip net.IP, domain, sender string) (SPFResult, string, spfTrace) {
+	evaluator := newSPFEvaluator(d.resolver, ip, sender)
+	result, err := evaluator.checkHost(ctx, domain)
+	return result, spfReason(result, err, ip, domain), evaluator.trace
+}
+
+// spfReason describes an SPF result that isn't a pass
+func spfReason(result SPFResult, err error, ip net.IP, domain string) string {
+	if errors.Is(err, errSPFUnsupportedMacro) {
+		return "SPF record of domain " + domain + " can't be evaluated: " + err.Error()
+	}
+
+	switch result {
+	case SPFPass:
+		return ""
+	case SPFFail:
+		return "SPF check failed: " + ip.String() + " is not authorized to send for domain " + domain
+	case SPFSoftFail:
+		return "SPF check soft-failed: " + ip.String() + " is not authorized to send for domain " + domain
+	case SPFNeutral:
+		return "SPF policy of domain " + domain + " is neutral about " + ip.String()
+	case SPFNone:
+		return "Domain " + domain + " doesn't have an SPF record (queried TXT " + domain + ")"
+	case SPFPermError:
+		return "SPF record of domain " + domain + " is invalid: " + err.Error()
+	default:
+		log.Printf("SPF evaluation error for domain %s: %v", domain, err)
+		if err != nil {
+			return "SPF lookup failed for domain " + domain + ": " + err.Error()
+		}
+		return "SPF lookup failed for domain " + domain
 	}
 }
 
-// checkDKIM verifies if the email has a valid DKIM signature
-func (d *SpoofDetector) checkDKIM(email *models.Email, domain string) string {
-	// In a real implementation, this would verify the DKIM signature
-	// For this example, we'll just check if the email has a DKIM-Signature header
-	
+// DKIM results recorded in the authentication results
+const (
+	DKIMNone       = "none"
+	DKIMMisaligned = "misaligned"
+	DKIMFail       = "fail"
+	DKIMUnverified = "unverified"
+)
+
+// checkDKIM looks for a DKIM signature of the From domain and checks its body hash,
+// returning the DKIM result and the reason if the check fails. The signature over
+// the headers isn't verified, so a matching body hash is still "unverified".
+func (d *SpoofDetector) checkDKIM(email *models.Email, domain string) (string, string) {
 	if !email.HasHeader("DKIM-Signature") {
-		return "Email doesn't have a DKIM signature"
+		return DKIMNone, "Email doesn't have a DKIM signature"
+	}
+
+	signature, found := dkimSignatureFor(email, domain)
+	if !found {
+		return DKIMMisaligned, "DKIM signature domain doesn't match From domain"
+	}
+
+	// Headers-only captures have no body to hash
+	if email.HasBody && !dkimBodyHashMatches(signature, []byte(email.Body)) {
+		return DKIMFail, "DKIM body hash of " + signature.Domain + " doesn't match the email body"
+	}
+
+	return DKIMUnverified, ""
+}
+
+// DMARCLookupFailure is the DMARC policy recorded when the record couldn't be looked up
+const DMARCLookupFailure = "error"
+
+// checkDMARC looks up the DMARC policy that applies to a domain following the
+// discovery of RFC 7489 section 6.6.3: the domain's own record, or else the record
+// of its organizational domain, whose sp= policy covers subdomains. It returns the
+// policy ("reject", "quarantine", "none", "unknown", "missing" or DMARCLookupFailure), the tag
+// and domain it came from, and the reason if the policy is weak.
+func (d *SpoofDetector) checkDMARC(ctx context.Context, email *models.Email, domain string) (string, string, string) {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+
+	record, err := d.lookupDMARC(ctx, domain)
+	if err != nil {
+		log.Printf("DMARC lookup error for domain _dmarc.%s: %v", domain, err)
+		return DMARCLookupFailure, "", "DMARC lookup failed for domain " + domain + ": " + err.Error()
+	}
+
+	queried := "_dmarc." + domain
+	policyDomain, subdomain := domain, false
+	if record == "" {
+		if orgDomain := baseDomain(domain); orgDomain != domain {
+			record, err = d.lookupDMARC(ctx, orgDomain)
+			if err != nil {
+				log.Printf("DMARC lookup error for domain _dmarc.%s: %v", orgDomain, err)
+				return DMARCLookupFailure, "", "DMARC lookup failed for domain " + orgDomain + ": " + err.Error()
+			}
+			policyDomain, subdomain = orgDomain, true
+			queried += " and _dmarc." + orgDomain
+		}
 	}
 
-	// In a real implementation, we would verify the DKIM signature
-	// For this example, we'll just check if the DKIM signature contains the From domain
-	dkimSignature := email.GetHeaderValue("DKIM-Signature")
-	if !strings.Contains(dkimSignature, domain) {
-		return "DKIM signature domain doesn't match From domain"
+	if record == "" {
+		return "missing", "", "Domain " + domain + " doesn't have a DMARC record (queried " + queried + ")"
 	}
 
-	return ""
+	tags := parseDMARCTags(record)
+	tag := "p"
+	if _, hasSP := tags["sp"]; subdomain && hasSP {
+		tag = "sp"
+	}
+	source := tag + "= of " + policyDomain
+	if subdomain {
+		source += ", no record at _dmarc." + domain
+	}
+
+	switch policy := strings.ToLower(tags[tag]); policy {
+	case "reject", "quarantine":
+		return policy, source, ""
+	case "none":
+		return policy, source, "Domain " + domain + " has a monitoring-only DMARC policy (" + source + ")"
+	default:
+		return "unknown", source, "Domain " + domain + " has an unknown DMARC policy (" + source + ")"
+	}
 }
 
-// checkDMARC verifies if the domain has a DMARC policy
-func (d *SpoofDetector) checkDMARC(email *models.Email, domain string) string {
-	// In a real implementation, this would check the domain's DMARC policy
-	// For this example, we'll just check if the domain has a DMARC record
-	
-	dmarcDomain := "_dmarc." + domain
-	txtRecords, err := net.LookupTXT(dmarcDomain)
+// lookupDMARC fetches the DMARC record published for a domain, returning an empty
+// string if there is none
+func (d *SpoofDetector) lookupDMARC(ctx context.Context, domain string) (string, error) {
+	txtRecords, err := d.resolver.LookupTXT(ctx, "_dmarc."+domain)
 	if err != nil {
-		log.Printf("DMARC lookup error for domain %s: %v", dmarcDomain, err)
-		return "DMARC lookup failed for domain " + domain
+		if isNotFound(err) {
+			return "", nil
+		}
+		return "", err
 	}
 
-	// Check if any of the TXT records is a DMARC record
-	dmarcRecord := ""
 	for _, record := range txtRecords {
 		if strings.HasPrefix(record, "v=DMARC1") {
-			dmarcRecord = record
-			break
+			return record, nil
 		}
 	}
 
-	if dmarcRecord == "" {
-		return "Domain " + domain + " doesn't have a DMARC record"
-	}
+	return "", nil
+}
 
-	// In a real implementation, we would check the DMARC policy
-	// For this example, we'll just check if the DMARC policy is restrictive
-	if strings.Contains(dmarcRecord, "p=reject") {
-		// Domain has a strict DMARC policy
-		return ""
-	} else if strings.Contains(dmarcRecord, "p=quarantine") {
-		// Domain has a moderate DMARC policy
-		return ""
-	} else if strings.Contains(dmarcRecord, "p=none") {
-		// Domain has a monitoring-only DMARC policy
-		return "Domain " + domain + " has a monitoring-only DMARC policy"
-	} else {
-		// Domain has an unknown DMARC policy
-		return "Domain " + domain + " has an unknown DMARC policy"
+// parseDMARCTags splits a DMARC record into its lower-cased tag names and values
+func parseDMARCTags(record string) map[string]string {
+	tags := map[string]string{}
+	for _, pair := range strings.Split(record, ";") {
+		name, value, found := strings.Cut(pair, "=")
+		if !found {
+			continue
+		}
+		tags[strings.ToLower(strings.TrimSpace(name))] = strings.TrimSpace(value)
 	}
+	return tags
 }
@@ -0,0 +1,41 @@
+package detector
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/user/email_spoof_detection/models"
+	"github.com/user/email_spoof_detection/utils"
+)
+
+// TestAnalyzeDeterministic checks that analyzing the same email, one at a time
+// or many at once, gives the findings in the same order every run
+func TestAnalyzeDeterministic(t *testing.T) {
+	email, err := utils.ParseEmail([]byte(selectionEmail))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := DefaultConfig()
+	config.Offline = true
+	d := NewSpoofDetectorWithConfig(config)
+
+	first := d.Analyze(email)
+	if len(first.Findings) < 3 || first.PrimaryReason == "" {
+		t.Fatalf("selection email gives findings %v with primary reason %q", first.Findings, first.PrimaryReason)
+	}
+
+	emails := make([]*models.Email, 2*batchWorkers)
+	for i := range emails {
+		emails[i] = email
+	}
+	for i, result := range append(d.AnalyzeBatch(emails), d.Analyze(email)) {
+		if !reflect.DeepEqual(result.Findings, first.Findings) || !reflect.DeepEqual(result.Reasons, first.Reasons) {
+			t.Fatalf("run %d: findings %v, want %v", i, result.Findings, first.Findings)
+		}
+		if result.PrimaryReason != first.PrimaryReason || result.Score != first.Score {
+			t.Errorf("run %d: primary reason %q with score %d, want %q with %d",
+				i, result.PrimaryReason, result.Score, first.PrimaryReason, first.Score)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package detector
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/user/email_spoof_detection/models"
+)
+
+// diagnosticLog collects the diagnostics of the rules of an analysis, which run
+// concurrently
+type diagnosticLog struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+// add records a diagnostic
+func (l *diagnosticLog) add(message string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.messages = append(l.messages, message)
+}
+
+// flush adds the collected diagnostics to a result, sorted as the order the rules
+// finished in varies between runs
+func (l *diagnosticLog) flush(result *models.AnalysisResult) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	sort.Strings(l.messages)
+	for _, message := range l.messages {
+		result.AddDiagnostic(message)
+	}
+	l.messages = nil
+}
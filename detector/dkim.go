@@ -0,0 +1,134 @@
+package detector
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/user/email_spoof_detection/models"
+)
+
+// wspRunPattern matches a run of spaces and tabs
+var wspRunPattern = regexp.MustCompile(`[ \t]+`)
+
+// dkimSignature holds the tags of a DKIM-Signature header needed to check the body
+// hash and locate the signing key
+type dkimSignature struct {
+	Domain    string // d= tag, lower-cased
+	Selector  string // s= tag, lower-cased
+	Algorithm string // a= tag, e.g. "rsa-sha256"
+	BodyCanon string // Body canonicalization, "simple" or "relaxed"
+	BodyHash  string // bh= tag, base64
+	Length    int    // l= tag, -1 when the whole body is signed
+}
+
+// parseDKIMSignature parses the tag list of a DKIM-Signature header value
+func parseDKIMSignature(value string) dkimSignature {
+	tags := parseDMARCTags(value)
+
+	signature := dkimSignature{
+		Domain:    strings.ToLower(tags["d"]),
+		Selector:  strings.ToLower(tags["s"]),
+		Algorithm: strings.ToLower(tags["a"]),
+		BodyCanon: "simple",
+		// Base64 values may be folded with whitespace
+		BodyHash: strings.Join(strings.Fields(tags["bh"]), ""),
+		Length:   -1,
+	}
+
+	if _, body, found := strings.Cut(strings.ToLower(tags["c"]), "/"); found {
+		signature.BodyCanon = body
+	}
+	if length, err := strconv.Atoi(tags["l"]); err == nil && length >= 0 {
+		signature.Length = length
+	}
+
+	return signature
+}
+
+// dkimSignatureFor returns the first DKIM signature whose signing domain is related
+// to the given domain, and whether one was found
+func dkimSignatureFor(email *models.Email, domain string) (dkimSignature, bool) {
+	for _, value := range email.GetAllHeaderValues("DKIM-Signature") {
+		signature := parseDKIMSignature(value)
+		if signature.Domain != "" && isRelatedDomain(signature.Domain, strings.ToLower(domain)) {
+			return signature, true
+		}
+	}
+	return dkimSignature{}, false
+}
+
+// canonicalizeDKIMBody applies the "simple" or "relaxed" body canonicalization of
+// RFC 6376 section 3.4
+func canonicalizeDKIMBody(body []byte, canon string) []byte {
+	lines := strings.Split(strings.ReplaceAll(string(body), "\r\n", "\n"), "\n")
+
+	var buf bytes.Buffer
+	for _, line := range lines {
+		if canon == "relaxed" {
+			line = strings.TrimRight(wspRunPattern.ReplaceAllString(line, " "), " ")
+		}
+		buf.WriteString(line)
+		buf.WriteString("\r\n")
+	}
+
+	// Both canonicalizations ignore trailing empty lines
+	canonical := bytes.TrimRight(buf.Bytes(), "\r\n")
+	if len(canonical) == 0 && canon == "relaxed" {
+		return nil
+	}
+	return append(canonical, '\r', '\n')
+}
+
+// dkimBodyHashMatches checks the signature's body hash against the signed part of the body
+func dkimBodyHashMatches(signature dkimSignature, body []byte) bool {
+	var h hash.Hash
+	switch {
+	case strings.HasSuffix(signature.Algorithm, "sha256"):
+		h = sha256.New()
+	case strings.HasSuffix(signature.Algorithm, "sha1"):
+		h = sha1.New()
+	default:
+		return false
+	}
+
+	canonical := canonicalizeDKIMBody(body, signature.BodyCanon)
+	if signature.Length >= 0 && signature.Length < len(canonical) {
+		canonical = canonical[:signature.Length]
+	}
+
+	h.Write(canonical)
+	return base64.StdEncoding.EncodeToString(h.Sum(nil)) == signature.BodyHash
+}
+
+// checkDKIMLengthLimit reports DKIM signatures of the From domain that use the l=
+// tag, flagging them when content the signature doesn't cover was appended after
+// the signed length
+func checkDKIMLengthLimit(email *models.Email, result *models.AnalysisResult) {
+	if email.From == nil || !email.HasBody {
+		return
+	}
+
+	signature, found := dkimSignatureFor(email, models.GetDomain(email.From))
+	if !found || signature.Length < 0 {
+		return
+	}
+
+	canonical := canonicalizeDKIMBody([]byte(email.Body), signature.BodyCanon)
+	if signature.Length >= len(canonical) ||
+		len(bytes.TrimSpace(canonical[signature.Length:])) == 0 {
+		result.AddFinding("dkim_length_limit", models.SeverityInfo, 0,
+			fmt.Sprintf("DKIM signature of %s uses l=%d; the whole body is signed", signature.Domain, signature.Length))
+		return
+	}
+
+	result.AddFinding("dkim_unsigned_content", models.SeverityHigh, 4,
+		fmt.Sprintf("DKIM signature of %s signs only the first %d body bytes (l=%d); %d bytes of unsigned content follow",
+			signature.Domain, signature.Length, signature.Length, len(canonical)-signature.Length))
+}
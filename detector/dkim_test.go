@@ -0,0 +1,102 @@
+package detector
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/user/email_spoof_detection/models"
+	"github.com/user/email_spoof_detection/utils"
+)
+
+// analyzeOffline parses and analyzes a raw email without DNS
+func analyzeOffline(t *testing.T, raw string) *models.AnalysisResult {
+	t.Helper()
+	email, err := utils.ParseEmail([]byte(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := DefaultConfig()
+	config.Offline = true
+	return NewSpoofDetectorWithConfig(config).Analyze(email)
+}
+
+// hasFinding checks if the result has a finding of the named rule
+func hasFinding(result *models.AnalysisResult, name string) bool {
+	for _, finding := range result.Findings {
+		if finding.Rule == name {
+			return true
+		}
+	}
+	return false
+}
+
+func TestHeadersOnlyEmail(t *testing.T) {
+	const header = "DKIM-Signature: v=1; a=rsa-sha256; c=relaxed/relaxed; d=example.com; s=mail;\r\n" +
+		" h=from:subject; bh=AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=; l=5; b=AAAA\r\n" +
+		"From: Alice <alice@example.com>\r\n" +
+		"To: bob@example.org\r\n" +
+		"Subject: Hello\r\n" +
+		"Date: Mon, 3 Jun 2024 10:00:00 +0000\r\n" +
+		"Message-ID: <1@example.com>\r\n"
+
+	// Without a body there is nothing to hash, so the signature isn't failed
+	result := analyzeOffline(t, header)
+	if result.Authentication.DKIM != DKIMUnverified {
+		t.Errorf("headers-only DKIM = %q, want %q", result.Authentication.DKIM, DKIMUnverified)
+	}
+	for _, name := range []string{"dkim", "dkim_unsigned_content", "dkim_length_limit"} {
+		if hasFinding(result, name) {
+			t.Errorf("headers-only email reports %s", name)
+		}
+	}
+
+	// With a body the same signature doesn't match it
+	result = analyzeOffline(t, header+"\r\nHello Bob, see https://example.com/\r\n")
+	if result.Authentication.DKIM != DKIMFail {
+		t.Errorf("DKIM with a body = %q, want %q", result.Authentication.DKIM, DKIMFail)
+	}
+	if !hasFinding(result, "dkim") {
+		t.Error("mismatching body hash isn't reported")
+	}
+}
+
+// TestDKIMBodyHash checks the body hash of a signature against a fixture whose
+// hash matches its body and a copy whose link was changed after signing
+func TestDKIMBodyHash(t *testing.T) {
+	valid, err := os.ReadFile("testdata/dkim_valid_body.eml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered, err := os.ReadFile("testdata/dkim_tampered_body.eml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Relaxed canonicalization ignores whitespace at the end of lines and the
+	// end of the body
+	header, body, _ := strings.Cut(string(valid), "\n\n")
+	respaced := header + "\n\n" + strings.ReplaceAll(body, "\n", " \t\r\n") + "\r\n\r\n"
+
+	tests := []struct {
+		name string
+		raw  string
+		dkim string
+	}{
+		{"valid body", string(valid), DKIMUnverified},
+		{"valid body with CRLF and trailing whitespace", respaced, DKIMUnverified},
+		{"tampered body", string(tampered), DKIMFail},
+		{"appended content", string(valid) + "<p>Also see https://accounts-example.top/</p>\n", DKIMFail},
+	}
+
+	for _, tt := range tests {
+		result := analyzeOffline(t, tt.raw)
+		if result.Authentication.DKIM != tt.dkim {
+			t.Errorf("%s: DKIM = %q, want %q", tt.name, result.Authentication.DKIM, tt.dkim)
+		}
+		if reported := hasFinding(result, "dkim"); reported != (tt.dkim == DKIMFail) {
+			t.Errorf("%s: dkim finding reported %v, want %v", tt.name, reported, tt.dkim == DKIMFail)
+		}
+	}
+}
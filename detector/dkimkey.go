@@ -0,0 +1,117 @@
+package detector
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/user/email_spoof_detection/models"
+)
+
+// DKIM key states found by checkDKIMKeys
+const (
+	dkimKeyFound    = iota
+	dkimKeyNotFound // No TXT record for the selector
+	dkimKeyNoTag    // Record without a p= tag
+	dkimKeyRevoked  // Record with an empty p= tag
+	dkimKeyUnknown  // Lookup failed
+)
+
+// checkDKIMKeys looks up the public key record of the selector of each DKIM
+// signature and reports records that don't exist, lack the p= tag or revoke the
+// key, so an operator can tell a botched key rotation from a forged signature.
+// A signature of the From domain whose key is unusable can't verify, so DKIM is
+// then recorded as failed.
+func (d *SpoofDetector) checkDKIMKeys(ctx context.Context, email *models.Email, result *models.AnalysisResult) {
+	values := email.GetAllHeaderValues("DKIM-Signature")
+	if len(values) == 0 {
+		return
+	}
+	if d.config.Offline {
+		result.NotEvaluated = append(result.NotEvaluated, "dkim_key")
+		return
+	}
+
+	fromDomain := strings.ToLower(models.GetDomain(email.From))
+	var notFound, noTag, revoked, failed []string
+	seen := map[string]bool{}
+	for _, value := range values {
+		signature := parseDKIMSignature(value)
+		if signature.Domain == "" || signature.Selector == "" {
+			continue
+		}
+		location := signature.Selector + "._domainkey." + signature.Domain
+		if seen[location] {
+			continue
+		}
+		seen[location] = true
+
+		state := d.lookupDKIMKey(ctx, location)
+		switch state {
+		case dkimKeyNotFound:
+			notFound = append(notFound, location)
+		case dkimKeyNoTag:
+			noTag = append(noTag, location)
+		case dkimKeyRevoked:
+			revoked = append(revoked, location)
+		case dkimKeyUnknown:
+			failed = append(failed, location)
+		}
+
+		unusable := state == dkimKeyNotFound || state == dkimKeyNoTag || state == dkimKeyRevoked
+		if unusable && fromDomain != "" && isRelatedDomain(signature.Domain, fromDomain) {
+			result.Authentication.DKIM = DKIMFail
+		}
+	}
+
+	if len(notFound) > 0 {
+		result.AddFinding("dkim_key_not_found", models.SeverityMedium, 2,
+			"DKIM selector record not found: "+strings.Join(notFound, ", "))
+	}
+	if len(noTag) > 0 {
+		result.AddFinding("dkim_key_invalid", models.SeverityLow, 1,
+			"DKIM selector record found but has no p= tag: "+strings.Join(noTag, ", "))
+	}
+	if len(revoked) > 0 {
+		result.AddFinding("dkim_key_revoked", models.SeverityMedium, 2,
+			"DKIM key revoked (empty p= tag): "+strings.Join(revoked, ", "))
+	}
+	if len(failed) > 0 {
+		result.AddDiagnostic("DKIM selector record lookup failed: " + strings.Join(failed, ", "))
+	}
+}
+
+// lookupDKIMKey looks up the key record at selector._domainkey.domain and
+// classifies it
+func (d *SpoofDetector) lookupDKIMKey(ctx context.Context, location string) int {
+	txtRecords, err := d.resolver.LookupTXT(ctx, location)
+	if err != nil {
+		if isNotFound(err) {
+			return dkimKeyNotFound
+		}
+		log.Printf("DKIM key lookup error for %s: %v", location, err)
+		return dkimKeyUnknown
+	}
+	if len(txtRecords) == 0 {
+		return dkimKeyNotFound
+	}
+
+	// A selector has a single key record; other TXT records at the name don't count
+	record := txtRecords[0]
+	for _, candidate := range txtRecords {
+		if strings.Contains(candidate, "p=") {
+			record = candidate
+			break
+		}
+	}
+
+	tags := parseDMARCTags(record)
+	key, hasKey := tags["p"]
+	switch {
+	case !hasKey:
+		return dkimKeyNoTag
+	case strings.Join(strings.Fields(key), "") == "":
+		return dkimKeyRevoked
+	}
+	return dkimKeyFound
+}
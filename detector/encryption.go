@@ -0,0 +1,33 @@
+package detector
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/user/email_spoof_detection/models"
+)
+
+// bodyContentRules lists the rules that inspect the message body, which can't
+// be evaluated on an encrypted body
+var bodyContentRules = []string{
+	"content_type_mismatch",
+	"suspicious_links",
+	"high_link_density",
+	"body_brand_contact",
+}
+
+// skipEncryptedBody drops the findings of body content rules for an encrypted
+// email and notes that its content wasn't checked. The header and
+// authentication rules apply as usual.
+func (d *SpoofDetector) skipEncryptedBody(email *models.Email, result *models.AnalysisResult) {
+	if email.Encryption == "" {
+		return
+	}
+	result.Encryption = email.Encryption
+
+	message := "Body encrypted (" + email.Encryption + "); content checks skipped"
+	if dropped := result.RemoveFindings(bodyContentRules...); len(dropped) > 0 {
+		message += fmt.Sprintf(", ignoring %s", strings.Join(dropped, ", "))
+	}
+	result.AddFinding("encrypted_body", models.SeverityInfo, 0, message)
+}
@@ -0,0 +1,140 @@
+package detector
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+
+	"github.com/user/email_spoof_detection/models"
+)
+
+// GeoIPLookup resolves the country and network of an IP address from an offline database
+type GeoIPLookup interface {
+	Lookup(ip net.IP) (models.IPInfo, error)
+}
+
+// genericTLDs are top-level domains that don't imply a country
+var genericTLDs = map[string]bool{
+	"com": true, "net": true, "org": true, "edu": true, "gov": true, "mil": true,
+	"int": true, "info": true, "biz": true, "io": true, "co": true, "me": true,
+	"tv": true, "ai": true, "app": true, "dev": true, "cloud": true,
+}
+
+// locateOrigin records the sending IP of an email and, when GeoIP or ASN
+// databases are configured, its country and autonomous system
+func (d *SpoofDetector) locateOrigin(email *models.Email, result *models.AnalysisResult) {
+	position, hop := d.originHop(email)
+	ip := hop.FromIP
+	if ip == nil {
+		return
+	}
+
+	result.Origin = &models.OriginInfo{IP: ip.String(), Hop: position}
+
+	for _, db := range []GeoIPLookup{d.config.GeoIP, d.config.ASN} {
+		if db == nil {
+			continue
+		}
+
+		info, err := db.Lookup(ip)
+		if err != nil {
+			log.Printf("GeoIP lookup error for %s: %v", ip, err)
+			continue
+		}
+
+		if info.Country != "" {
+			result.Origin.Country = info.Country
+		}
+		if info.ASN != 0 {
+			result.Origin.ASN = info.ASN
+			result.Origin.Organization = info.Organization
+		}
+	}
+}
+
+// checkOriginCountry flags emails sent from a high-risk country, or from a country
+// that doesn't match the country-code TLD of the From domain
+func (d *SpoofDetector) checkOriginCountry(email *models.Email, result *models.AnalysisResult) {
+	if result.Origin == nil || result.Origin.Country == "" {
+		return
+	}
+	country := strings.ToUpper(result.Origin.Country)
+
+	for _, risky := range d.config.HighRiskCountries {
+		if strings.EqualFold(risky, country) {
+			result.AddFinding("high_risk_origin_country", models.SeverityMedium, 2,
+				"Email was sent from a high-risk country: "+country+" ("+result.Origin.IP+")")
+			return
+		}
+	}
+
+	fromDomain := models.GetDomain(email.From)
+	if claimed := countryFromTLD(fromDomain); claimed != "" && claimed != country {
+		result.AddFinding("origin_country_mismatch", models.SeverityLow, 1,
+			"Email from "+fromDomain+" ("+claimed+") was sent from "+country+" ("+result.Origin.IP+")")
+	}
+}
+
+// checkBrandOriginCountry flags email from, or impersonating, a brand that was
+// sent from a country the brand doesn't send email from
+func (d *SpoofDetector) checkBrandOriginCountry(email *models.Email, result *models.AnalysisResult) {
+	if len(d.config.BrandCountries) == 0 || email.From == nil || result.Origin == nil || result.Origin.Country == "" {
+		return
+	}
+
+	brand := d.brandOf(email)
+	expected := d.config.BrandCountries[brand]
+	if brand == "" || len(expected) == 0 {
+		return
+	}
+
+	country := strings.ToUpper(result.Origin.Country)
+	for _, candidate := range expected {
+		if strings.EqualFold(candidate, country) {
+			return
+		}
+	}
+
+	result.AddFinding("brand_origin_country", models.SeverityMedium, 3,
+		"Email from "+brand+" was sent from "+country+" ("+result.Origin.IP+"), not one of its expected countries ("+
+			strings.ToUpper(strings.Join(expected, ", "))+")")
+}
+
+// countryFromTLD returns the ISO country code implied by a country-code TLD, or
+// an empty string for generic TLDs
+func countryFromTLD(domain string) string {
+	tld := strings.ToLower(domain[strings.LastIndex(domain, ".")+1:])
+	if len(tld) != 2 || genericTLDs[tld] {
+		return ""
+	}
+	if tld == "uk" {
+		return "GB"
+	}
+	return strings.ToUpper(tld)
+}
+
+// checkOriginASN flags emails sent from a suspicious hosting or bulletproof network,
+// with a higher severity when the From domain belongs to a well-known brand
+func (d *SpoofDetector) checkOriginASN(email *models.Email, result *models.AnalysisResult) {
+	if result.Origin == nil || result.Origin.ASN == 0 {
+		return
+	}
+
+	for _, asn := range d.config.SuspiciousASNs {
+		if asn != result.Origin.ASN {
+			continue
+		}
+
+		network := fmt.Sprintf("AS%d %s (%s)", asn, result.Origin.Organization, result.Origin.IP)
+		fromDomain := strings.ToLower(models.GetDomain(email.From))
+		if commonDomains[fromDomain] {
+			result.AddFinding("brand_from_suspicious_asn", models.SeverityHigh, 4,
+				"Email from "+fromDomain+" was sent from a hosting network: "+network)
+		} else {
+			result.AddFinding("suspicious_origin_asn", models.SeverityMedium, 2,
+				"Email was sent from a suspicious network: "+network)
+		}
+		return
+	}
+}
@@ -0,0 +1,53 @@
+package detector
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/user/email_spoof_detection/models"
+)
+
+// DefaultMaxHeaderCount is the number of occurrences of a header field above
+// which it is flagged. MTAs reject mail relayed this many times as a loop.
+const DefaultMaxHeaderCount = 50
+
+// DefaultMaxHeaderLength is the length, in bytes after unfolding, above which a
+// header value is flagged
+const DefaultMaxHeaderLength = 8192
+
+// checkHeaderLimits flags header fields that occur an abnormal number of times or
+// have absurdly long values, as produced by tools trying to overflow parsers or
+// push the real headers out of view
+func (d *SpoofDetector) checkHeaderLimits(email *models.Email, result *models.AnalysisResult) {
+	names := make([]string, 0, len(email.Headers))
+	for name := range email.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var anomalies []string
+	for _, name := range names {
+		values := email.Headers[name]
+		if max := d.config.MaxHeaderCount; max > 0 && len(values) > max {
+			anomalies = append(anomalies, fmt.Sprintf("%s appears %d times (limit %d)", name, len(values), max))
+		}
+
+		longest := 0
+		for _, value := range values {
+			if len(value) > longest {
+				longest = len(value)
+			}
+		}
+		if max := d.config.MaxHeaderLength; max > 0 && longest > max {
+			anomalies = append(anomalies, fmt.Sprintf("%s is %d bytes long (limit %d)", name, longest, max))
+		}
+	}
+
+	if len(anomalies) == 0 {
+		return
+	}
+
+	result.AddFinding("header_limit_exceeded", models.SeverityMedium, 2,
+		"Abnormal header fields: "+strings.Join(anomalies, ", "))
+}
@@ -0,0 +1,65 @@
+package detector
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"github.com/user/email_spoof_detection/models"
+)
+
+// heloRule returns the check of the HELO/EHLO identity announced by the sending server
+func (d *SpoofDetector) heloRule(ctx context.Context, diagnostics *diagnosticLog) Rule {
+	return Rule{
+		Name:        "suspicious_helo",
+		Description: "HELO/EHLO identity of the sending server is an IP literal, doesn't resolve, or falsely claims the From domain",
+		Weight:      2,
+		Severity:    models.SeverityMedium,
+		Network:     true,
+		CheckFunc: func(email *models.Email) (bool, string) {
+			return d.checkHELO(ctx, email, diagnostics)
+		},
+	}
+}
+
+// checkHELO checks the HELO/EHLO name recorded in the Received header of the
+// sending server for signs of spoofing infrastructure, recording a failed lookup
+// in diagnostics
+func (d *SpoofDetector) checkHELO(ctx context.Context, email *models.Email, diagnostics *diagnosticLog) (bool, string) {
+	_, hop := d.originHop(email)
+	helo, ip := hop.FromHELO, hop.FromIP
+	if helo == "" {
+		return false, ""
+	}
+
+	if strings.HasPrefix(helo, "[") || net.ParseIP(helo) != nil {
+		return true, "Sending server " + ip.String() + " announced an IP literal (" + helo + ") as its HELO identity"
+	}
+
+	if !strings.Contains(helo, ".") {
+		return true, "HELO identity " + helo + " of sending server " + ip.String() + " isn't a fully qualified domain name"
+	}
+
+	ips, err := d.resolver.LookupIP(ctx, "ip", helo)
+	if err != nil {
+		if isNotFound(err) {
+			return true, "HELO identity " + helo + " of sending server " + ip.String() + " doesn't resolve"
+		}
+		// Inconclusive without DNS
+		diagnostics.add("HELO identity lookup failed for " + helo + ": " + err.Error())
+		return false, ""
+	}
+
+	// A server claiming to be part of the From domain should resolve to the sending IP
+	fromDomain := models.GetDomain(email.From)
+	if fromDomain != "" && isRelatedDomain(helo, fromDomain) {
+		for _, heloIP := range ips {
+			if heloIP.Equal(ip) {
+				return false, ""
+			}
+		}
+		return true, "HELO identity " + helo + " claims the From domain " + fromDomain + " but doesn't resolve to the sending IP " + ip.String()
+	}
+
+	return false, ""
+}
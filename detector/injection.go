@@ -0,0 +1,49 @@
+package detector
+
+import (
+	"io"
+	"mime"
+	"strings"
+
+	"golang.org/x/text/encoding/htmlindex"
+
+	"github.com/user/email_spoof_detection/models"
+)
+
+// injectableHeaders lists the address and subject fields whose decoded values are
+// commonly re-emitted by downstream consumers
+var injectableHeaders = []string{"From", "Sender", "Reply-To", "To", "Cc", "Subject"}
+
+// readCharset converts encoded words in any charset the standard decoder doesn't
+// know. Words in a charset that's unknown altogether are read as they are, since
+// an unknown charset mustn't hide the CR and LF of the words around it.
+func readCharset(charset string, input io.Reader) (io.Reader, error) {
+	if encoding, err := htmlindex.Get(charset); err == nil {
+		return encoding.NewDecoder().Reader(input), nil
+	}
+	return input, nil
+}
+
+// checkHeaderInjection checks for RFC 2047 encoded words that decode to CR or LF,
+// which inject extra header lines once the value is decoded and written out again
+func checkHeaderInjection(email *models.Email) (bool, string) {
+	decoder := &mime.WordDecoder{CharsetReader: readCharset}
+
+	var fields []string
+	for _, name := range injectableHeaders {
+		for _, value := range email.GetAllHeaderValues(name) {
+			decoded, err := decoder.DecodeHeader(value)
+			if err != nil || !strings.ContainsAny(decoded, "\r\n") {
+				continue
+			}
+			fields = append(fields, name)
+			break
+		}
+	}
+
+	if len(fields) == 0 {
+		return false, ""
+	}
+
+	return true, "Header injection attempted: encoded CR/LF in " + strings.Join(fields, ", ")
+}
@@ -0,0 +1,47 @@
+package detector
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/user/email_spoof_detection/utils"
+)
+
+func TestCheckHeaderInjection(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		fields string // Fields reported, empty for no injection
+	}{
+		{"plain subject", "Subject: Invoice 42", ""},
+		{"encoded subject", "Subject: =?UTF-8?B?UmVjaHVuZyDDvGJlciA0MiDigqw=?=", ""},
+
+		// CR and LF inside B and Q encoded words
+		{"B encoded CRLF", "Subject: =?UTF-8?B?SGVsbG8NCkJjYzogdmljdGltQGV4YW1wbGUuY29t?=", "Subject"},
+		{"Q encoded CRLF", "Subject: =?UTF-8?Q?Hello=0D=0ABcc:_victim@example.com?=", "Subject"},
+		{"Q encoded bare LF", "Subject: =?us-ascii?q?Hello=0ABcc:_victim@example.com?=", "Subject"},
+		{"CRLF in a display name", "Reply-To: =?UTF-8?Q?Support=0D=0ABcc:_x@example.com?= <help@example.org>", "Reply-To"},
+		{"CRLF after a clean word", "Subject: =?UTF-8?Q?Hello?= =?ISO-8859-1?Q?=0D=0AX-Spam:_no?=", "Subject"},
+
+		// Charsets the standard decoder doesn't know
+		{"windows-1252 CRLF", "Subject: =?windows-1252?Q?Hello=0D=0ABcc:_victim@example.com?=", "Subject"},
+		{"unknown charset CRLF", "Subject: =?x-no-such-charset?Q?Hello=0D=0ABcc:_victim@example.com?=", "Subject"},
+		{"unknown charset hiding a later CRLF", "Subject: =?x-bogus?Q?Hi?= =?UTF-8?B?DQpCY2M6IHZpY3RpbUBleGFtcGxlLmNvbQ==?=", "Subject"},
+		{"unknown charset without CRLF", "Subject: =?x-bogus?Q?Hello?=", ""},
+
+		// Malformed encoded words are left as they are
+		{"invalid base64", "Subject: =?UTF-8?B?!!!DQo?=", ""},
+	}
+
+	for _, tt := range tests {
+		email, err := utils.ParseEmail([]byte("From: alice@example.com\r\n" + tt.header + "\r\n\r\nHello\r\n"))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		found, reason := checkHeaderInjection(email)
+		if found != (tt.fields != "") || !strings.HasSuffix(reason, tt.fields) {
+			t.Errorf("%s: checkHeaderInjection = %v, %q, want fields %q", tt.name, found, reason, tt.fields)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package detector
+
+import (
+	"net"
+	"testing"
+
+	"github.com/user/email_spoof_detection/utils"
+)
+
+// TestInternalDomainSpoofForwarded checks that internal email failing SPF, as when
+// forwarded from elsewhere, is authenticated by an aligned DKIM or DMARC pass of
+// the trusted receiving server, and only by that
+func TestInternalDomainSpoofForwarded(t *testing.T) {
+	fake := &fakeResolver{
+		txt: map[string][]string{
+			"corp.example":        {"v=spf1 ip4:192.0.2.1 -all"},
+			"_dmarc.corp.example": {"v=DMARC1; p=reject"},
+		},
+		ip: map[string][]net.IP{DefaultDNSProbeHost: ips("198.41.0.4")},
+	}
+	config := DefaultConfig()
+	config.Resolver = fake
+	config.InternalDomains = []string{"corp.example"}
+	config.TrustedAuthServIDs = []string{"mx.corp.example"}
+	d := NewSpoofDetectorWithConfig(config)
+
+	// Forwarded by a list server that isn't in the SPF record
+	const message = "From: Alice <alice@corp.example>\r\n" +
+		"To: team@lists.example.org\r\n" +
+		"Subject: Quarterly numbers\r\n" +
+		"Date: Mon, 3 Jun 2024 10:00:00 +0000\r\n" +
+		"Message-ID: <1@corp.example>\r\n" +
+		"Received: from lists.example.org (lists.example.org [198.51.100.7]) by mx.corp.example with ESMTPS; Mon, 3 Jun 2024 10:00:05 +0000\r\n" +
+		"\r\n" +
+		"Numbers attached.\r\n"
+
+	tests := []struct {
+		name    string
+		stamps  string
+		spoofed bool
+	}{
+		{"DMARC pass", "Authentication-Results: mx.corp.example; spf=fail smtp.mailfrom=lists.example.org; dkim=pass header.d=corp.example; dmarc=pass header.from=corp.example\r\n", false},
+		{"aligned DKIM pass", "Authentication-Results: mx.corp.example; spf=fail smtp.mailfrom=corp.example; dkim=pass header.d=mail.corp.example\r\n", false},
+		{"unaligned DKIM pass", "Authentication-Results: mx.corp.example; spf=fail smtp.mailfrom=corp.example; dkim=pass header.d=lists.example.org\r\n", true},
+		{"DMARC fail", "Authentication-Results: mx.corp.example; dkim=fail header.d=corp.example; dmarc=fail header.from=corp.example\r\n", true},
+		{"pass stamped by another server", "Authentication-Results: mx.attacker.example; dkim=pass header.d=corp.example; dmarc=pass header.from=corp.example\r\n", true},
+		// The latest trusted stamp decides; an older one may have been added by the sender
+		{"pass below a trusted fail", "Authentication-Results: mx.corp.example; dmarc=fail header.from=corp.example\r\n" +
+			"Authentication-Results: mx.corp.example; dmarc=pass header.from=corp.example\r\n", true},
+		{"no stamps", "", true},
+	}
+
+	for _, tt := range tests {
+		email, err := utils.ParseEmail([]byte(tt.stamps + message))
+		if err != nil {
+			t.Fatal(err)
+		}
+		result := d.Analyze(email)
+		if result.Authentication.SPF != string(SPFFail) {
+			t.Fatalf("%s: SPF = %q, want a failure for the forwarder", tt.name, result.Authentication.SPF)
+		}
+		if reported := hasFinding(result, "internal_domain_spoof"); reported != tt.spoofed {
+			t.Errorf("%s: internal_domain_spoof reported %v, want %v: %v", tt.name, reported, tt.spoofed, result.Findings)
+		}
+	}
+}
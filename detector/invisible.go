@@ -0,0 +1,133 @@
+package detector
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/user/email_spoof_detection/models"
+)
+
+// invisibleCharNames names the invisible characters most often used to break up words
+var invisibleCharNames = map[rune]string{
+	'\u00AD': "SOFT HYPHEN",
+	'\u034F': "COMBINING GRAPHEME JOINER",
+	'\u180E': "MONGOLIAN VOWEL SEPARATOR",
+	'\u200B': "ZERO WIDTH SPACE",
+	'\u200C': "ZERO WIDTH NON-JOINER",
+	'\u200D': "ZERO WIDTH JOINER",
+	'\u200E': "LEFT-TO-RIGHT MARK",
+	'\u200F': "RIGHT-TO-LEFT MARK",
+	'\u2060': "WORD JOINER",
+	'\u3164': "HANGUL FILLER",
+	'\uFEFF': "ZERO WIDTH NO-BREAK SPACE",
+}
+
+// isInvisible checks if a character renders as nothing: format characters such as
+// zero-width spaces, joiners and bidirectional marks, and the blank fillers
+func isInvisible(r rune) bool {
+	switch r {
+	case '\u034F', '\u115F', '\u1160', '\u3164', '\uFFA0':
+		return true
+	}
+	return unicode.Is(unicode.Cf, r)
+}
+
+// stripInvisible removes the invisible characters from a text, returning the
+// cleaned text and the characters removed
+func stripInvisible(text string) (string, []rune) {
+	if strings.IndexFunc(text, isInvisible) < 0 {
+		return text, nil
+	}
+
+	var cleaned strings.Builder
+	var removed []rune
+	for _, r := range text {
+		if isInvisible(r) {
+			removed = append(removed, r)
+			continue
+		}
+		cleaned.WriteRune(r)
+	}
+	return cleaned.String(), removed
+}
+
+// cleanText returns a text without its invisible characters
+func cleanText(text string) string {
+	cleaned, _ := stripInvisible(text)
+	return cleaned
+}
+
+// splitsWord checks if a text has an invisible character next to an ASCII letter or
+// digit. Joiners inside emoji sequences and marks in right-to-left or Persian text
+// are legitimate, while one inside a Latin word only serves to defeat matching.
+func splitsWord(text string) bool {
+	runes := []rune(text)
+	isWordChar := func(i int) bool {
+		return i >= 0 && i < len(runes) && runes[i] < unicode.MaxASCII &&
+			(unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]))
+	}
+
+	for i, r := range runes {
+		if !isInvisible(r) {
+			continue
+		}
+		// Look past runs of invisible characters to the visible neighbours
+		before, after := i-1, i+1
+		for before >= 0 && isInvisible(runes[before]) {
+			before--
+		}
+		for after < len(runes) && isInvisible(runes[after]) {
+			after++
+		}
+		if isWordChar(before) || isWordChar(after) {
+			return true
+		}
+	}
+	return false
+}
+
+// describeRunes lists characters as code points, each once, naming the invisible
+// ones, e.g. "U+200B ZERO WIDTH SPACE, U+0455"
+func describeRunes(removed []rune) string {
+	var described []string
+	seen := map[rune]bool{}
+	for _, r := range removed {
+		if seen[r] {
+			continue
+		}
+		seen[r] = true
+		description := fmt.Sprintf("U+%04X", r)
+		if name, known := invisibleCharNames[r]; known {
+			description += " " + name
+		}
+		described = append(described, description)
+	}
+	return strings.Join(described, ", ")
+}
+
+// checkInvisibleCharacters checks for zero-width and other invisible characters
+// inside the words of the display name or subject, as in "Pay\u200bPal", which
+// render normally but keep keyword and brand matching from seeing the word. The
+// brand checks match the cleaned text, so this notes the attempt itself.
+func checkInvisibleCharacters(email *models.Email) (bool, string) {
+	var fields []string
+	describe := func(field, value string) {
+		if !splitsWord(value) {
+			return
+		}
+		cleaned, removed := stripInvisible(value)
+		fields = append(fields, fmt.Sprintf("%s reads \"%s\" without %d invisible character(s): %s",
+			field, cleaned, len(removed), describeRunes(removed)))
+	}
+
+	if email.From != nil {
+		describe("display name", email.From.Name)
+	}
+	describe("subject", email.Subject)
+	if len(fields) == 0 {
+		return false, ""
+	}
+
+	return true, "Invisible characters break up words: " + strings.Join(fields, "; ")
+}
@@ -0,0 +1,154 @@
+package detector
+
+import (
+	"net/textproto"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/user/email_spoof_detection/models"
+)
+
+// languageStopwords lists frequent short words that identify the language of a text
+var languageStopwords = map[string][]string{
+	"en": {"the", "and", "you", "your", "is", "to", "of", "for", "this", "with", "have", "please", "are", "we"},
+	"de": {"der", "die", "das", "und", "ist", "sie", "ihr", "ihre", "nicht", "mit", "wir", "bitte", "für", "auf"},
+	"fr": {"le", "la", "les", "et", "est", "vous", "votre", "des", "pour", "une", "nous", "avec", "dans", "pas"},
+	"es": {"el", "los", "las", "y", "es", "su", "usted", "para", "una", "por", "con", "del", "que", "más"},
+	"it": {"il", "lo", "gli", "e", "di", "che", "per", "una", "sono", "con", "non", "del", "tuo", "suo"},
+	"pt": {"o", "os", "as", "e", "de", "que", "para", "uma", "com", "não", "seu", "sua", "você", "por"},
+	"nl": {"de", "het", "een", "en", "is", "van", "u", "uw", "niet", "met", "voor", "wij", "op", "dat"},
+}
+
+// minLanguageWords is the fewest stopwords a text must contain for its language
+// to be detected
+const minLanguageWords = 5
+
+// checkContentLanguage flags email from, or impersonating, a brand or domain with
+// expected languages whose declared Content-Language, or the language detected in
+// its text, is none of them
+func (d *SpoofDetector) checkContentLanguage(email *models.Email, result *models.AnalysisResult) {
+	if len(d.config.ExpectedLanguages) == 0 || email.From == nil {
+		return
+	}
+
+	sender, expected := d.expectedLanguages(email)
+	if len(expected) == 0 {
+		return
+	}
+
+	observed, source := declaredLanguages(email), "Content-Language"
+	if len(observed) == 0 {
+		if language := detectLanguage(email); language != "" {
+			observed, source = []string{language}, "detected in the body"
+		}
+	}
+
+	for _, language := range observed {
+		if !containsFold(expected, language) {
+			result.AddFinding("unexpected_language", models.SeverityLow, 1,
+				"Email from "+sender+" is in "+language+" ("+source+"), not one of its expected languages ("+
+					strings.Join(expected, ", ")+")")
+			return
+		}
+	}
+}
+
+// expectedLanguages returns the languages configured for the From domain, its
+// registrable domain or the brand the email is from or presents itself as, along
+// with the entry that matched
+func (d *SpoofDetector) expectedLanguages(email *models.Email) (string, []string) {
+	domain := strings.ToLower(models.GetDomain(email.From))
+	for _, key := range []string{domain, baseDomain(domain), d.brandOf(email)} {
+		if key == "" {
+			continue
+		}
+		if languages := d.config.ExpectedLanguages[key]; len(languages) > 0 {
+			return key, languages
+		}
+	}
+	return "", nil
+}
+
+// declaredLanguages returns the primary language subtags of the Content-Language
+// headers of an email and its text parts, such as "en" for "en-US"
+func declaredLanguages(email *models.Email) []string {
+	values := email.GetAllHeaderValues("Content-Language")
+	for _, part := range email.Parts {
+		if !part.IsAttachment() {
+			values = append(values, textproto.MIMEHeader(part.Headers).Get("Content-Language"))
+		}
+	}
+
+	var languages []string
+	for _, value := range values {
+		for _, tag := range strings.Split(value, ",") {
+			primary, _, _ := strings.Cut(strings.TrimSpace(tag), "-")
+			primary = strings.ToLower(primary)
+			if primary != "" && !containsFold(languages, primary) {
+				languages = append(languages, primary)
+			}
+		}
+	}
+	return languages
+}
+
+// detectLanguage guesses the language of the text parts of an email from their
+// stopwords, returning an empty string unless one language clearly dominates
+func detectLanguage(email *models.Email) string {
+	counts := map[string]int{}
+	for _, part := range email.Parts {
+		if part.IsAttachment() {
+			continue
+		}
+
+		var text string
+		switch part.ContentType {
+		case "text/plain":
+			text = string(part.Body)
+		case "text/html":
+			text = markupPattern.ReplaceAllString(string(part.Body), " ")
+		default:
+			continue
+		}
+
+		words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !unicode.IsLetter(r) })
+		for _, word := range words {
+			for language, stopwords := range languageStopwords {
+				if containsFold(stopwords, word) {
+					counts[language]++
+				}
+			}
+		}
+	}
+
+	languages := make([]string, 0, len(counts))
+	for language := range counts {
+		languages = append(languages, language)
+	}
+	sort.Slice(languages, func(i, j int) bool {
+		if counts[languages[i]] != counts[languages[j]] {
+			return counts[languages[i]] > counts[languages[j]]
+		}
+		return languages[i] < languages[j]
+	})
+
+	if len(languages) == 0 || counts[languages[0]] < minLanguageWords {
+		return ""
+	}
+	// Languages share some stopwords, so only a clear lead counts
+	if len(languages) > 1 && counts[languages[0]] < 2*counts[languages[1]] {
+		return ""
+	}
+	return languages[0]
+}
+
+// containsFold checks if a list contains a string, ignoring case
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+package detector
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/user/email_spoof_detection/models"
+)
+
+// DefaultMaxLinkDensity is the number of links and images per 100 characters of
+// visible text above which a body is flagged: more than one every 50 characters
+const DefaultMaxLinkDensity = 2.0
+
+var (
+	// invisibleMarkupPattern matches HTML elements whose content isn't displayed
+	invisibleMarkupPattern = regexp.MustCompile(`(?is)<(script|style|head|title)\b.*?</(script|style|head|title)\s*>|<!--.*?-->`)
+	// imageTagPattern matches an HTML image
+	imageTagPattern = regexp.MustCompile(`(?i)<img\b`)
+	// urlTextPattern matches a URL in plain text
+	urlTextPattern = regexp.MustCompile(`(?i)\bhttps?://\S+`)
+)
+
+// bodyLinkDensity measures the body of an email shown to readers, its HTML part
+// or else its plain text part, returning the number of distinct links, images
+// and visible characters it contains
+func bodyLinkDensity(email *models.Email) (links, images, characters int) {
+	var body *models.Part
+	for i, part := range email.Parts {
+		if part.IsAttachment() {
+			continue
+		}
+		if part.ContentType == "text/html" {
+			body = &email.Parts[i]
+			break
+		}
+		if part.ContentType == "text/plain" && body == nil {
+			body = &email.Parts[i]
+		}
+	}
+	if body == nil {
+		return 0, 0, 0
+	}
+
+	urls := map[string]bool{}
+	for _, link := range email.Links {
+		urls[link.URL] = true
+	}
+	links = len(urls)
+
+	text := string(body.Body)
+	if body.ContentType == "text/html" {
+		text = invisibleMarkupPattern.ReplaceAllString(text, " ")
+		images = len(imageTagPattern.FindAllStringIndex(text, -1))
+		text = html.UnescapeString(markupPattern.ReplaceAllString(text, " "))
+	} else {
+		// URLs in plain text are links, not text to read
+		text = urlTextPattern.ReplaceAllString(text, " ")
+		for _, part := range email.Parts {
+			if strings.HasPrefix(part.ContentType, "image/") && !part.IsAttachment() {
+				images++
+			}
+		}
+	}
+
+	for _, r := range cleanText(text) {
+		if !unicode.IsSpace(r) && r != utf8.RuneError {
+			characters++
+		}
+	}
+	return links, images, characters
+}
+
+// checkLinkDensity flags a body made of links and images with little text around
+// them, the shape of image-only phishing that hides its lure from text filters.
+// Newsletters are link-heavy as well, so the body is only flagged when the sender
+// isn't authenticated: SPF didn't pass and there is no aligned DKIM signature.
+func (d *SpoofDetector) checkLinkDensity(email *models.Email, result *models.AnalysisResult) {
+	max := d.config.MaxLinkDensity
+	if max <= 0 {
+		return
+	}
+
+	auth := result.Authentication
+	if auth.SPF == string(SPFPass) || auth.DKIM == DKIMUnverified {
+		return
+	}
+
+	links, images, characters := bodyLinkDensity(email)
+	if links+images == 0 {
+		return
+	}
+
+	elements := fmt.Sprintf("%d link(s) and %d image(s)", links, images)
+	weakness := " (SPF: " + orNotEvaluated(auth.SPF) + ", DKIM: " + orNotEvaluated(auth.DKIM) + ")"
+	if characters == 0 {
+		result.AddFinding("high_link_density", models.SeverityMedium, 2,
+			"Body has "+elements+" and no visible text from an unauthenticated sender"+weakness)
+		return
+	}
+
+	density := float64(links+images) * 100 / float64(characters)
+	if density <= max {
+		return
+	}
+	result.AddFinding("high_link_density", models.SeverityMedium, 2,
+		fmt.Sprintf("Body has %s for %d characters of visible text, %.1f per 100 characters (limit %.1f), from an unauthenticated sender%s",
+			elements, characters, density, max, weakness))
+}
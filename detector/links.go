@@ -0,0 +1,86 @@
+package detector
+
+import (
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/user/email_spoof_detection/models"
+)
+
+// DefaultURLShorteners lists URL shortening services that hide the real destination of a link
+var DefaultURLShorteners = []string{
+	"bit.ly",
+	"tinyurl.com",
+	"t.co",
+	"goo.gl",
+	"ow.ly",
+	"is.gd",
+	"buff.ly",
+	"rebrand.ly",
+	"cutt.ly",
+	"shorturl.at",
+	"rb.gy",
+	"tiny.cc",
+}
+
+// checkSuspiciousLinks flags links to bare IP addresses, URL shorteners and
+// plain HTTP links to well-known brands or their lookalikes
+func (d *SpoofDetector) checkSuspiciousLinks(email *models.Email, result *models.AnalysisResult) {
+	shorteners := d.config.URLShorteners
+	if len(shorteners) == 0 {
+		shorteners = DefaultURLShorteners
+	}
+
+	var suspicious []string
+	for _, link := range email.Links {
+		if reason := suspiciousLinkReason(link.URL, shorteners); reason != "" {
+			suspicious = append(suspicious, link.URL+" ("+reason+")")
+		}
+	}
+
+	if len(suspicious) == 0 {
+		return
+	}
+
+	result.AddFinding("suspicious_links", models.SeverityMedium, 2,
+		"Email contains suspicious links: "+strings.Join(suspicious, ", "))
+}
+
+// suspiciousLinkReason describes why a URL is suspicious, or returns an empty string
+func suspiciousLinkReason(rawURL string, shorteners []string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Host == "" {
+		return ""
+	}
+	scheme := strings.ToLower(parsed.Scheme)
+	if scheme != "http" && scheme != "https" {
+		return ""
+	}
+
+	host := strings.ToLower(strings.TrimSuffix(parsed.Hostname(), "."))
+	if net.ParseIP(host) != nil {
+		return "links to a bare IP address"
+	}
+
+	for _, shortener := range shorteners {
+		shortener = strings.ToLower(shortener)
+		if host == shortener || strings.HasSuffix(host, "."+shortener) {
+			return "URL shortener hides the destination"
+		}
+	}
+
+	if scheme == "http" {
+		registrable := baseDomain(host)
+		for _, brand := range commonDomainNames {
+			if registrable == brand {
+				return "plain HTTP link to " + brand + ", which only operates over HTTPS"
+			}
+			if isSimilarDomain(registrable, brand) {
+				return "plain HTTP link to a lookalike of " + brand
+			}
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,92 @@
+package detector
+
+import (
+	"net/mail"
+	"strings"
+
+	"github.com/user/email_spoof_detection/models"
+)
+
+// onBehalfService is a domain that handled an email for the From domain
+type onBehalfService struct {
+	domain string
+	via    string // How the service is identified, e.g. "DKIM d="
+}
+
+// checkOnBehalfSending flags email sent on behalf of the From domain by a service
+// that isn't an email service provider. For a brand's own domain any such service
+// is a sign of spoofing; otherwise an explicit Sender is noted at low weight.
+func (d *SpoofDetector) checkOnBehalfSending(email *models.Email, result *models.AnalysisResult) {
+	fromDomain := strings.ToLower(models.GetDomain(email.From))
+	if fromDomain == "" {
+		return
+	}
+
+	esps := d.config.ESPDomains
+	if len(esps) == 0 {
+		esps = DefaultESPDomains
+	}
+
+	var services []onBehalfService
+	for _, service := range onBehalfServices(email, fromDomain) {
+		if !matchesDomainList(service.domain, esps) {
+			services = append(services, service)
+		}
+	}
+	if len(services) == 0 {
+		return
+	}
+
+	for _, brand := range d.sortedBrands() {
+		legitimate := d.config.BrandDomains[brand]
+		if !isBrandDomain(fromDomain, legitimate) {
+			continue
+		}
+
+		var unexpected []string
+		for _, service := range services {
+			if !isBrandDomain(service.domain, legitimate) {
+				unexpected = append(unexpected, service.domain+" ("+service.via+")")
+			}
+		}
+		if len(unexpected) > 0 {
+			result.AddFinding("unexpected_on_behalf", models.SeverityHigh, 4,
+				"Email from "+brand+" domain "+fromDomain+" was sent on its behalf by "+strings.Join(unexpected, ", ")+
+					", which is neither one of its domains nor a known email service provider")
+		}
+		return
+	}
+
+	for _, service := range services {
+		if service.via == "Sender" {
+			result.AddFinding("unexpected_on_behalf", models.SeverityLow, 1,
+				"Email was sent by "+service.domain+" on behalf of "+fromDomain+", which isn't a known email service provider")
+			return
+		}
+	}
+}
+
+// onBehalfServices returns the Sender, DKIM signing and Return-Path domains of an
+// email that are unrelated to its From domain
+func onBehalfServices(email *models.Email, fromDomain string) []onBehalfService {
+	var services []onBehalfService
+	seen := map[string]bool{}
+	add := func(domain, via string) {
+		domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+		if domain == "" || seen[domain] || isRelatedDomain(domain, fromDomain) {
+			return
+		}
+		seen[domain] = true
+		services = append(services, onBehalfService{domain: domain, via: via})
+	}
+
+	if sender, err := mail.ParseAddress(email.GetHeaderValue("Sender")); err == nil {
+		add(models.GetDomain(sender), "Sender")
+	}
+	for _, value := range email.GetAllHeaderValues("DKIM-Signature") {
+		add(parseDKIMSignature(value).Domain, "DKIM d=")
+	}
+	add(returnPathDomain(email), "Return-Path")
+
+	return services
+}
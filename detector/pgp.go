@@ -0,0 +1,124 @@
+package detector
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/mail"
+	"os"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/clearsign"
+	pgperrors "github.com/ProtonMail/go-crypto/openpgp/errors"
+
+	"github.com/user/email_spoof_detection/models"
+)
+
+// PGP results recorded in the authentication results
+const (
+	PGPPass       = "pass"
+	PGPFail       = "fail"
+	PGPMismatch   = "mismatch"
+	PGPUnknownKey = "unknown-key"
+	PGPUnverified = "unverified"
+)
+
+// LoadPGPKeyring reads an armored or binary OpenPGP keyring of trusted public keys
+func LoadPGPKeyring(path string) (openpgp.EntityList, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading PGP keyring: %w", err)
+	}
+
+	keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	if err != nil {
+		keyring, err = openpgp.ReadKeyRing(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing PGP keyring %s: %w", path, err)
+	}
+
+	return keyring, nil
+}
+
+// checkPGPSignature verifies the PGP signature of an email against the configured
+// keyring and checks that the signing key belongs to the From address
+func (d *SpoofDetector) checkPGPSignature(email *models.Email, result *models.AnalysisResult) {
+	if email.PGP == nil {
+		return
+	}
+
+	if d.config.PGPKeyring == nil {
+		result.Authentication.PGP = PGPUnverified
+		result.AddFinding("pgp_signature", models.SeverityInfo, 0,
+			"Email has a "+email.PGP.Type+" PGP signature that wasn't verified: no keyring configured")
+		return
+	}
+
+	signer, err := verifyPGPSignature(d.config.PGPKeyring, email.PGP)
+	if errors.Is(err, pgperrors.ErrUnknownIssuer) {
+		result.Authentication.PGP = PGPUnknownKey
+		result.AddFinding("pgp_signature", models.SeverityLow, 1,
+			"PGP signature was made by a key that isn't in the keyring")
+		return
+	}
+	if err != nil {
+		result.Authentication.PGP = PGPFail
+		result.AddFinding("pgp_signature_invalid", models.SeverityHigh, 4,
+			"Email claims a PGP signature that is invalid: "+err.Error())
+		return
+	}
+
+	identities := make([]string, 0, len(signer.Identities))
+	for name := range signer.Identities {
+		identities = append(identities, name)
+	}
+
+	if email.From == nil || !pgpIdentityMatches(signer, email.From.Address) {
+		result.Authentication.PGP = PGPMismatch
+		fromAddress := ""
+		if email.From != nil {
+			fromAddress = email.From.Address
+		}
+		result.AddFinding("pgp_signer_mismatch", models.SeverityHigh, 4,
+			"PGP signing key ("+strings.Join(identities, ", ")+") doesn't belong to the From address "+fromAddress)
+		return
+	}
+
+	result.Authentication.PGP = PGPPass
+	result.AddFinding("pgp_signature", models.SeverityInfo, 0,
+		"PGP signature verified: signed by "+strings.Join(identities, ", "))
+}
+
+// verifyPGPSignature checks a PGP/MIME or inline signature, returning the signing key
+func verifyPGPSignature(keyring openpgp.KeyRing, signature *models.PGPSignature) (*openpgp.Entity, error) {
+	if signature.Type == models.PGPInline {
+		block, _ := clearsign.Decode(signature.Signed)
+		if block == nil {
+			return nil, errors.New("malformed cleartext signed message")
+		}
+		return block.VerifySignature(keyring, nil)
+	}
+
+	return openpgp.CheckArmoredDetachedSignature(keyring, bytes.NewReader(signature.Signed), bytes.NewReader(signature.Signature), nil)
+}
+
+// pgpIdentityMatches checks if any user ID of a key carries the given email address
+func pgpIdentityMatches(entity *openpgp.Entity, address string) bool {
+	for name, identity := range entity.Identities {
+		uidAddress := ""
+		if identity.UserId != nil {
+			uidAddress = identity.UserId.Email
+		}
+		if uidAddress == "" {
+			if parsed, err := mail.ParseAddress(name); err == nil {
+				uidAddress = parsed.Address
+			}
+		}
+		if strings.EqualFold(uidAddress, address) {
+			return true
+		}
+	}
+	return false
+}
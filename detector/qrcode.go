@@ -0,0 +1,85 @@
+package detector
+
+import (
+	"log"
+	"net/url"
+	"strings"
+
+	"github.com/user/email_spoof_detection/models"
+)
+
+// QRDecoder reads the QR codes of an image, returning the text of each
+type QRDecoder interface {
+	Decode(image []byte) ([]string, error)
+}
+
+// checkQRCodes decodes the QR codes of the images in an email and flags the
+// links they hide from text-based link checks ("quishing"). Every QR code link
+// is reported; those that the link checks find suspicious, or that point to a
+// brand lookalike, are weighted higher.
+func (d *SpoofDetector) checkQRCodes(email *models.Email, result *models.AnalysisResult) {
+	if d.config.QRDecoder == nil {
+		return
+	}
+
+	shorteners := d.config.URLShorteners
+	if len(shorteners) == 0 {
+		shorteners = DefaultURLShorteners
+	}
+	risky := d.config.RiskyTLDs
+	if len(risky) == 0 {
+		risky = DefaultRiskyTLDs
+	}
+
+	var links, suspicious []string
+	for _, part := range email.Parts {
+		if !strings.HasPrefix(part.ContentType, "image/") {
+			continue
+		}
+
+		texts, err := d.config.QRDecoder.Decode(part.Body)
+		if err != nil {
+			log.Printf("QR code decoding error for image %q: %v", part.Filename, err)
+			continue
+		}
+
+		for _, text := range texts {
+			link := strings.TrimSpace(text)
+			parsed, err := url.Parse(link)
+			if err != nil || parsed.Host == "" {
+				continue
+			}
+
+			result.QRLinks = append(result.QRLinks, link)
+			links = append(links, link)
+			if reason := d.qrLinkReason(parsed, shorteners, risky); reason != "" {
+				suspicious = append(suspicious, link+" ("+reason+")")
+			}
+		}
+	}
+
+	if len(suspicious) > 0 {
+		result.AddFinding("suspicious_qr_code", models.SeverityHigh, 4,
+			"Image QR code links to suspicious destinations: "+strings.Join(suspicious, ", "))
+	} else if len(links) > 0 {
+		result.AddFinding("qr_code_link", models.SeverityLow, 1,
+			"Image QR code links to "+strings.Join(links, ", "))
+	}
+}
+
+// qrLinkReason describes why a QR code link is suspicious, or returns an empty string
+func (d *SpoofDetector) qrLinkReason(link *url.URL, shorteners, riskyTLDs []string) string {
+	if reason := suspiciousLinkReason(link.String(), shorteners); reason != "" {
+		return reason
+	}
+
+	host := strings.ToLower(strings.TrimSuffix(link.Hostname(), "."))
+	if brand := d.impersonatedBrand("", host); brand != "" {
+		return "lookalike of " + brand
+	}
+	if tld := riskyTLDOf(host, riskyTLDs); tld != "" {
+		return "high-risk TLD ." + tld
+	}
+
+	return ""
+}
@@ -0,0 +1,155 @@
+package detector
+
+import (
+	"fmt"
+	"net"
+	"net/mail"
+	"strings"
+
+	"github.com/user/email_spoof_detection/models"
+	"github.com/user/email_spoof_detection/utils"
+)
+
+// receivedHops returns the parsed Received headers of an email, parsing them
+// when the email wasn't created by the parser
+func receivedHops(email *models.Email) []models.ReceivedHop {
+	headers := email.GetAllHeaderValues("Received")
+	if len(email.Received) == len(headers) {
+		return email.Received
+	}
+
+	hops := make([]models.ReceivedHop, len(headers))
+	for i, header := range headers {
+		hops[i] = utils.ParseReceived(header)
+	}
+	return hops
+}
+
+// originIP returns the IP address of the first public untrusted server in the
+// Received chain, i.e. the server that handed the email to the receiving infrastructure
+func (d *SpoofDetector) originIP(email *models.Email) net.IP {
+	_, hop := d.originHop(email)
+	return hop.FromIP
+}
+
+// originHop returns the position (1 for the most recent) and fields of the
+// Received header recording the hop from the first public server in the chain
+// that isn't one of the trusted relays, or 0 if there is none
+func (d *SpoofDetector) originHop(email *models.Email) (int, models.ReceivedHop) {
+	// Received headers are prepended, so the most recent hop comes first
+	for i, hop := range receivedHops(email) {
+		if hop.FromIP == nil || !IsPublicIP(hop.FromIP) {
+			continue
+		}
+		if d.isTrustedRelay(hop.FromIP, hop.FromHost) {
+			continue
+		}
+		return i + 1, hop
+	}
+
+	return 0, models.ReceivedHop{}
+}
+
+// isTrustedRelay checks if a client is one of the trusted relays, matching its IP
+// address against IPs and CIDR ranges and its reverse DNS name against hostnames
+// and their subdomains
+func (d *SpoofDetector) isTrustedRelay(ip net.IP, host string) bool {
+	for _, relay := range d.config.TrustedRelays {
+		relay = strings.ToLower(strings.TrimSpace(relay))
+		if _, network, err := net.ParseCIDR(relay); err == nil {
+			if network.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if relayIP := net.ParseIP(relay); relayIP != nil {
+			if relayIP.Equal(ip) {
+				return true
+			}
+			continue
+		}
+		relay = strings.TrimSuffix(relay, ".")
+		if host != "" && host != "unknown" && (host == relay || strings.HasSuffix(host, "."+relay)) {
+			return true
+		}
+	}
+	return false
+}
+
+// maxTimezoneDrift is the largest difference between the Date offset and the
+// nearest Received offset that isn't reported, in seconds
+const maxTimezoneDrift = 4 * 60 * 60
+
+// checkDateTimezoneMismatch checks if the timezone offset of the Date header is far
+// from the offsets of every Received timestamp
+func checkDateTimezoneMismatch(email *models.Email) (bool, string) {
+	date, err := mail.ParseDate(email.GetHeaderValue("Date"))
+	if err != nil {
+		return false, ""
+	}
+	_, dateOffset := date.Zone()
+
+	var hopOffsets []string
+	closest := -1
+	for _, hop := range receivedHops(email) {
+		if hop.Time.IsZero() {
+			continue
+		}
+		_, offset := hop.Time.Zone()
+		hopOffsets = append(hopOffsets, hop.Time.Format("-0700"))
+
+		drift := offset - dateOffset
+		if drift < 0 {
+			drift = -drift
+		}
+		if closest < 0 || drift < closest {
+			closest = drift
+		}
+	}
+
+	if closest <= maxTimezoneDrift {
+		return false, ""
+	}
+
+	return true, "Date header timezone (" + date.Format("-0700") + ") doesn't match the Received timezones (" +
+		strings.Join(hopOffsets, ", ") + ")"
+}
+
+// checkReceivedCount flags email with fewer Received headers than expected, which
+// suggests it was injected directly rather than relayed by mail servers
+func (d *SpoofDetector) checkReceivedCount(email *models.Email, result *models.AnalysisResult) {
+	minimum := d.config.MinReceivedHeaders
+	// Internal mail may be delivered by a single server
+	if minimum > 1 && d.isInternalOnly(email) {
+		minimum = 1
+	}
+
+	count := len(email.GetAllHeaderValues("Received"))
+	if count >= minimum {
+		return
+	}
+
+	result.AddFinding("too_few_received_headers", models.SeverityLow, 2,
+		fmt.Sprintf("Email has %d Received header(s), expected at least %d", count, minimum))
+}
+
+// isInternalOnly checks if the sender and all recipients of an email are in internal domains
+func (d *SpoofDetector) isInternalOnly(email *models.Email) bool {
+	if email.From == nil || !d.isInternalDomain(strings.ToLower(models.GetDomain(email.From))) {
+		return false
+	}
+
+	for _, recipient := range append(append([]*mail.Address{}, email.To...), email.Cc...) {
+		if !d.isInternalDomain(strings.ToLower(models.GetDomain(recipient))) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// IsPublicIP checks if an IP address is globally routable
+func IsPublicIP(ip net.IP) bool {
+	return !ip.IsPrivate() && !ip.IsLoopback() && !ip.IsLinkLocalUnicast() &&
+		!ip.IsUnspecified() && !ip.IsMulticast()
+}
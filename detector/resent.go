@@ -0,0 +1,63 @@
+package detector
+
+import (
+	"net/mail"
+	"strings"
+
+	"github.com/user/email_spoof_detection/models"
+)
+
+// checkResentHeaders flags Resent-* blocks that impersonate a brand or whose
+// resending domain isn't covered by a DKIM signature, reporting the resent chain.
+// A Resent-From takes over the role of From for the hop it describes, so it needs
+// the same scrutiny.
+func (d *SpoofDetector) checkResentHeaders(email *models.Email, result *models.AnalysisResult) {
+	if len(email.Resent) == 0 {
+		return
+	}
+
+	var problems []string
+	severity, weight := models.SeverityMedium, 2
+	for _, block := range email.Resent {
+		if block.From == nil {
+			problems = append(problems, "a Resent-* block has no valid Resent-From")
+			continue
+		}
+
+		domain := strings.ToLower(models.GetDomain(block.From))
+		if brand := d.impersonatedBrand(block.From.Name, domain); brand != "" {
+			problems = append(problems, "Resent-From "+block.From.Address+" impersonates "+brand)
+			severity, weight = models.SeverityHigh, 4
+			continue
+		}
+
+		if _, signed := dkimSignatureFor(email, domain); !signed {
+			problems = append(problems, "Resent-From domain "+domain+" has no DKIM signature")
+		}
+	}
+
+	if len(problems) == 0 {
+		return
+	}
+
+	result.AddFinding("resent_header_mismatch", severity, weight,
+		strings.Join(problems, "; ")+" (resent chain: "+resentChain(email)+")")
+}
+
+// resentChain describes the path of a re-sent email from the original sender
+// through each resender, oldest first
+func resentChain(email *models.Email) string {
+	hops := []string{addressOrUnknown(email.From)}
+	for i := len(email.Resent) - 1; i >= 0; i-- {
+		hops = append(hops, addressOrUnknown(email.Resent[i].From))
+	}
+	return strings.Join(hops, " -> ")
+}
+
+// addressOrUnknown returns the bare address, or "unknown" for a missing address
+func addressOrUnknown(address *mail.Address) string {
+	if address == nil {
+		return "unknown"
+	}
+	return address.Address
+}
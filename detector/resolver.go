@@ -0,0 +1,178 @@
+package detector
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// Resolver performs the DNS lookups needed by the detector. *net.Resolver
+// satisfies this interface; tests and offline setups can provide their own.
+type Resolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+	LookupIP(ctx context.Context, network, host string) ([]net.IP, error)
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+	LookupCNAME(ctx context.Context, host string) (string, error)
+}
+
+// isNotFound checks if a DNS error means the name or record doesn't exist,
+// as opposed to a temporary failure
+func isNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+// lookupTally counts the lookups of a single analysis that got no answer
+type lookupTally struct {
+	failed atomic.Int64
+}
+
+// lookupTallyKey is the context key of the lookupTally of an analysis
+type lookupTallyKey struct{}
+
+// withLookupTally returns a context whose failed lookups are counted in tally.
+// Each analysis counts its own, so that analyses running at the same time don't
+// see each other's failures.
+func withLookupTally(ctx context.Context, tally *lookupTally) context.Context {
+	return context.WithValue(ctx, lookupTallyKey{}, tally)
+}
+
+// recordLookup counts a lookup that got no answer in the tally of the analysis
+// that made it, if any
+func recordLookup(ctx context.Context, err error) {
+	if err == nil || isNotFound(err) {
+		return
+	}
+	if tally, ok := ctx.Value(lookupTallyKey{}).(*lookupTally); ok {
+		tally.failed.Add(1)
+	}
+}
+
+// coalescingResolver shares one in-flight lookup among concurrent callers asking
+// for the same record, so a bulk scan doesn't query the same domain many times
+// at once. Each caller's failed lookups are counted in the tally of its context.
+type coalescingResolver struct {
+	resolver Resolver
+	group    singleflight.Group
+}
+
+// lookupTimeout bounds a shared lookup, which runs apart from the contexts of the
+// callers waiting on it
+const lookupTimeout = 15 * time.Second
+
+// do makes the lookup identified by key once for all concurrent callers. The
+// lookup runs on a context of its own, so that a caller giving up, such as a
+// client disconnecting, doesn't fail the lookup for the others; each caller
+// stops waiting when its own ctx is done.
+func (r *coalescingResolver) do(ctx context.Context, key string, lookup func(context.Context) (interface{}, error)) (interface{}, error) {
+	results := r.group.DoChan(key, func() (interface{}, error) {
+		lookupCtx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+		defer cancel()
+		return lookup(lookupCtx)
+	})
+
+	select {
+	case result := <-results:
+		recordLookup(ctx, result.Err)
+		return result.Val, result.Err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// newCoalescingResolver wraps a resolver so concurrent identical lookups are coalesced
+func newCoalescingResolver(resolver Resolver) *coalescingResolver {
+	return &coalescingResolver{resolver: resolver}
+}
+
+// LookupTXT looks up the TXT records of a name
+func (r *coalescingResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	records, err := r.do(ctx, "txt:"+name, func(ctx context.Context) (interface{}, error) {
+		return r.resolver.LookupTXT(ctx, name)
+	})
+	txt, _ := records.([]string)
+	return txt, err
+}
+
+// LookupIP looks up the addresses of a host for the given network ("ip", "ip4" or "ip6")
+func (r *coalescingResolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	records, err := r.do(ctx, "ip:"+network+":"+host, func(ctx context.Context) (interface{}, error) {
+		return r.resolver.LookupIP(ctx, network, host)
+	})
+	ips, _ := records.([]net.IP)
+	return ips, err
+}
+
+// LookupMX looks up the MX records of a name
+func (r *coalescingResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	records, err := r.do(ctx, "mx:"+name, func(ctx context.Context) (interface{}, error) {
+		return r.resolver.LookupMX(ctx, name)
+	})
+	mx, _ := records.([]*net.MX)
+	return mx, err
+}
+
+// LookupAddr looks up the names pointing at an address
+func (r *coalescingResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	records, err := r.do(ctx, "ptr:"+addr, func(ctx context.Context) (interface{}, error) {
+		return r.resolver.LookupAddr(ctx, addr)
+	})
+	names, _ := records.([]string)
+	return names, err
+}
+
+// LookupCNAME looks up the canonical name of a host
+func (r *coalescingResolver) LookupCNAME(ctx context.Context, host string) (string, error) {
+	record, err := r.do(ctx, "cname:"+host, func(ctx context.Context) (interface{}, error) {
+		return r.resolver.LookupCNAME(ctx, host)
+	})
+	cname, _ := record.(string)
+	return cname, err
+}
+
+// dnsRetryInterval is how long email is analyzed without DNS once the resolver
+// was found not to answer, before DNS is tried again
+const dnsRetryInterval = 30 * time.Second
+
+// DefaultDNSProbeHost is the host looked up to check that the resolver answers
+// when lookups of an analysis fail
+const DefaultDNSProbeHost = "a.root-servers.net"
+
+// dnsStatus remembers when DNS was last found not to work at all
+type dnsStatus struct {
+	mu        sync.Mutex
+	downSince time.Time
+}
+
+// probe checks that the resolver answers a lookup of host, which is any answer
+// including "not found", and records DNS as down if it doesn't
+func (s *dnsStatus) probe(ctx context.Context, resolver Resolver, host string) bool {
+	_, err := resolver.LookupIP(ctx, "ip", host)
+	answered := err == nil || isNotFound(err)
+	s.setDown(!answered)
+	return answered
+}
+
+// isDown checks if DNS failed entirely less than dnsRetryInterval ago
+func (s *dnsStatus) isDown() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !s.downSince.IsZero() && time.Since(s.downSince) < dnsRetryInterval
+}
+
+// setDown records whether DNS is working
+func (s *dnsStatus) setDown(down bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if down {
+		s.downSince = time.Now()
+	} else {
+		s.downSince = time.Time{}
+	}
+}
@@ -0,0 +1,319 @@
+package detector
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/user/email_spoof_detection/models"
+	"github.com/user/email_spoof_detection/utils"
+)
+
+// fakeResolver answers lookups from canned records. Names without records
+// aren't found, and names in fail and their subdomains get a temporary error
+// as from a SERVFAIL. A down resolver fails every lookup.
+type fakeResolver struct {
+	txt   map[string][]string
+	ip    map[string][]net.IP
+	mx    map[string][]*net.MX
+	ptr   map[string][]string
+	cname map[string]string
+	fail  map[string]bool
+	down  bool
+
+	calls   atomic.Int64  // Lookups made
+	release chan struct{} // When set, lookups wait until it is closed
+}
+
+// lookup counts a lookup of name and returns the error it gets, if any
+func (r *fakeResolver) lookup(ctx context.Context, name string, found bool) error {
+	r.calls.Add(1)
+	if r.release != nil {
+		select {
+		case <-r.release:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	switch {
+	case r.down || r.fails(name):
+		return &net.DNSError{Err: "server misbehaving", Name: name, IsTemporary: true}
+	case !found:
+		return &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return nil
+}
+
+// fails checks if name or one of its parent domains is in fail
+func (r *fakeResolver) fails(name string) bool {
+	for {
+		if r.fail[name] {
+			return true
+		}
+		_, parent, found := strings.Cut(name, ".")
+		if !found {
+			return false
+		}
+		name = parent
+	}
+}
+
+func (r *fakeResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	records, found := r.txt[name]
+	if err := r.lookup(ctx, name, found); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+func (r *fakeResolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	var ips []net.IP
+	for _, ip := range r.ip[host] {
+		switch {
+		case network == "ip4" && ip.To4() == nil, network == "ip6" && ip.To4() != nil:
+			continue
+		}
+		ips = append(ips, ip)
+	}
+	if err := r.lookup(ctx, host, len(ips) > 0); err != nil {
+		return nil, err
+	}
+	return ips, nil
+}
+
+func (r *fakeResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	records, found := r.mx[name]
+	if err := r.lookup(ctx, name, found); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+func (r *fakeResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	names, found := r.ptr[addr]
+	if err := r.lookup(ctx, addr, found); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+func (r *fakeResolver) LookupCNAME(ctx context.Context, host string) (string, error) {
+	cname, found := r.cname[host]
+	if err := r.lookup(ctx, host, found); err != nil {
+		return "", err
+	}
+	return cname, nil
+}
+
+// ips parses a list of IP addresses
+func ips(addresses ...string) []net.IP {
+	parsed := make([]net.IP, len(addresses))
+	for i, address := range addresses {
+		parsed[i] = net.ParseIP(address)
+	}
+	return parsed
+}
+
+func TestCoalescingResolverSharesLookups(t *testing.T) {
+	const callers = 20
+	fake := &fakeResolver{
+		txt:     map[string][]string{"example.com": {"v=spf1 -all"}},
+		release: make(chan struct{}),
+	}
+	resolver := newCoalescingResolver(fake)
+
+	var wg sync.WaitGroup
+	results := make([][]string, callers)
+	errs := make([]error, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = resolver.LookupTXT(context.Background(), "example.com")
+		}(i)
+	}
+
+	// Hold the first lookup until the other callers have joined it
+	for fake.calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(fake.release)
+	wg.Wait()
+
+	if calls := fake.calls.Load(); calls != 1 {
+		t.Errorf("%d concurrent lookups made %d lookups, want 1", callers, calls)
+	}
+	for i := range results {
+		if errs[i] != nil || !reflect.DeepEqual(results[i], []string{"v=spf1 -all"}) {
+			t.Errorf("caller %d got %q, %v", i, results[i], errs[i])
+		}
+	}
+
+	// Lookups of other records or names aren't shared
+	fake.calls.Store(0)
+	resolver.LookupTXT(context.Background(), "example.org")
+	resolver.LookupMX(context.Background(), "example.com")
+	if calls := fake.calls.Load(); calls != 2 {
+		t.Errorf("lookups of different records made %d lookups, want 2", calls)
+	}
+}
+
+// TestCoalescingResolverCancelledCaller checks that a caller giving up on a
+// shared lookup doesn't fail it for the other callers
+func TestCoalescingResolverCancelledCaller(t *testing.T) {
+	fake := &fakeResolver{
+		txt:     map[string][]string{"example.com": {"v=spf1 -all"}},
+		release: make(chan struct{}),
+	}
+	resolver := newCoalescingResolver(fake)
+
+	// The first caller starts the lookup, the second joins it
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelled := make(chan error, 1)
+	go func() {
+		_, err := resolver.LookupTXT(ctx, "example.com")
+		cancelled <- err
+	}()
+	for fake.calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	type answer struct {
+		records []string
+		err     error
+	}
+	joined := make(chan answer, 1)
+	go func() {
+		records, err := resolver.LookupTXT(context.Background(), "example.com")
+		joined <- answer{records, err}
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// The first caller stops waiting as soon as it is cancelled
+	cancel()
+	select {
+	case err := <-cancelled:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("cancelled caller got %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("cancelled caller still waits on the lookup")
+	}
+
+	close(fake.release)
+	got := <-joined
+	if got.err != nil || !reflect.DeepEqual(got.records, []string{"v=spf1 -all"}) {
+		t.Errorf("joined caller got %q, %v, want the records", got.records, got.err)
+	}
+	if calls := fake.calls.Load(); calls != 1 {
+		t.Errorf("made %d lookups, want 1", calls)
+	}
+}
+
+// dnsEmail is an email from the given domain, sent from the given IP address
+func dnsEmail(t *testing.T, domain, ip string) *models.Email {
+	t.Helper()
+	email, err := utils.ParseEmail([]byte("From: Alice <alice@" + domain + ">\r\n" +
+		"To: bob@example.org\r\n" +
+		"Subject: Lunch\r\n" +
+		"Date: Mon, 3 Jun 2024 10:00:00 +0000\r\n" +
+		"Message-ID: <1@" + domain + ">\r\n" +
+		"Received: from mail." + domain + " (mail." + domain + " [" + ip + "]) by mx.example.org with ESMTP; Mon, 3 Jun 2024 10:00:01 +0000\r\n" +
+		"\r\n" +
+		"See you at noon.\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return email
+}
+
+// dnsDetector returns a detector using the resolver
+func dnsDetector(resolver Resolver, probeHost string) *SpoofDetector {
+	config := DefaultConfig()
+	config.Resolver = resolver
+	config.DNSProbeHost = probeHost
+	return NewSpoofDetectorWithConfig(config)
+}
+
+func TestDeadResolverAnalyzesWithoutDNS(t *testing.T) {
+	fake := &fakeResolver{down: true}
+	d := dnsDetector(fake, "")
+
+	result := d.Analyze(dnsEmail(t, "example.com", "192.0.2.10"))
+	if !hasFinding(result, "dns_unavailable") {
+		t.Fatalf("findings with a dead resolver: %v", result.Findings)
+	}
+	for _, finding := range result.Findings {
+		if finding.Rule == "spf" || finding.Rule == "dmarc" || finding.Rule == "suspicious_helo" {
+			t.Errorf("failed lookups reported as %s: %s", finding.Rule, finding.Message)
+		}
+	}
+
+	// The following email is analyzed without trying DNS again
+	calls := fake.calls.Load()
+	result = d.Analyze(dnsEmail(t, "example.net", "192.0.2.10"))
+	if !hasFinding(result, "dns_unavailable") {
+		t.Errorf("following email analyzed with DNS: %v", result.Findings)
+	}
+	if made := fake.calls.Load() - calls; made != 0 {
+		t.Errorf("following email made %d lookups, want none", made)
+	}
+}
+
+// TestFailingSenderDomainKeepsDNS checks that email from domains whose DNS
+// fails can't get other email analyzed without DNS, also when analyzed at the
+// same time
+func TestFailingSenderDomainKeepsDNS(t *testing.T) {
+	fake := &fakeResolver{
+		txt: map[string][]string{
+			"example.com":        {"v=spf1 -all"},
+			"_dmarc.example.com": {"v=DMARC1; p=reject"},
+		},
+		ip: map[string][]net.IP{DefaultDNSProbeHost: ips("198.41.0.4")},
+		// The attacker's domain and reverse DNS fail every lookup
+		fail: map[string]bool{"attacker.example": true, "198.51.100.66": true},
+	}
+	d := dnsDetector(fake, "")
+
+	var emails []*models.Email
+	for i := 0; i < 3*batchWorkers; i++ {
+		emails = append(emails, dnsEmail(t, "attacker.example", "198.51.100.66"))
+	}
+	emails = append(emails, dnsEmail(t, "example.com", "192.0.2.10"))
+
+	results := d.AnalyzeBatch(emails)
+	for i, result := range results {
+		if hasFinding(result, "dns_unavailable") {
+			t.Fatalf("email %d analyzed without DNS: %v", i, result.Findings)
+		}
+	}
+	if len(results[0].Diagnostics) == 0 {
+		t.Errorf("failed lookups of attacker.example aren't reported as diagnostics")
+	}
+	if legitimate := results[len(results)-1]; legitimate.Authentication.SPF != string(SPFFail) || !hasFinding(legitimate, "spf") {
+		t.Errorf("SPF of example.com = %q with findings %v, want a failure", legitimate.Authentication.SPF, legitimate.Findings)
+	}
+
+	result := d.Analyze(dnsEmail(t, "example.com", "192.0.2.10"))
+	if hasFinding(result, "dns_unavailable") || !hasFinding(result, "spf") {
+		t.Errorf("email following the batch: %v", result.Findings)
+	}
+}
+
+// TestDNSProbeHost checks that DNS is found down when the probe host fails too
+func TestDNSProbeHost(t *testing.T) {
+	fake := &fakeResolver{fail: map[string]bool{"example.com": true, "resolver.internal": true}}
+	d := dnsDetector(fake, "resolver.internal")
+
+	if result := d.Analyze(dnsEmail(t, "example.com", "192.0.2.10")); !hasFinding(result, "dns_unavailable") {
+		t.Errorf("findings with a failing probe host: %v", result.Findings)
+	}
+}
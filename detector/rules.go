@@ -1,9 +1,13 @@
 package detector
 
 import (
-	"net"
+	"fmt"
+	"net/mail"
+	"sort"
 	"strings"
 
+	"golang.org/x/net/publicsuffix"
+
 	"github.com/user/email_spoof_detection/models"
 )
 
@@ -12,6 +16,8 @@ type Rule struct {
 	Name        string
 	Description string
 	Weight      int // Weight of this rule in the overall score
+	Severity    models.Severity
+	Network     bool // Set for rules that perform DNS lookups; they run concurrently
 	CheckFunc   func(*models.Email) (bool, string)
 }
 
@@ -22,39 +28,242 @@ func Rules() []Rule {
 			Name:        "inconsistent_from_reply_to",
 			Description: "From and Reply-To domains don't match",
 			Weight:      3,
+			Severity:    models.SeverityMedium,
 			CheckFunc:   checkFromReplyToDomainMismatch,
 		},
 		{
 			Name:        "inconsistent_from_return_path",
 			Description: "From and Return-Path domains don't match",
 			Weight:      3,
+			Severity:    models.SeverityMedium,
 			CheckFunc:   checkFromReturnPathDomainMismatch,
 		},
-		{
-			Name:        "missing_spf",
-			Description: "Domain doesn't have SPF record",
-			Weight:      2,
-			CheckFunc:   checkMissingSPF,
-		},
 		{
 			Name:        "suspicious_from_domain",
 			Description: "From domain is suspicious (lookalike domain)",
 			Weight:      4,
+			Severity:    models.SeverityHigh,
 			CheckFunc:   checkSuspiciousFromDomain,
 		},
+		{
+			Name:        "ip_literal_from",
+			Description: "From domain is an IP address literal or a dotted-quad hostname",
+			Weight:      4,
+			Severity:    models.SeverityHigh,
+			CheckFunc:   checkIPLiteralFromDomain,
+		},
 		{
 			Name:        "multiple_from_headers",
 			Description: "Email contains multiple From headers",
 			Weight:      5,
+			Severity:    models.SeverityCritical,
 			CheckFunc:   checkMultipleFromHeaders,
 		},
+		{
+			Name:        "duplicate_singleton_headers",
+			Description: "Headers that may appear only once, such as Date or Message-ID, appear more than once",
+			Weight:      3,
+			Severity:    models.SeverityMedium,
+			CheckFunc:   checkDuplicateSingletonHeaders,
+		},
+		{
+			Name:        "group_from_header",
+			Description: "From header uses group syntax instead of a single mailbox",
+			Weight:      2,
+			Severity:    models.SeverityMedium,
+			CheckFunc:   checkGroupFromHeader,
+		},
 		{
 			Name:        "suspicious_received_chain",
 			Description: "Suspicious Received headers chain",
 			Weight:      2,
+			Severity:    models.SeverityLow,
 			CheckFunc:   checkSuspiciousReceivedChain,
 		},
+		{
+			Name:        "date_timezone_mismatch",
+			Description: "Date header timezone is far from the timezones of all Received hops",
+			Weight:      1,
+			Severity:    models.SeverityLow,
+			CheckFunc:   checkDateTimezoneMismatch,
+		},
+		{
+			Name:        "bcc_recipient",
+			Description: "Envelope recipient of a Received for clause isn't among the To and Cc recipients (Bcc delivery)",
+			Weight:      1,
+			Severity:    models.SeverityLow,
+			CheckFunc:   checkBccRecipient,
+		},
+		{
+			Name:        "forged_thread_headers",
+			Description: "In-Reply-To/References headers appear forged",
+			Weight:      2,
+			Severity:    models.SeverityMedium,
+			CheckFunc:   checkForgedThreadHeaders,
+		},
+		{
+			Name:        "content_type_mismatch",
+			Description: "Content of a text part doesn't match its declared Content-Type",
+			Weight:      2,
+			Severity:    models.SeverityMedium,
+			CheckFunc:   checkContentTypeMismatch,
+		},
+		{
+			Name:        "malformed_message",
+			Description: "Message headers are malformed and could only be partially parsed",
+			Weight:      2,
+			Severity:    models.SeverityMedium,
+			CheckFunc:   checkMalformedMessage,
+		},
+		{
+			Name:        "leading_junk",
+			Description: "Message starts with a byte order mark or blank lines before its headers",
+			Weight:      1,
+			Severity:    models.SeverityLow,
+			CheckFunc:   checkLeadingJunk,
+		},
+		{
+			Name:        "mime_structure_anomaly",
+			Description: "MIME structure is malformed in a way used to evade scanners",
+			Weight:      2,
+			Severity:    models.SeverityMedium,
+			CheckFunc:   checkMIMEStructureAnomaly,
+		},
+		{
+			Name:        "duplicate_mime_headers",
+			Description: "A MIME part repeats its Content-Type, Content-Disposition or Content-Transfer-Encoding header",
+			Weight:      3,
+			Severity:    models.SeverityMedium,
+			CheckFunc:   checkDuplicateMIMEHeaders,
+		},
+		{
+			Name:        "undecodable_charset",
+			Description: "Text body declares an unknown charset or isn't valid in its charset",
+			Weight:      1,
+			Severity:    models.SeverityLow,
+			CheckFunc:   checkUndecodableCharset,
+		},
+		{
+			Name:        "header_injection",
+			Description: "Encoded words in address or subject headers decode to CR or LF",
+			Weight:      4,
+			Severity:    models.SeverityHigh,
+			CheckFunc:   checkHeaderInjection,
+		},
+		{
+			Name:        "invisible_characters",
+			Description: "Display name or subject words are broken up by zero-width or other invisible characters",
+			Weight:      2,
+			Severity:    models.SeverityMedium,
+			CheckFunc:   checkInvisibleCharacters,
+		},
+		{
+			Name:        "confusable_local_part",
+			Description: "From local part imitates a sensitive mailbox such as support or billing with non-ASCII lookalike letters",
+			Weight:      3,
+			Severity:    models.SeverityHigh,
+			CheckFunc:   checkConfusableLocalPart,
+		},
+	}
+}
+
+// selectRules returns the rules that are selected, leaving out the others
+func (d *SpoofDetector) selectRules(rules []Rule) []Rule {
+	selected := make([]Rule, 0, len(rules))
+	for _, rule := range rules {
+		if d.selected(rule.Name) {
+			selected = append(selected, rule)
+		}
+	}
+	return selected
+}
+
+// ruleSelected checks if a rule is named in enable, or enable is empty, and
+// isn't named in disable
+func ruleSelected(rule string, enable, disable []string) bool {
+	named := func(names []string) bool {
+		for _, name := range names {
+			if name == rule {
+				return true
+			}
+		}
+		return false
+	}
+	return (len(enable) == 0 || named(enable)) && !named(disable)
+}
+
+// selected checks if the findings of a rule or check are reported given the
+// EnabledRules and DisabledRules and the rules disabled in the rules file.
+// Notes that aren't in the Catalog always are.
+func (d *SpoofDetector) selected(name string) bool {
+	if !inCatalog(name) {
+		return true
+	}
+	if d.config.RulesFile != nil && d.config.RulesFile.Rules[name].Disabled {
+		return false
 	}
+	return ruleSelected(name, d.config.EnabledRules, d.config.DisabledRules)
+}
+
+// dropUnselected removes the findings of the checks that aren't selected, which
+// run as part of the analysis rather than as Rules()
+func (d *SpoofDetector) dropUnselected(result *models.AnalysisResult) {
+	result.RemoveFindingsFunc(func(finding models.Finding) bool {
+		return !d.selected(finding.Rule)
+	})
+
+	notEvaluated := result.NotEvaluated[:0]
+	for _, name := range result.NotEvaluated {
+		if d.selected(name) {
+			notEvaluated = append(notEvaluated, name)
+		}
+	}
+	result.NotEvaluated = notEvaluated
+}
+
+// ValidateRuleNames checks that each name is that of a rule or check listed by Catalog
+func ValidateRuleNames(names []string) error {
+	known := map[string]bool{}
+	for _, rule := range Catalog() {
+		known[rule.Name] = true
+	}
+
+	for _, name := range names {
+		if !known[name] {
+			return fmt.Errorf("unknown rule %q (the rules subcommand lists them)", name)
+		}
+	}
+	return nil
+}
+
+// commonDomains lists well-known domains that are frequently spoofed
+var commonDomains = map[string]bool{
+	"gmail.com":         true,
+	"yahoo.com":         true,
+	"outlook.com":       true,
+	"hotmail.com":       true,
+	"microsoft.com":     true,
+	"apple.com":         true,
+	"amazon.com":        true,
+	"facebook.com":      true,
+	"paypal.com":        true,
+	"wellsfargo.com":    true,
+	"bankofamerica.com": true,
+	"chase.com":         true,
+}
+
+// commonDomainNames lists the commonDomains in alphabetical order, so the domain
+// reported when several match is the same on every run
+var commonDomainNames = sortedDomainNames(commonDomains)
+
+// sortedDomainNames returns the domains of a set in alphabetical order
+func sortedDomainNames(domains map[string]bool) []string {
+	names := make([]string, 0, len(domains))
+	for domain := range domains {
+		names = append(names, domain)
+	}
+	sort.Strings(names)
+	return names
 }
 
 // checkFromReplyToDomainMismatch checks if From and Reply-To domains don't match
@@ -75,18 +284,13 @@ func checkFromReplyToDomainMismatch(email *models.Email) (bool, string) {
 
 // checkFromReturnPathDomainMismatch checks if From and Return-Path domains don't match
 func checkFromReturnPathDomainMismatch(email *models.Email) (bool, string) {
-	if email.From == nil || email.ReturnPath == "" {
+	// The null sender <> is legitimately used by bounces and has no domain to compare
+	if email.From == nil || email.ReturnPath == "" || email.NullReturnPath {
 		return false, ""
 	}
 
 	fromDomain := models.GetDomain(email.From)
-	
-	// Extract domain from Return-Path
-	returnPathParts := strings.Split(email.ReturnPath, "@")
-	if len(returnPathParts) != 2 {
-		return false, ""
-	}
-	returnPathDomain := returnPathParts[1]
+	returnPathDomain := returnPathDomain(email)
 
 	if fromDomain != "" && returnPathDomain != "" && fromDomain != returnPathDomain {
 		return true, "From domain (" + fromDomain + ") doesn't match Return-Path domain (" + returnPathDomain + ")"
@@ -95,32 +299,18 @@ func checkFromReturnPathDomainMismatch(email *models.Email) (bool, string) {
 	return false, ""
 }
 
-// checkMissingSPF checks if the domain has an SPF record
-func checkMissingSPF(email *models.Email) (bool, string) {
-	if email.From == nil {
-		return false, ""
+// returnPathDomain extracts the domain from the Return-Path of an email,
+// returning an empty string for the null sender or a malformed address
+func returnPathDomain(email *models.Email) string {
+	if email.NullReturnPath {
+		return ""
 	}
 
-	fromDomain := models.GetDomain(email.From)
-	if fromDomain == "" {
-		return false, ""
+	at := strings.LastIndex(email.ReturnPath, "@")
+	if at < 0 || at == len(email.ReturnPath)-1 {
+		return ""
 	}
-
-	// Look up TXT records for the domain
-	txtRecords, err := net.LookupTXT(fromDomain)
-	if err != nil {
-		// DNS lookup error, can't determine if SPF exists
-		return false, ""
-	}
-
-	// Check if any of the TXT records is an SPF record
-	for _, record := range txtRecords {
-		if strings.HasPrefix(record, "v=spf1") {
-			return false, ""
-		}
-	}
-
-	return true, "Domain " + fromDomain + " doesn't have an SPF record"
+	return strings.ToLower(email.ReturnPath[at+1:])
 }
 
 // checkSuspiciousFromDomain checks for lookalike domains
@@ -134,24 +324,8 @@ func checkSuspiciousFromDomain(email *models.Email) (bool, string) {
 		return false, ""
 	}
 
-	// List of common domains that might be spoofed
-	commonDomains := map[string]bool{
-		"gmail.com":      true,
-		"yahoo.com":      true,
-		"outlook.com":    true,
-		"hotmail.com":    true,
-		"microsoft.com":  true,
-		"apple.com":      true,
-		"amazon.com":     true,
-		"facebook.com":   true,
-		"paypal.com":     true,
-		"wellsfargo.com": true,
-		"bankofamerica.com": true,
-		"chase.com":      true,
-	}
-
 	// Check for lookalike domains (simple check for demonstration)
-	for domain := range commonDomains {
+	for _, domain := range commonDomainNames {
 		if fromDomain != domain && isSimilarDomain(fromDomain, domain) {
 			return true, "From domain (" + fromDomain + ") looks similar to " + domain
 		}
@@ -160,6 +334,49 @@ func checkSuspiciousFromDomain(email *models.Email) (bool, string) {
 	return false, ""
 }
 
+// checkIPLiteralFromDomain checks for a From address at an IP address instead of
+// a domain name, such as user@[203.0.113.5] or user@203.0.113.5. Legitimate
+// senders mail from domains; an IP address has no SPF, DKIM or DMARC policy to
+// check against and is mostly seen in spam and spoofing tools.
+func checkIPLiteralFromDomain(email *models.Email) (bool, string) {
+	fromDomain := models.GetDomain(email.From)
+	ip := models.DomainIP(fromDomain)
+	if ip == nil {
+		return false, ""
+	}
+
+	if strings.HasPrefix(fromDomain, "[") {
+		return true, "From address " + email.From.Address + " uses the IP address literal " + ip.String() + " instead of a domain"
+	}
+	return true, "From address " + email.From.Address + " uses the IP address " + ip.String() + " as its domain"
+}
+
+// checkMalformedMessage checks if the message had to be parsed leniently
+func checkMalformedMessage(email *models.Email) (bool, string) {
+	if email.ParseError == "" {
+		return false, ""
+	}
+	return true, "Message is malformed (" + email.ParseError + "); only the recoverable headers were analyzed"
+}
+
+// checkLeadingJunk checks if anything had to be stripped before the headers of the
+// message, which mail servers never produce but tampered or crafted files can
+func checkLeadingJunk(email *models.Email) (bool, string) {
+	if email.LeadingJunk == "" {
+		return false, ""
+	}
+	return true, "Message starts with " + email.LeadingJunk + " before its headers, which were stripped to parse it"
+}
+
+// checkUndecodableCharset checks for text parts whose charset couldn't be decoded,
+// which leaves their content checks working on the raw bytes
+func checkUndecodableCharset(email *models.Email) (bool, string) {
+	if len(email.CharsetErrors) == 0 {
+		return false, ""
+	}
+	return true, "Text could not be decoded from its declared charset: " + strings.Join(email.CharsetErrors, "; ")
+}
+
 // isSimilarDomain checks if two domains are suspiciously similar
 func isSimilarDomain(domain1, domain2 string) bool {
 	// Simple check: domain1 contains domain2 but is not equal to it
@@ -194,29 +411,199 @@ func checkMultipleFromHeaders(email *models.Email) (bool, string) {
 	return false, ""
 }
 
-// checkSuspiciousReceivedChain checks for suspicious patterns in Received headers
+// singletonHeaders are the header fields RFC 5322 section 3.6 allows at most once,
+// apart from From, which multiple_from_headers checks with a higher weight
+var singletonHeaders = []string{
+	"Date", "Sender", "Reply-To", "To", "Cc", "Bcc",
+	"Message-ID", "In-Reply-To", "References", "Subject",
+}
+
+// checkDuplicateSingletonHeaders checks for header fields that may appear only once
+// but appear several times: a second copy is either injected or smuggled in for
+// the clients and filters that read the other one
+func checkDuplicateSingletonHeaders(email *models.Email) (bool, string) {
+	var duplicated []string
+	for _, name := range singletonHeaders {
+		if count := len(email.GetAllHeaderValues(name)); count > 1 {
+			duplicated = append(duplicated, fmt.Sprintf("%s (%d times)", name, count))
+		}
+	}
+
+	if len(duplicated) == 0 {
+		return false, ""
+	}
+	return true, "Headers allowed only once appear more than once: " + strings.Join(duplicated, ", ")
+}
+
+// checkGroupFromHeader checks if the From header is a group rather than a single
+// mailbox; the first member of a group is analyzed as the sender
+func checkGroupFromHeader(email *models.Email) (bool, string) {
+	if email.FromGroup == "" {
+		return false, ""
+	}
+
+	if len(email.FromMembers) == 0 {
+		return true, "From header is the empty group \"" + email.FromGroup + "\"; the sender can't be determined"
+	}
+
+	return true, fmt.Sprintf("From header uses group syntax (%s) with %d address(es); analyzing %s",
+		email.FromGroup, len(email.FromMembers), email.From.Address)
+}
+
+// suspiciousReceivedPatterns are hosts and IP prefixes that don't belong in the
+// sending side of a hop crossing the internet
+var suspiciousReceivedPatterns = []string{
+	"unknown", "localhost", "127.0.0.1", "192.168.", "10.0.", "172.16.",
+}
+
+// checkSuspiciousReceivedChain checks for suspicious hosts and IP addresses in the
+// HELO, reverse DNS, IP and receiving server fields of the Received headers
 func checkSuspiciousReceivedChain(email *models.Email) (bool, string) {
-	receivedHeaders := email.GetAllHeaderValues("Received")
-	
-	if len(receivedHeaders) == 0 {
-		return true, "Email doesn't have any Received headers"
-	}
-	
-	// Check for suspicious patterns in Received headers
-	for _, header := range receivedHeaders {
-		header = strings.ToLower(header)
-		
-		// Check for suspicious IP addresses or domains
-		suspiciousPatterns := []string{
-			"unknown", "localhost", "127.0.0.1", "192.168.", "10.0.", "172.16.",
+	for _, hop := range receivedHops(email) {
+		fields := []string{strings.ToLower(hop.FromHELO), hop.FromHost, strings.ToLower(hop.ByHost)}
+		if hop.FromIP != nil {
+			fields = append(fields, hop.FromIP.String())
 		}
-		
-		for _, pattern := range suspiciousPatterns {
-			if strings.Contains(header, pattern) {
-				return true, "Suspicious pattern found in Received headers: " + pattern
+
+		for _, pattern := range suspiciousReceivedPatterns {
+			for _, field := range fields {
+				if strings.Contains(field, pattern) {
+					return true, "Suspicious pattern found in Received headers: " + pattern
+				}
 			}
 		}
 	}
-	
+
 	return false, ""
 }
+
+// checkBccRecipient checks for an envelope recipient, recorded in the "for" clause
+// of a Received header, that isn't one of the To and Cc recipients, meaning the
+// email was delivered as a Bcc. Phishing blasts Bcc their targets to hide them
+// from each other, but so do legitimate announcements, so the rule only adds
+// a little to the other signals. Mailing list deliveries are skipped, as the
+// list address stands in for its members.
+func checkBccRecipient(email *models.Email) (bool, string) {
+	if email.HasHeader("List-Id") || email.HasHeader("List-Post") {
+		return false, ""
+	}
+
+	visible := map[string]bool{}
+	var shown []string
+	for _, address := range append(append([]*mail.Address{}, email.To...), email.Cc...) {
+		visible[strings.ToLower(address.Address)] = true
+		shown = append(shown, address.Address)
+	}
+
+	for _, hop := range receivedHops(email) {
+		if hop.For == "" || visible[strings.ToLower(hop.For)] {
+			continue
+		}
+
+		if len(shown) == 0 {
+			return true, "Envelope recipient " + hop.For + " (Received for clause) was sent an email without To or Cc recipients"
+		}
+		return true, "Envelope recipient " + hop.For + " (Received for clause) isn't among the visible recipients " + strings.Join(shown, ", ")
+	}
+
+	return false, ""
+}
+
+// checkForgedThreadHeaders checks for In-Reply-To and References headers that fake
+// membership of an existing conversation
+func checkForgedThreadHeaders(email *models.Email) (bool, string) {
+	if email.InReplyTo == "" {
+		// A reply without In-Reply-To but with References is unusual for real mail clients
+		if len(email.References) > 0 && isReplySubject(email.Subject) {
+			return true, "Email claims to be a reply and has References but no In-Reply-To header"
+		}
+		return false, ""
+	}
+
+	// Clients append the parent message to References, so In-Reply-To should be listed there
+	if len(email.References) > 0 {
+		found := false
+		for _, reference := range email.References {
+			if reference == email.InReplyTo {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return true, "In-Reply-To (" + email.InReplyTo + ") is not listed in the References header"
+		}
+	}
+
+	referencedDomain := models.GetMessageIDDomain(email.InReplyTo)
+	if referencedDomain == "" || !strings.Contains(referencedDomain, ".") {
+		return false, ""
+	}
+
+	// The parent message should have been written by one of the participants of this conversation
+	participants := []*mail.Address{email.From, email.ReplyTo}
+	participants = append(participants, email.To...)
+	participants = append(participants, email.Cc...)
+
+	for _, participant := range participants {
+		domain := models.GetDomain(participant)
+		if domain != "" && isRelatedDomain(referencedDomain, domain) {
+			return false, ""
+		}
+	}
+
+	return true, "In-Reply-To references a message (" + email.InReplyTo + ") from a domain unrelated to any participant"
+}
+
+// isReplySubject checks if a subject line is marked as a reply
+func isReplySubject(subject string) bool {
+	subject = strings.ToLower(strings.TrimSpace(subject))
+	return strings.HasPrefix(subject, "re:") || strings.HasPrefix(subject, "aw:")
+}
+
+// isRelatedDomain checks if two domains are equal, one is a subdomain of the other,
+// or they share the same last two labels (e.g. mail.example.com and example.com)
+func isRelatedDomain(domain1, domain2 string) bool {
+	domain1 = strings.ToLower(strings.TrimSuffix(domain1, "."))
+	domain2 = strings.ToLower(strings.TrimSuffix(domain2, "."))
+
+	if domain1 == domain2 {
+		return true
+	}
+
+	return baseDomain(domain1) == baseDomain(domain2)
+}
+
+// baseDomain returns the registrable (organizational) domain of a domain name,
+// such as example.co.uk for mail.example.co.uk, falling back to the last two labels
+func baseDomain(domain string) string {
+	if registrable, err := publicsuffix.EffectiveTLDPlusOne(domain); err == nil {
+		return registrable
+	}
+
+	labels := strings.Split(domain, ".")
+	if len(labels) <= 2 {
+		return domain
+	}
+	return strings.Join(labels[len(labels)-2:], ".")
+}
+
+// checkDuplicateMIMEHeaders checks for MIME parts with a repeated content header:
+// one copy is benign for scanners that read it, the other malicious for clients
+// that read that one instead
+func checkDuplicateMIMEHeaders(email *models.Email) (bool, string) {
+	if len(email.DuplicateMIME) == 0 {
+		return false, ""
+	}
+
+	return true, "Content headers repeated within a MIME entity: " + strings.Join(email.DuplicateMIME, "; ")
+}
+
+// checkMIMEStructureAnomaly checks for malformed MIME structures such as content hidden
+// after the terminating boundary, excessive nesting or multiparts without parts
+func checkMIMEStructureAnomaly(email *models.Email) (bool, string) {
+	if len(email.MIMEAnomalies) == 0 {
+		return false, ""
+	}
+
+	return true, "MIME structure anomaly: " + strings.Join(email.MIMEAnomalies, "; ")
+}
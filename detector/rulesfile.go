@@ -0,0 +1,165 @@
+package detector
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+
+	"github.com/user/email_spoof_detection/models"
+)
+
+// DefaultGroupDecay is the factor applied to each further finding of a rule group
+// when the rules file doesn't set one
+const DefaultGroupDecay = 0.5
+
+// RuleOverride customizes a single rule, identified by name, in the rules file
+type RuleOverride struct {
+	Weight   *int             `json:"weight,omitempty"`
+	Severity *models.Severity `json:"severity,omitempty"`
+	Disabled bool             `json:"disabled,omitempty"`
+
+	// Group places correlated rules together so they don't inflate the score:
+	// only the highest-weight finding of a group counts fully
+	Group string `json:"group,omitempty"`
+}
+
+// RulesFile is the JSON document that customizes rule weights, severities and grouping
+type RulesFile struct {
+	Rules map[string]RuleOverride `json:"rules"`
+
+	// GroupDecay multiplies the weight of the second-highest finding of a group,
+	// its square the third, and so on; DefaultGroupDecay is used when zero
+	GroupDecay float64 `json:"group_decay,omitempty"`
+
+	// MaxScore caps the total score when positive
+	MaxScore int `json:"max_score,omitempty"`
+
+	// Thresholds overrides the spoofing threshold per From domain; see Config.DomainThresholds
+	Thresholds map[string]int `json:"thresholds,omitempty"`
+
+	// Brands adds brands, or replaces the legitimate domains of built-in brands;
+	// see Config.BrandDomains
+	Brands map[string][]string `json:"brands,omitempty"`
+
+	// BrandKeywords adds display name keywords per brand, or replaces those of
+	// built-in brands; see Config.BrandKeywords
+	BrandKeywords map[string][]string `json:"brand_keywords,omitempty"`
+
+	// CommonWordBrands adds brands whose names are also common words or names;
+	// see Config.CommonWordBrands
+	CommonWordBrands []string `json:"common_word_brands,omitempty"`
+
+	// BrandCountries lists the ISO country codes each brand sends email from;
+	// see Config.BrandCountries
+	BrandCountries map[string][]string `json:"brand_countries,omitempty"`
+
+	// ExpectedLanguages lists the languages each brand or domain writes in; see
+	// Config.ExpectedLanguages
+	ExpectedLanguages map[string][]string `json:"expected_languages,omitempty"`
+
+	// Allowlist allowlists senders with the findings suppressed for each; see
+	// Config.AllowlistScopes
+	Allowlist map[string]AllowlistScope `json:"allowlist,omitempty"`
+}
+
+// LoadRulesFile reads a rules file from disk
+func LoadRulesFile(path string) (*RulesFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading rules file: %w", err)
+	}
+
+	var rulesFile RulesFile
+	if err := json.Unmarshal(data, &rulesFile); err != nil {
+		return nil, fmt.Errorf("parsing rules file %s: %w", path, err)
+	}
+
+	if rulesFile.GroupDecay < 0 || rulesFile.GroupDecay > 1 {
+		return nil, fmt.Errorf("group_decay must be between 0 and 1, got %v", rulesFile.GroupDecay)
+	}
+
+	return &rulesFile, nil
+}
+
+// overrideRule applies the weight and severity set in the rules file to a rule.
+// Disabled rules are left out by selected.
+func (d *SpoofDetector) overrideRule(rule Rule) Rule {
+	if d.config.RulesFile == nil {
+		return rule
+	}
+
+	override := d.config.RulesFile.Rules[rule.Name]
+	if override.Weight != nil {
+		rule.Weight = *override.Weight
+	}
+	if override.Severity != nil {
+		rule.Severity = *override.Severity
+	}
+
+	return rule
+}
+
+// overrideFindings applies the weight and severity set in the rules file to
+// the findings of each rule or check by name, including the checks that run as
+// part of the analysis and weigh their findings themselves
+func (d *SpoofDetector) overrideFindings(result *models.AnalysisResult) {
+	if d.config.RulesFile == nil {
+		return
+	}
+
+	for i, finding := range result.Findings {
+		override := d.config.RulesFile.Rules[finding.Rule]
+		if override.Weight != nil {
+			result.Score += *override.Weight - finding.Weight
+			result.Findings[i].Weight = *override.Weight
+		}
+		if override.Severity != nil {
+			result.Findings[i].Severity = *override.Severity
+		}
+	}
+}
+
+// score computes the total score of the findings. Findings of the same rule group
+// contribute with diminishing weight and the total is capped at the configured maximum.
+func (d *SpoofDetector) score(findings []models.Finding) int {
+	if d.config.RulesFile == nil {
+		total := 0
+		for _, finding := range findings {
+			total += finding.Weight
+		}
+		return total
+	}
+
+	decay := d.config.RulesFile.GroupDecay
+	if decay == 0 {
+		decay = DefaultGroupDecay
+	}
+
+	total := 0
+	groups := map[string][]int{}
+	for _, finding := range findings {
+		group := d.config.RulesFile.Rules[finding.Rule].Group
+		if group == "" {
+			total += finding.Weight
+			continue
+		}
+		groups[group] = append(groups[group], finding.Weight)
+	}
+
+	for _, weights := range groups {
+		sort.Sort(sort.Reverse(sort.IntSlice(weights)))
+		factor := 1.0
+		for _, weight := range weights {
+			total += int(math.Round(float64(weight) * factor))
+			factor *= decay
+		}
+	}
+
+	if maxScore := d.config.RulesFile.MaxScore; maxScore > 0 && total > maxScore {
+		total = maxScore
+	}
+
+	return total
+}
@@ -0,0 +1,51 @@
+package detector
+
+import (
+	"testing"
+
+	"github.com/user/email_spoof_detection/models"
+	"github.com/user/email_spoof_detection/utils"
+)
+
+func TestRulesFileOverridesChecks(t *testing.T) {
+	email, err := utils.ParseEmail([]byte(selectionEmail))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	weight, severity := 7, models.SeverityCritical
+	config := DefaultConfig()
+	config.Offline = true
+	config.RulesFile = &RulesFile{Rules: map[string]RuleOverride{
+		"risky_tld":                  {Weight: &weight, Severity: &severity},
+		"brand_impersonation":        {Disabled: true},
+		"inconsistent_from_reply_to": {Weight: &weight},
+		"dkim":                       {Disabled: true},
+	}}
+	result := NewSpoofDetectorWithConfig(config).Analyze(email)
+
+	findings := map[string]models.Finding{}
+	score := 0
+	for _, finding := range result.Findings {
+		findings[finding.Rule] = finding
+		score += finding.Weight
+	}
+
+	if finding, found := findings["risky_tld"]; !found || finding.Weight != weight || finding.Severity != severity {
+		t.Errorf("risky_tld = %+v, want weight %d and severity %s", finding, weight, severity)
+	}
+	if finding := findings["inconsistent_from_reply_to"]; finding.Weight != weight {
+		t.Errorf("inconsistent_from_reply_to weight = %d, want %d", finding.Weight, weight)
+	}
+	for _, name := range []string{"brand_impersonation", "dkim"} {
+		if _, found := findings[name]; found {
+			t.Errorf("disabled %s is still reported", name)
+		}
+	}
+	if result.Score != score {
+		t.Errorf("score = %d, want the sum %d of the overridden findings", result.Score, score)
+	}
+	if result.Authentication.DKIM == "" {
+		t.Errorf("DKIM outcome isn't recorded with the dkim rule disabled")
+	}
+}
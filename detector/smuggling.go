@@ -0,0 +1,142 @@
+package detector
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/user/email_spoof_detection/models"
+)
+
+// smtpCommands are the commands that open a smuggled message after an injected
+// end-of-data sequence
+var smtpCommands = []string{"MAIL FROM:", "RCPT TO:", "HELO ", "EHLO ", "DATA", "RSET", "QUIT"}
+
+// dotLine is a line made of a single dot in raw message data, the end-of-data
+// marker of SMTP
+type dotLine struct {
+	Offset   int    // Byte offset of the dot
+	Sequence string // Line endings around the dot, e.g. "<LF>.<CR><LF>"
+	Command  string // SMTP command that follows, if any
+}
+
+// lineEndingAt names the line ending starting at data[i], or returns an empty string
+func lineEndingAt(data []byte, i int) string {
+	switch {
+	case i >= len(data):
+		return ""
+	case data[i] == '\n':
+		return "<LF>"
+	case data[i] == '\r' && i+1 < len(data) && data[i+1] == '\n':
+		return "<CR><LF>"
+	case data[i] == '\r':
+		return "<CR>"
+	}
+	return ""
+}
+
+// findDotLines returns the dot lines of raw message data
+func findDotLines(data []byte) []dotLine {
+	var lines []dotLine
+	for offset := 1; offset < len(data)-1; offset++ {
+		if data[offset] != '.' || data[offset-1] != '\n' && data[offset-1] != '\r' {
+			continue
+		}
+		after := lineEndingAt(data, offset+1)
+		if after == "" {
+			continue
+		}
+
+		before := "<LF>"
+		switch {
+		case data[offset-1] == '\r':
+			before = "<CR>"
+		case offset >= 2 && data[offset-2] == '\r':
+			before = "<CR><LF>"
+		}
+
+		line := dotLine{Offset: offset, Sequence: before + "." + after}
+		rest := bytes.TrimLeft(data[offset+1:], "\r\n")
+		for _, command := range smtpCommands {
+			if len(rest) >= len(command) && strings.EqualFold(string(rest[:len(command)]), command) {
+				line.Command = strings.TrimSpace(strings.TrimSuffix(command, ":"))
+				break
+			}
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// checkSMTPSmuggling flags the framing anomalies of SMTP smuggling in the raw
+// message. A dot line ends the message in SMTP; one with line endings other than
+// those of the rest of the message, such as <LF>.<LF> in a <CR><LF> message, is
+// taken as the end by some servers and not by others, letting a second message
+// with a spoofed sender ride along. Any dot line followed by SMTP commands is
+// flagged as critical. Line endings mixed outside dot lines are reported on
+// their own with a low weight, as careless tools produce them too.
+func checkSMTPSmuggling(email *models.Email, result *models.AnalysisResult) {
+	data := email.RawContent
+	if len(data) == 0 {
+		return
+	}
+
+	crlf, bareLF, bareCR := 0, 0, 0
+	firstBareLF, firstBareCR := -1, -1
+	for i, c := range data {
+		switch {
+		case c == '\r' && i+1 < len(data) && data[i+1] == '\n':
+			crlf++
+		case c == '\r':
+			if bareCR++; firstBareCR < 0 {
+				firstBareCR = i
+			}
+		case c == '\n' && (i == 0 || data[i-1] != '\r'):
+			if bareLF++; firstBareLF < 0 {
+				firstBareLF = i
+			}
+		}
+	}
+
+	native := "<CR><LF>"
+	if crlf == 0 {
+		native = "<LF>"
+	}
+
+	var nonStandard *dotLine
+	for _, line := range findDotLines(data) {
+		if line.Command != "" {
+			result.AddFinding("smtp_smuggling", models.SeverityCritical, 5,
+				fmt.Sprintf("End-of-data sequence %s at byte %d is followed by the SMTP command %s, smuggling a second message",
+					line.Sequence, line.Offset, line.Command))
+			return
+		}
+		if line.Sequence != native+"."+native && nonStandard == nil {
+			line := line
+			nonStandard = &line
+		}
+	}
+	if nonStandard != nil {
+		result.AddFinding("smtp_smuggling", models.SeverityHigh, 4,
+			fmt.Sprintf("End-of-data sequence %s at byte %d doesn't use the %s line endings of the message; some servers end the message there (SMTP smuggling)",
+				nonStandard.Sequence, nonStandard.Offset, native))
+		return
+	}
+
+	if crlf == 0 && bareCR == 0 {
+		return
+	}
+	var anomalies []string
+	if crlf > 0 && bareLF > 0 {
+		anomalies = append(anomalies, fmt.Sprintf("%d bare <LF> (first at byte %d)", bareLF, firstBareLF))
+	}
+	if bareCR > 0 {
+		anomalies = append(anomalies, fmt.Sprintf("%d bare <CR> (first at byte %d)", bareCR, firstBareCR))
+	}
+	if len(anomalies) == 0 {
+		return
+	}
+
+	result.AddFinding("mixed_line_endings", models.SeverityLow, 1,
+		fmt.Sprintf("Message mixes %s line endings with %s", native, strings.Join(anomalies, " and ")))
+}
@@ -0,0 +1,487 @@
+package detector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// SPFResult is the outcome of an SPF evaluation as defined in RFC 7208
+type SPFResult string
+
+// SPF results
+const (
+	SPFNone      SPFResult = "none"
+	SPFNeutral   SPFResult = "neutral"
+	SPFPass      SPFResult = "pass"
+	SPFFail      SPFResult = "fail"
+	SPFSoftFail  SPFResult = "softfail"
+	SPFTempError SPFResult = "temperror"
+	SPFPermError SPFResult = "permerror"
+)
+
+// spfLookupLimit is the maximum number of mechanisms and modifiers that
+// trigger DNS lookups during a single evaluation (RFC 7208 section 4.6.4)
+const spfLookupLimit = 10
+
+// spfMXLimit is the maximum number of MX records evaluated by a single "mx" mechanism
+const spfMXLimit = 10
+
+// spfPTRLimit is the maximum number of reverse DNS names validated by a "ptr" mechanism
+const spfPTRLimit = 10
+
+var (
+	errSPFLookupLimit = errors.New("too many DNS lookups")
+	// errSPFTemporary wraps DNS failures that make the evaluation inconclusive
+	errSPFTemporary = errors.New("temporary DNS failure")
+	// errSPFMultipleRecords is the permerror of a domain publishing more than one SPF record
+	errSPFMultipleRecords = errors.New("multiple SPF records")
+)
+
+// spfEvaluator evaluates SPF records for a single sending IP
+type spfEvaluator struct {
+	resolver Resolver
+	ip       net.IP
+	sender   string // Envelope sender used to expand macros
+	lookups  int
+	trace    spfTrace
+}
+
+// spfTrace records how an SPF evaluation reached its result
+type spfTrace struct {
+	usedPTR   bool     // Set once a ptr mechanism has been evaluated
+	redirects []string // Targets of the redirect= modifiers followed, in order
+}
+
+// newSPFEvaluator creates an evaluator for the given sending IP and envelope sender
+func newSPFEvaluator(resolver Resolver, ip net.IP, sender string) *spfEvaluator {
+	return &spfEvaluator{
+		resolver: resolver,
+		ip:       ip,
+		sender:   sender,
+	}
+}
+
+// checkHost evaluates the SPF record of a domain against the sending IP. The
+// returned error describes why a temperror or permerror result was reached.
+func (e *spfEvaluator) checkHost(ctx context.Context, domain string) (SPFResult, error) {
+	record, err := e.lookupRecord(ctx, domain)
+	if errors.Is(err, errSPFMultipleRecords) {
+		return SPFPermError, err
+	}
+	if err != nil {
+		return SPFTempError, err
+	}
+	if record == "" {
+		return SPFNone, nil
+	}
+
+	redirect, err := spfRedirect(record)
+	if err != nil {
+		return SPFPermError, err
+	}
+
+	for _, term := range strings.Fields(record)[1:] {
+		// Modifiers have the form name=value and don't take part in matching
+		if isSPFModifier(term) {
+			continue
+		}
+
+		result := SPFPass
+		switch term[0] {
+		case '+':
+			term = term[1:]
+		case '-':
+			result, term = SPFFail, term[1:]
+		case '~':
+			result, term = SPFSoftFail, term[1:]
+		case '?':
+			result, term = SPFNeutral, term[1:]
+		}
+
+		matched, err := e.matchMechanism(ctx, domain, term)
+		if err != nil {
+			switch {
+			case errors.Is(err, errSPFTemporary):
+				return SPFTempError, err
+			case errors.Is(err, errSPFUnsupportedMacro):
+				// Don't guess at a result the record doesn't support
+				return SPFNeutral, err
+			}
+			return SPFPermError, err
+		}
+		if matched {
+			return result, nil
+		}
+	}
+
+	// A redirect only applies when no mechanism, including "all", matched
+	// (RFC 7208 section 6.1)
+	if redirect != "" {
+		return e.followRedirect(ctx, domain, redirect)
+	}
+
+	return SPFNeutral, nil
+}
+
+// followRedirect evaluates the record a redirect= modifier points to in place of
+// the record holding it; a target without an SPF record is a permerror
+func (e *spfEvaluator) followRedirect(ctx context.Context, domain, redirect string) (SPFResult, error) {
+	target, err := e.expandMacros(redirect, domain)
+	if err != nil {
+		if errors.Is(err, errSPFUnsupportedMacro) {
+			return SPFNeutral, fmt.Errorf("redirect=%s: %w", redirect, err)
+		}
+		return SPFPermError, fmt.Errorf("redirect=%s: %w", redirect, err)
+	}
+	if err := e.countLookup(); err != nil {
+		return SPFPermError, err
+	}
+	e.trace.redirects = append(e.trace.redirects, target)
+
+	result, err := e.checkHost(ctx, target)
+	switch result {
+	case SPFNone:
+		return SPFPermError, fmt.Errorf("redirect=%s has no SPF record", target)
+	case SPFTempError:
+		return SPFTempError, fmt.Errorf("%w: redirect=%s: %v", errSPFTemporary, target, err)
+	}
+	return result, err
+}
+
+// lookupRecord fetches the SPF record of a domain, returning an empty string if there is none
+func (e *spfEvaluator) lookupRecord(ctx context.Context, domain string) (string, error) {
+	txtRecords, err := e.resolver.LookupTXT(ctx, domain)
+	if err != nil {
+		if isNotFound(err) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	return spfRecordOf(txtRecords)
+}
+
+// spfRecordOf picks the SPF record out of a domain's TXT records, returning an
+// empty string if there is none and errSPFMultipleRecords if there are several,
+// which RFC 7208 section 4.5 makes a permerror
+func spfRecordOf(txtRecords []string) (string, error) {
+	var records []string
+	for _, record := range txtRecords {
+		if isSPFRecord(record) {
+			records = append(records, record)
+		}
+	}
+
+	switch len(records) {
+	case 0:
+		return "", nil
+	case 1:
+		return records[0], nil
+	default:
+		return "", fmt.Errorf("%w (%d published)", errSPFMultipleRecords, len(records))
+	}
+}
+
+// matchMechanism checks if the sending IP matches a single SPF mechanism
+func (e *spfEvaluator) matchMechanism(ctx context.Context, domain, term string) (bool, error) {
+	name, value := splitSPFMechanism(term)
+
+	switch name {
+	case "all":
+		return true, nil
+
+	case "ip4", "ip6":
+		network := value
+		if !strings.Contains(network, "/") {
+			if name == "ip4" {
+				network += "/32"
+			} else {
+				network += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(network)
+		if err != nil {
+			return false, fmt.Errorf("invalid %s mechanism %q", name, term)
+		}
+		return ipNet.Contains(e.ip), nil
+
+	case "a":
+		target, v4Len, v6Len, err := parseSPFTarget(domain, value)
+		if err != nil {
+			return false, fmt.Errorf("invalid a mechanism %q: %w", term, err)
+		}
+		if target, err = e.expandMacros(target, domain); err != nil {
+			return false, fmt.Errorf("a mechanism %q: %w", term, err)
+		}
+		if err := e.countLookup(); err != nil {
+			return false, err
+		}
+		return e.matchHostIPs(ctx, target, v4Len, v6Len)
+
+	case "mx":
+		target, v4Len, v6Len, err := parseSPFTarget(domain, value)
+		if err != nil {
+			return false, fmt.Errorf("invalid mx mechanism %q: %w", term, err)
+		}
+		if target, err = e.expandMacros(target, domain); err != nil {
+			return false, fmt.Errorf("mx mechanism %q: %w", term, err)
+		}
+		if err := e.countLookup(); err != nil {
+			return false, err
+		}
+		return e.matchMX(ctx, target, v4Len, v6Len)
+
+	case "include":
+		if value == "" {
+			return false, fmt.Errorf("include mechanism without a domain")
+		}
+		value, err := e.expandMacros(value, domain)
+		if err != nil {
+			return false, fmt.Errorf("include mechanism %q: %w", term, err)
+		}
+		if err := e.countLookup(); err != nil {
+			return false, err
+		}
+		result, err := e.checkHost(ctx, value)
+		switch result {
+		case SPFPass:
+			return true, nil
+		case SPFTempError:
+			return false, fmt.Errorf("%w: include:%s: %v", errSPFTemporary, value, err)
+		case SPFPermError, SPFNone:
+			return false, fmt.Errorf("include:%s has no valid SPF record", value)
+		}
+		return false, nil
+
+	case "exists":
+		if value == "" {
+			return false, fmt.Errorf("exists mechanism without a domain")
+		}
+		value, err := e.expandMacros(value, domain)
+		if err != nil {
+			return false, fmt.Errorf("exists mechanism %q: %w", term, err)
+		}
+		if err := e.countLookup(); err != nil {
+			return false, err
+		}
+		ips, err := e.resolver.LookupIP(ctx, "ip4", value)
+		if err != nil && !isNotFound(err) {
+			return false, fmt.Errorf("%w: exists:%s: %v", errSPFTemporary, value, err)
+		}
+		return len(ips) > 0, nil
+
+	case "ptr":
+		target := domain
+		if value != "" {
+			expanded, err := e.expandMacros(value, domain)
+			if err != nil {
+				return false, fmt.Errorf("ptr mechanism %q: %w", term, err)
+			}
+			target = expanded
+		}
+		if err := e.countLookup(); err != nil {
+			return false, err
+		}
+		e.trace.usedPTR = true
+		return e.matchPTR(ctx, target), nil
+	}
+
+	return false, fmt.Errorf("unknown mechanism %q", term)
+}
+
+// matchHostIPs checks if the sending IP is within the given prefix of any address of a host
+func (e *spfEvaluator) matchHostIPs(ctx context.Context, host string, v4Len, v6Len int) (bool, error) {
+	ips, err := e.resolver.LookupIP(ctx, "ip", host)
+	if err != nil {
+		if isNotFound(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("%w: %s: %v", errSPFTemporary, host, err)
+	}
+
+	for _, ip := range ips {
+		if ipInPrefix(e.ip, ip, v4Len, v6Len) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// matchMX checks if the sending IP belongs to any of the mail exchangers of a domain
+func (e *spfEvaluator) matchMX(ctx context.Context, domain string, v4Len, v6Len int) (bool, error) {
+	mxRecords, err := e.resolver.LookupMX(ctx, domain)
+	if err != nil {
+		if isNotFound(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("%w: mx %s: %v", errSPFTemporary, domain, err)
+	}
+	if len(mxRecords) > spfMXLimit {
+		return false, fmt.Errorf("domain %s has more than %d MX records", domain, spfMXLimit)
+	}
+
+	for _, mx := range mxRecords {
+		matched, err := e.matchHostIPs(ctx, strings.TrimSuffix(mx.Host, "."), v4Len, v6Len)
+		if err != nil || matched {
+			return matched, err
+		}
+	}
+
+	return false, nil
+}
+
+// matchPTR checks if the sending IP has a validated reverse DNS name within the
+// target domain (RFC 7208 section 5.5). Only the first spfPTRLimit names are
+// validated, and DNS errors make the mechanism not match.
+func (e *spfEvaluator) matchPTR(ctx context.Context, target string) bool {
+	names, err := e.resolver.LookupAddr(ctx, e.ip.String())
+	if err != nil {
+		return false
+	}
+	if len(names) > spfPTRLimit {
+		names = names[:spfPTRLimit]
+	}
+
+	target = strings.ToLower(strings.TrimSuffix(target, "."))
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSuffix(name, "."))
+		if name != target && !strings.HasSuffix(name, "."+target) {
+			continue
+		}
+
+		// The name only counts if it resolves back to the sending IP
+		if matched, err := e.matchHostIPs(ctx, name, 32, 128); err == nil && matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// countLookup accounts for a DNS-querying term and enforces the lookup limit
+func (e *spfEvaluator) countLookup() error {
+	e.lookups++
+	if e.lookups > spfLookupLimit {
+		return errSPFLookupLimit
+	}
+	return nil
+}
+
+// isSPFRecord checks if a TXT record is an SPF version 1 record
+func isSPFRecord(record string) bool {
+	return record == "v=spf1" || strings.HasPrefix(record, "v=spf1 ")
+}
+
+// spfRedirect returns the domain of the redirect= modifier of an SPF record, or
+// an empty string if there is none. RFC 7208 section 6 allows it only once.
+func spfRedirect(record string) (string, error) {
+	redirect := ""
+	for _, term := range strings.Fields(record)[1:] {
+		if !isSPFModifier(term) {
+			continue
+		}
+		name, value, _ := strings.Cut(term, "=")
+		if !strings.EqualFold(name, "redirect") {
+			continue
+		}
+		if redirect != "" {
+			return "", fmt.Errorf("more than one redirect modifier")
+		}
+		if value == "" {
+			return "", fmt.Errorf("redirect modifier without a domain")
+		}
+		redirect = value
+	}
+	return redirect, nil
+}
+
+// hasSPFAll checks if an SPF record has an "all" mechanism, which makes any
+// redirect= modifier irrelevant
+func hasSPFAll(record string) bool {
+	for _, term := range strings.Fields(record)[1:] {
+		if strings.EqualFold(strings.TrimLeft(term, "+-~?"), "all") {
+			return true
+		}
+	}
+	return false
+}
+
+// isSPFModifier checks if an SPF term is a modifier (name=value) rather than a mechanism
+func isSPFModifier(term string) bool {
+	eq := strings.Index(term, "=")
+	return eq > 0 && !strings.ContainsAny(term[:eq], ":/")
+}
+
+// splitSPFMechanism splits a mechanism into its lower-cased name and the
+// remainder, which is either ":domain[/cidr]", "/cidr" or empty
+func splitSPFMechanism(term string) (string, string) {
+	end := strings.IndexAny(term, ":/")
+	if end < 0 {
+		return strings.ToLower(term), ""
+	}
+
+	name := strings.ToLower(term[:end])
+	if term[end] == ':' {
+		return name, term[end+1:]
+	}
+	return name, term[end:]
+}
+
+// parseSPFTarget parses the "domain/cidr4//cidr6" argument of the a and mx
+// mechanisms, defaulting to the current domain and full-length prefixes
+func parseSPFTarget(domain, value string) (string, int, int, error) {
+	target := domain
+	cidr := value
+	if value != "" && value[0] != '/' {
+		// Macro delimiters may include "/", so the prefix starts after the last macro
+		start := strings.LastIndex(value, "}") + 1
+		target, cidr = value, ""
+		if idx := strings.Index(value[start:], "/"); idx >= 0 {
+			target, cidr = value[:start+idx], value[start+idx:]
+		}
+	}
+
+	v4Len, v6Len := 32, 128
+	if cidr == "" {
+		return target, v4Len, v6Len, nil
+	}
+
+	v4Text, v6Text, hasV6 := strings.Cut(cidr[1:], "//")
+	if strings.HasPrefix(cidr, "//") {
+		v4Text, v6Text, hasV6 = "", cidr[2:], true
+	}
+
+	var err error
+	if v4Text != "" {
+		if v4Len, err = strconv.Atoi(v4Text); err != nil || v4Len < 0 || v4Len > 32 {
+			return "", 0, 0, fmt.Errorf("invalid IPv4 prefix length %q", v4Text)
+		}
+	}
+	if hasV6 {
+		if v6Len, err = strconv.Atoi(v6Text); err != nil || v6Len < 0 || v6Len > 128 {
+			return "", 0, 0, fmt.Errorf("invalid IPv6 prefix length %q", v6Text)
+		}
+	}
+
+	return target, v4Len, v6Len, nil
+}
+
+// ipInPrefix checks if ip is within the prefix of the given length around network,
+// using v4Len for IPv4 and v6Len for IPv6 addresses
+func ipInPrefix(ip, network net.IP, v4Len, v6Len int) bool {
+	if ip4, network4 := ip.To4(), network.To4(); ip4 != nil || network4 != nil {
+		if ip4 == nil || network4 == nil {
+			return false
+		}
+		mask := net.CIDRMask(v4Len, 32)
+		return ip4.Mask(mask).Equal(network4.Mask(mask))
+	}
+
+	mask := net.CIDRMask(v6Len, 128)
+	return ip.Mask(mask).Equal(network.Mask(mask))
+}
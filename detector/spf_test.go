@@ -0,0 +1,227 @@
+package detector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// evaluateSPFRecords evaluates the SPF record of example.com against a sending IP
+func evaluateSPFRecords(resolver Resolver, ip string) (SPFResult, error) {
+	evaluator := newSPFEvaluator(resolver, net.ParseIP(ip), "sender@example.com")
+	return evaluator.checkHost(context.Background(), "example.com")
+}
+
+func TestSPFAMechanism(t *testing.T) {
+	resolver := &fakeResolver{
+		ip: map[string][]net.IP{
+			"example.com":     ips("192.0.2.10", "2001:db8::10"),
+			"web.example.net": ips("198.51.100.20"),
+		},
+	}
+
+	tests := []struct {
+		record string
+		ip     string
+		want   SPFResult
+	}{
+		{"v=spf1 a -all", "192.0.2.10", SPFPass},
+		{"v=spf1 a -all", "192.0.2.11", SPFFail},
+		{"v=spf1 a/24 -all", "192.0.2.99", SPFPass},
+		{"v=spf1 a/24 -all", "192.0.3.10", SPFFail},
+		{"v=spf1 a//64 -all", "2001:db8::ffff", SPFPass},
+		{"v=spf1 a -all", "2001:db8::10", SPFPass},
+		{"v=spf1 a:web.example.net -all", "198.51.100.20", SPFPass},
+		{"v=spf1 a:web.example.net/30 ~all", "198.51.100.23", SPFPass},
+		{"v=spf1 a:web.example.net/30 ~all", "198.51.100.24", SPFSoftFail},
+		{"v=spf1 a:missing.example.net -all", "198.51.100.20", SPFFail},
+	}
+	for _, test := range tests {
+		resolver.txt = map[string][]string{"example.com": {test.record}}
+		got, err := evaluateSPFRecords(resolver, test.ip)
+		if got != test.want {
+			t.Errorf("%q from %s = %s (%v), want %s", test.record, test.ip, got, err, test.want)
+		}
+	}
+}
+
+func TestSPFMXMechanism(t *testing.T) {
+	resolver := &fakeResolver{
+		mx: map[string][]*net.MX{
+			"example.com":      {{Host: "mx1.example.com.", Pref: 10}, {Host: "mx2.example.com.", Pref: 20}},
+			"example.org":      {{Host: "mail.example.org.", Pref: 10}},
+			"dangling.example": {{Host: "gone.example.", Pref: 10}},
+		},
+		ip: map[string][]net.IP{
+			"mx1.example.com":  ips("192.0.2.25"),
+			"mx2.example.com":  ips("192.0.2.26", "2001:db8::25"),
+			"mail.example.org": ips("203.0.113.5"),
+		},
+	}
+
+	tests := []struct {
+		record string
+		ip     string
+		want   SPFResult
+	}{
+		{"v=spf1 mx -all", "192.0.2.25", SPFPass},
+		{"v=spf1 mx -all", "192.0.2.26", SPFPass},
+		{"v=spf1 mx -all", "2001:db8::25", SPFPass},
+		{"v=spf1 mx -all", "192.0.2.27", SPFFail},
+		{"v=spf1 mx/28 -all", "192.0.2.27", SPFPass},
+		{"v=spf1 mx:example.org -all", "203.0.113.5", SPFPass},
+		{"v=spf1 mx:example.org/24 -all", "203.0.113.200", SPFPass},
+		{"v=spf1 mx:dangling.example -all", "192.0.2.25", SPFFail},
+		{"v=spf1 mx:nomx.example ?all", "192.0.2.25", SPFNeutral},
+	}
+	for _, test := range tests {
+		resolver.txt = map[string][]string{"example.com": {test.record}}
+		got, err := evaluateSPFRecords(resolver, test.ip)
+		if got != test.want {
+			t.Errorf("%q from %s = %s (%v), want %s", test.record, test.ip, got, err, test.want)
+		}
+	}
+}
+
+func TestSPFAMXLookupLimit(t *testing.T) {
+	resolver := &fakeResolver{
+		ip: map[string][]net.IP{"example.com": ips("192.0.2.10")},
+		mx: map[string][]*net.MX{"example.com": {{Host: "mx.example.com.", Pref: 10}}},
+	}
+
+	// Ten lookups are allowed, the eleventh is a permerror even if a later term would match
+	terms := strings.Repeat("a:other.example ", 5) + strings.Repeat("mx:other.example ", 5)
+	resolver.txt = map[string][]string{"example.com": {"v=spf1 " + terms + "a -all"}}
+	got, err := evaluateSPFRecords(resolver, "192.0.2.10")
+	if got != SPFPermError || !errors.Is(err, errSPFLookupLimit) {
+		t.Errorf("eleven a and mx lookups = %s (%v), want permerror for the lookup limit", got, err)
+	}
+
+	resolver.txt = map[string][]string{"example.com": {"v=spf1 " + terms + "ip4:192.0.2.10 -all"}}
+	if got, err := evaluateSPFRecords(resolver, "192.0.2.10"); got != SPFPass {
+		t.Errorf("ten a and mx lookups = %s (%v), want pass", got, err)
+	}
+}
+
+func TestSPFAMXTemporaryError(t *testing.T) {
+	resolver := &fakeResolver{
+		txt:  map[string][]string{"example.com": {"v=spf1 a:broken.example mx -all"}},
+		fail: map[string]bool{"broken.example": true},
+	}
+
+	if got, err := evaluateSPFRecords(resolver, "192.0.2.10"); got != SPFTempError {
+		t.Errorf("a mechanism with a failing lookup = %s (%v), want temperror", got, err)
+	}
+}
+
+func TestSPFPTRMechanism(t *testing.T) {
+	// Eleven reverse names of which only the last is validated
+	var many []string
+	for i := 1; i <= spfPTRLimit; i++ {
+		many = append(many, fmt.Sprintf("host%d.example.com.", i))
+	}
+	many = append(many, "mail.example.com.")
+
+	tests := []struct {
+		name   string
+		record string
+		ptr    []string
+		want   SPFResult
+	}{
+		{"validated name", "v=spf1 ptr -all", []string{"mail.example.com."}, SPFPass},
+		{"validated name of the domain itself", "v=spf1 ptr -all", []string{"example.com."}, SPFPass},
+		{"validated name under another domain", "v=spf1 ptr:example.org -all", []string{"mx.example.org."}, SPFPass},
+		{"name outside the domain", "v=spf1 ptr -all", []string{"mail.example.org."}, SPFFail},
+		{"forged name", "v=spf1 ptr -all", []string{"forged.example.com."}, SPFFail},
+		{"name lookalike of the domain", "v=spf1 ptr -all", []string{"mail.badexample.com."}, SPFFail},
+		{"no reverse name", "v=spf1 ptr -all", nil, SPFFail},
+		{"validated name after the limit", "v=spf1 ptr -all", many, SPFFail},
+		{"validated name within the limit", "v=spf1 ptr -all", many[1:], SPFPass},
+	}
+	for _, test := range tests {
+		resolver := &fakeResolver{
+			txt: map[string][]string{"example.com": {test.record}},
+			ip: map[string][]net.IP{
+				"mail.example.com":    ips("192.0.2.3"),
+				"example.com":         ips("192.0.2.3"),
+				"mx.example.org":      ips("192.0.2.3"),
+				"mail.example.org":    ips("192.0.2.3"),
+				"mail.badexample.com": ips("192.0.2.3"),
+				"forged.example.com":  ips("198.51.100.7"),
+			},
+			ptr: map[string][]string{},
+		}
+		if test.ptr != nil {
+			resolver.ptr["192.0.2.3"] = test.ptr
+		}
+
+		evaluator := newSPFEvaluator(resolver, net.ParseIP("192.0.2.3"), "sender@example.com")
+		got, err := evaluator.checkHost(context.Background(), "example.com")
+		if got != test.want {
+			t.Errorf("%s: %s = %s (%v), want %s", test.name, test.record, got, err, test.want)
+		}
+		if !evaluator.trace.usedPTR {
+			t.Errorf("%s: evaluation didn't record the use of ptr", test.name)
+		}
+	}
+}
+
+func TestSPFRedirect(t *testing.T) {
+	resolver := &fakeResolver{
+		txt: map[string][]string{
+			"example.com":        {"v=spf1 redirect=_spf.example.com"},
+			"_spf.example.com":   {"v=spf1 ip4:192.0.2.0/24 -all"},
+			"loop-a.example":     {"v=spf1 redirect=loop-b.example"},
+			"loop-b.example":     {"v=spf1 redirect=loop-a.example"},
+			"with-all.example":   {"v=spf1 ip4:198.51.100.1 ~all redirect=_spf.example.com"},
+			"dangling.example":   {"v=spf1 redirect=missing.example"},
+			"chained.example":    {"v=spf1 redirect=example.com"},
+			"broken.example":     {"v=spf1 redirect=servfail.example"},
+			"macro.example":      {"v=spf1 redirect=_spf.%{d2}"},
+			"_spf.macro.example": {"v=spf1 ip4:192.0.2.7 -all"},
+			"twice.example":      {"v=spf1 redirect=a.example redirect=b.example"},
+		},
+		fail: map[string]bool{"servfail.example": true},
+	}
+
+	tests := []struct {
+		domain    string
+		ip        string
+		want      SPFResult
+		redirects []string
+		errText   string
+	}{
+		{"example.com", "192.0.2.7", SPFPass, []string{"_spf.example.com"}, ""},
+		{"example.com", "203.0.113.7", SPFFail, []string{"_spf.example.com"}, ""},
+		{"chained.example", "192.0.2.7", SPFPass, []string{"example.com", "_spf.example.com"}, ""},
+		{"macro.example", "192.0.2.7", SPFPass, []string{"_spf.macro.example"}, ""},
+		// "all" matches before the redirect is considered (RFC 7208 section 6.1)
+		{"with-all.example", "192.0.2.7", SPFSoftFail, nil, ""},
+		{"with-all.example", "198.51.100.1", SPFPass, nil, ""},
+		// A loop runs into the lookup limit
+		{"loop-a.example", "192.0.2.7", SPFPermError, nil, errSPFLookupLimit.Error()},
+		{"dangling.example", "192.0.2.7", SPFPermError, []string{"missing.example"}, "redirect=missing.example has no SPF record"},
+		{"broken.example", "192.0.2.7", SPFTempError, []string{"servfail.example"}, "temporary DNS failure"},
+		{"twice.example", "192.0.2.7", SPFPermError, nil, "more than one redirect modifier"},
+	}
+	for _, test := range tests {
+		evaluator := newSPFEvaluator(resolver, net.ParseIP(test.ip), "sender@"+test.domain)
+		got, err := evaluator.checkHost(context.Background(), test.domain)
+		if got != test.want {
+			t.Errorf("%s from %s = %s (%v), want %s", test.domain, test.ip, got, err, test.want)
+		}
+		if test.errText != "" && (err == nil || !strings.Contains(err.Error(), test.errText)) {
+			t.Errorf("%s from %s error = %v, want one mentioning %q", test.domain, test.ip, err, test.errText)
+		}
+		if test.redirects != nil && !reflect.DeepEqual(evaluator.trace.redirects, test.redirects) {
+			t.Errorf("%s from %s followed %q, want %q", test.domain, test.ip, evaluator.trace.redirects, test.redirects)
+		}
+		if test.redirects == nil && test.want != SPFPermError && len(evaluator.trace.redirects) > 0 {
+			t.Errorf("%s from %s followed %q, want no redirect", test.domain, test.ip, evaluator.trace.redirects)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package detector
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// errSPFUnsupportedMacro marks records using macros that can't be expanded
+// without information the detector doesn't have, such as the HELO name
+var errSPFUnsupportedMacro = errors.New("unsupported macro")
+
+// spfMaxDomainLength is the longest domain name a macro expansion may produce;
+// longer expansions lose their leftmost labels (RFC 7208 section 7.3)
+const spfMaxDomainLength = 253
+
+// expandMacros expands the macros of an SPF domain-spec (RFC 7208 section 7)
+// against the sending IP, the sender and the domain being evaluated
+func (e *spfEvaluator) expandMacros(spec, domain string) (string, error) {
+	if !strings.Contains(spec, "%") {
+		return spec, nil
+	}
+
+	var expanded strings.Builder
+	for i := 0; i < len(spec); i++ {
+		if spec[i] != '%' {
+			expanded.WriteByte(spec[i])
+			continue
+		}
+
+		if i+1 >= len(spec) {
+			return "", fmt.Errorf("invalid macro at the end of %q", spec)
+		}
+		i++
+		switch spec[i] {
+		case '%':
+			expanded.WriteByte('%')
+		case '_':
+			expanded.WriteByte(' ')
+		case '-':
+			expanded.WriteString("%20")
+		case '{':
+			end := strings.IndexByte(spec[i:], '}')
+			if end < 0 {
+				return "", fmt.Errorf("unterminated macro in %q", spec)
+			}
+			value, err := e.expandMacro(spec[i+1:i+end], domain)
+			if err != nil {
+				return "", err
+			}
+			expanded.WriteString(value)
+			i += end
+		default:
+			return "", fmt.Errorf("invalid macro %q in %q", spec[i-1:i+1], spec)
+		}
+	}
+
+	result := expanded.String()
+	for len(result) > spfMaxDomainLength {
+		dot := strings.IndexByte(result, '.')
+		if dot < 0 {
+			break
+		}
+		result = result[dot+1:]
+	}
+
+	return result, nil
+}
+
+// expandMacro expands the body of a single %{...} macro: a letter followed by an
+// optional number of labels to keep, an optional "r" to reverse them, and delimiters
+func (e *spfEvaluator) expandMacro(macro, domain string) (string, error) {
+	if macro == "" {
+		return "", errors.New("empty macro")
+	}
+
+	letter := macro[0]
+	value, err := e.macroValue(letter, domain)
+	if err != nil {
+		return "", err
+	}
+
+	rest := macro[1:]
+	digits := 0
+	for digits < len(rest) && rest[digits] >= '0' && rest[digits] <= '9' {
+		digits++
+	}
+	keep := 0
+	if digits > 0 {
+		if keep, err = strconv.Atoi(rest[:digits]); err != nil || keep == 0 {
+			return "", fmt.Errorf("invalid macro %%{%s}", macro)
+		}
+	}
+	rest = rest[digits:]
+
+	reverse := false
+	if rest != "" && (rest[0] == 'r' || rest[0] == 'R') {
+		reverse, rest = true, rest[1:]
+	}
+
+	delimiters := "."
+	if rest != "" {
+		if strings.Trim(rest, ".-+,/_=") != "" {
+			return "", fmt.Errorf("invalid macro %%{%s}", macro)
+		}
+		delimiters = rest
+	}
+
+	labels := strings.FieldsFunc(value, func(r rune) bool {
+		return strings.ContainsRune(delimiters, r)
+	})
+	if reverse {
+		for i, j := 0, len(labels)-1; i < j; i, j = i+1, j-1 {
+			labels[i], labels[j] = labels[j], labels[i]
+		}
+	}
+	if keep > 0 && keep < len(labels) {
+		labels = labels[len(labels)-keep:]
+	}
+	value = strings.Join(labels, ".")
+
+	// Upper-case macro letters are URL escaped
+	if letter >= 'A' && letter <= 'Z' {
+		value = strings.ReplaceAll(url.QueryEscape(value), "+", "%20")
+	}
+
+	return value, nil
+}
+
+// macroValue returns the unprocessed value of a macro letter
+func (e *spfEvaluator) macroValue(letter byte, domain string) (string, error) {
+	localPart, senderDomain, _ := strings.Cut(e.sender, "@")
+
+	switch letter | 0x20 { // Compare lower-cased
+	case 's':
+		return e.sender, nil
+	case 'l':
+		return localPart, nil
+	case 'o':
+		return senderDomain, nil
+	case 'd':
+		return domain, nil
+	case 'i':
+		return spfMacroIP(e.ip), nil
+	case 'v':
+		if e.ip.To4() != nil {
+			return "in-addr", nil
+		}
+		return "ip6", nil
+	case 'p':
+		// The validated reverse DNS name isn't looked up; RFC 7208 permits "unknown"
+		return "unknown", nil
+	}
+
+	return "", fmt.Errorf("%w %%{%c}", errSPFUnsupportedMacro, letter)
+}
+
+// spfMacroIP formats an IP address for the "i" macro: dotted decimal for IPv4
+// and dot-separated nibbles for IPv6
+func spfMacroIP(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.String()
+	}
+
+	ip16 := ip.To16()
+	nibbles := make([]string, 0, 32)
+	for _, b := range ip16 {
+		nibbles = append(nibbles, strconv.FormatUint(uint64(b>>4), 16), strconv.FormatUint(uint64(b&0xf), 16))
+	}
+	return strings.Join(nibbles, ".")
+}
@@ -0,0 +1,79 @@
+package detector
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+// TestExpandMacrosRFC7208 checks the examples of RFC 7208 section 7.4
+func TestExpandMacrosRFC7208(t *testing.T) {
+	evaluator := newSPFEvaluator(nil, net.ParseIP("192.0.2.3"), "strong-bad@email.example.com")
+
+	tests := []struct {
+		spec string
+		want string
+	}{
+		{"%{s}", "strong-bad@email.example.com"},
+		{"%{o}", "email.example.com"},
+		{"%{d}", "email.example.com"},
+		{"%{d4}", "email.example.com"},
+		{"%{d3}", "email.example.com"},
+		{"%{d2}", "example.com"},
+		{"%{d1}", "com"},
+		{"%{dr}", "com.example.email"},
+		{"%{d2r}", "example.email"},
+		{"%{l}", "strong-bad"},
+		{"%{l-}", "strong.bad"},
+		{"%{lr}", "strong-bad"},
+		{"%{lr-}", "bad.strong"},
+		{"%{l1r-}", "strong"},
+		{"%{ir}.%{v}._spf.%{d2}", "3.2.0.192.in-addr._spf.example.com"},
+		{"%{lr-}.lp._spf.%{d2}", "bad.strong.lp._spf.example.com"},
+		{"%{lr-}.lp.%{ir}.%{v}._spf.%{d2}", "bad.strong.lp.3.2.0.192.in-addr._spf.example.com"},
+		{"%{ir}.%{v}.%{l1r-}.lp._spf.%{d2}", "3.2.0.192.in-addr.strong.lp._spf.example.com"},
+		{"%{d2}.trusted-domains.example.net", "example.com.trusted-domains.example.net"},
+	}
+	for _, test := range tests {
+		got, err := evaluator.expandMacros(test.spec, "email.example.com")
+		if err != nil || got != test.want {
+			t.Errorf("expandMacros(%q) = %q, %v, want %q", test.spec, got, err, test.want)
+		}
+	}
+
+	evaluator.ip = net.ParseIP("2001:db8::cb01")
+	const want = "1.0.b.c.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6._spf.example.com"
+	if got, err := evaluator.expandMacros("%{ir}.%{v}._spf.%{d2}", "email.example.com"); err != nil || got != want {
+		t.Errorf("expandMacros for IPv6 = %q, %v, want %q", got, err, want)
+	}
+}
+
+func TestExpandMacrosEscapes(t *testing.T) {
+	evaluator := newSPFEvaluator(nil, net.ParseIP("192.0.2.3"), "strong-bad@email.example.com")
+
+	tests := []struct {
+		spec string
+		want string
+	}{
+		{"%%-%_-%-", "%- -%20"},
+		{"%{L}.example.com", "strong-bad.example.com"},
+		{"%{S}", "strong-bad%40email.example.com"},
+		{"no-macros.example.com", "no-macros.example.com"},
+	}
+	for _, test := range tests {
+		got, err := evaluator.expandMacros(test.spec, "email.example.com")
+		if err != nil || got != test.want {
+			t.Errorf("expandMacros(%q) = %q, %v, want %q", test.spec, got, err, test.want)
+		}
+	}
+
+	for _, spec := range []string{"%{d0}", "%{x}", "%{d", "%", "%a", "%{d2*}"} {
+		if got, err := evaluator.expandMacros(spec, "email.example.com"); err == nil {
+			t.Errorf("expandMacros(%q) = %q, want an error", spec, got)
+		}
+	}
+
+	if _, err := evaluator.expandMacros("%{h}", "email.example.com"); !errors.Is(err, errSPFUnsupportedMacro) {
+		t.Errorf("expandMacros(%%{h}) error = %v, want errSPFUnsupportedMacro", err)
+	}
+}
@@ -0,0 +1,97 @@
+package detector
+
+import (
+	"context"
+	"log"
+	"net/mail"
+	"strings"
+
+	"github.com/user/email_spoof_detection/models"
+)
+
+// relatedSubdomain is a subdomain of the From domain named in another header
+type relatedSubdomain struct {
+	domain string
+	header string
+}
+
+// relatedSubdomains returns the subdomains of the From domain that an email's
+// Return-Path, Sender, DKIM signatures and Message-ID use, such as the
+// mail.example.com of a bounce address for email from example.com
+func relatedSubdomains(email *models.Email, fromDomain string) []relatedSubdomain {
+	candidates := []relatedSubdomain{
+		{returnPathDomain(email), "Return-Path"},
+	}
+	if sender, err := mail.ParseAddress(email.GetHeaderValue("Sender")); err == nil {
+		candidates = append(candidates, relatedSubdomain{strings.ToLower(models.GetDomain(sender)), "Sender"})
+	}
+	for _, value := range email.GetAllHeaderValues("DKIM-Signature") {
+		candidates = append(candidates, relatedSubdomain{parseDKIMSignature(value).Domain, "DKIM d="})
+	}
+	candidates = append(candidates, relatedSubdomain{models.GetMessageIDDomain(email.MessageID), "Message-ID"})
+
+	var subdomains []relatedSubdomain
+	seen := map[string]bool{}
+	for _, candidate := range candidates {
+		domain := strings.TrimSuffix(candidate.domain, ".")
+		if !strings.HasSuffix(domain, "."+fromDomain) || seen[domain] {
+			continue
+		}
+		seen[domain] = true
+		subdomains = append(subdomains, relatedSubdomain{domain, candidate.header})
+	}
+	return subdomains
+}
+
+// checkSubdomainOnlyAuth notes when the From domain has no SPF or DMARC record but
+// a subdomain used by the same email has one. Neither is inherited downwards, so
+// records published only for mail.example.com leave example.com itself spoofable
+// while looking protected to its operators.
+func (d *SpoofDetector) checkSubdomainOnlyAuth(ctx context.Context, email *models.Email, result *models.AnalysisResult) {
+	auth := result.Authentication
+	if d.config.Offline || auth.SPF != string(SPFNone) && auth.DMARC != "missing" {
+		return
+	}
+	if !d.selected("spf_subdomain_only") && !d.selected("dmarc_subdomain_only") {
+		return
+	}
+
+	fromDomain := strings.ToLower(strings.TrimSuffix(models.GetDomain(email.From), "."))
+	if fromDomain == "" {
+		return
+	}
+
+	var spfSubdomains, dmarcSubdomains []string
+	for _, subdomain := range relatedSubdomains(email, fromDomain) {
+		label := subdomain.domain + " (" + subdomain.header + ")"
+		if auth.SPF == string(SPFNone) {
+			if txtRecords, err := d.resolver.LookupTXT(ctx, subdomain.domain); err == nil {
+				if record, _ := spfRecordOf(txtRecords); record != "" {
+					spfSubdomains = append(spfSubdomains, label)
+				}
+			} else if !isNotFound(err) {
+				log.Printf("SPF lookup error for domain %s: %v", subdomain.domain, err)
+				result.AddDiagnostic("SPF lookup failed for domain " + subdomain.domain + ": " + err.Error())
+			}
+		}
+		if auth.DMARC == "missing" {
+			if record, err := d.lookupDMARC(ctx, subdomain.domain); err == nil && record != "" {
+				dmarcSubdomains = append(dmarcSubdomains, label)
+			} else if err != nil {
+				log.Printf("DMARC lookup error for domain _dmarc.%s: %v", subdomain.domain, err)
+				result.AddDiagnostic("DMARC lookup failed for domain " + subdomain.domain + ": " + err.Error())
+			}
+		}
+	}
+
+	if len(spfSubdomains) > 0 {
+		result.AddFinding("spf_subdomain_only", models.SeverityInfo, 0,
+			"No SPF record at "+fromDomain+" (the From domain), though its subdomain "+strings.Join(spfSubdomains, ", ")+
+				" has one; SPF isn't inherited, so "+fromDomain+" itself is unprotected")
+	}
+	if len(dmarcSubdomains) > 0 {
+		result.AddFinding("dmarc_subdomain_only", models.SeverityInfo, 0,
+			"No DMARC record at _dmarc."+fromDomain+" (the From domain), though its subdomain "+strings.Join(dmarcSubdomains, ", ")+
+				" has one; DMARC policies only apply downwards, so "+fromDomain+" itself is unprotected")
+	}
+}
@@ -0,0 +1,109 @@
+package detector
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/user/email_spoof_detection/models"
+)
+
+// maxCNAMEChain is the longest CNAME chain followed when looking for a dangling target
+const maxCNAMEChain = 5
+
+// takeoverServices are hosting services whose resources, once deleted, can be
+// created again by anyone under the same name, taking over the subdomains whose
+// CNAME records still point at them
+var takeoverServices = []string{
+	"amazonaws.com", "azureedge.net", "azurewebsites.net", "blob.core.windows.net",
+	"cloudapp.azure.com", "cloudapp.net", "trafficmanager.net", "herokuapp.com",
+	"herokudns.com", "github.io", "bitbucket.io", "ghost.io", "netlify.app",
+	"pantheonsite.io", "readthedocs.io", "surge.sh", "webflow.io", "myshopify.com",
+	"wordpress.com", "wpengine.com", "zendesk.com", "helpscoutdocs.com", "unbouncepages.com",
+}
+
+// takeoverServiceOf returns the hosting service a CNAME target belongs to, or an empty string
+func takeoverServiceOf(target string) string {
+	for _, service := range takeoverServices {
+		if target == service || strings.HasSuffix(target, "."+service) {
+			return service
+		}
+	}
+	return ""
+}
+
+// checkDanglingCNAME flags a From or Reply-To domain that is a CNAME to a name
+// that doesn't exist. Whoever claims the target, typically a deleted cloud
+// resource or an expired domain, controls the sender domain and can receive
+// the replies, which makes it a subdomain takeover candidate.
+func (d *SpoofDetector) checkDanglingCNAME(ctx context.Context, email *models.Email, result *models.AnalysisResult) {
+	if !d.config.CheckDanglingCNAME || !d.selected("dangling_cname") {
+		return
+	}
+	if d.config.Offline {
+		result.NotEvaluated = append(result.NotEvaluated, "dangling_cname")
+		return
+	}
+
+	checked := map[string]bool{}
+	for _, sender := range []struct {
+		header string
+		domain string
+	}{
+		{"From", models.GetDomain(email.From)},
+		{"Reply-To", models.GetDomain(email.ReplyTo)},
+	} {
+		domain := strings.ToLower(strings.TrimSuffix(sender.domain, "."))
+		if domain == "" || checked[domain] {
+			continue
+		}
+		checked[domain] = true
+
+		target, dangling, err := d.danglingCNAME(ctx, domain)
+		if err != nil {
+			result.AddDiagnostic("CNAME chain of " + sender.header + " domain " + domain + " couldn't be followed: " + err.Error())
+		}
+		if !dangling {
+			continue
+		}
+
+		message := sender.header + " domain " + domain + " is a CNAME to " + target + ", which doesn't exist"
+		if service := takeoverServiceOf(target); service != "" {
+			message += ": an unclaimed " + service + " resource that anyone can create (subdomain takeover risk)"
+		} else {
+			message += " (subdomain takeover risk if its domain can be registered)"
+		}
+		result.AddFinding("dangling_cname", models.SeverityHigh, 3, message)
+	}
+}
+
+// danglingCNAME follows the CNAME chain of a domain and returns its last target
+// and whether that target doesn't exist. Lookup failures count as not dangling
+// and are returned.
+func (d *SpoofDetector) danglingCNAME(ctx context.Context, domain string) (string, bool, error) {
+	name, target := domain, ""
+	for i := 0; i < maxCNAMEChain; i++ {
+		cname, err := d.resolver.LookupCNAME(ctx, name)
+		if err != nil {
+			if !isNotFound(err) {
+				log.Printf("CNAME lookup error for %s: %v", name, err)
+				return target, false, err
+			}
+			break
+		}
+		cname = strings.ToLower(strings.TrimSuffix(cname, "."))
+		if cname == "" || cname == name {
+			break
+		}
+		name, target = cname, cname
+	}
+	if target == "" {
+		return "", false, nil
+	}
+
+	_, err := d.resolver.LookupIP(ctx, "ip", target)
+	if err != nil && !isNotFound(err) {
+		return target, false, err
+	}
+	return target, isNotFound(err), nil
+}
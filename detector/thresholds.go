@@ -0,0 +1,90 @@
+package detector
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/user/email_spoof_detection/models"
+)
+
+// domainThreshold returns the threshold configured for the From domain of an email
+// and the entry that matched, or the default threshold and an empty entry. An exact
+// entry wins; otherwise the most specific of the wildcards ("*.example.com", which
+// also covers example.com itself) and the entry for the registrable domain is used.
+func (d *SpoofDetector) domainThreshold(email *models.Email) (int, string) {
+	domain := strings.ToLower(models.GetDomain(email.From))
+	if domain == "" || len(d.config.DomainThresholds) == 0 {
+		return d.config.Threshold, ""
+	}
+
+	registrable := baseDomain(domain)
+	bestEntry, bestLength := "", -1
+	for entry := range d.config.DomainThresholds {
+		pattern := strings.ToLower(entry)
+		if pattern == domain {
+			return d.config.DomainThresholds[entry], entry
+		}
+
+		suffix, wildcard := strings.CutPrefix(pattern, "*.")
+		if !wildcard && pattern != registrable {
+			continue
+		}
+		matches := domain == suffix || strings.HasSuffix(domain, "."+suffix)
+		// Prefer the wildcard when it and the registrable entry name the same domain
+		if matches && (len(suffix) > bestLength || len(suffix) == bestLength && wildcard) {
+			bestEntry, bestLength = entry, len(suffix)
+		}
+	}
+
+	if bestEntry == "" {
+		return d.config.Threshold, ""
+	}
+
+	return d.config.DomainThresholds[bestEntry], bestEntry
+}
+
+// applyDomainThreshold records the threshold used for an email, noting a per-domain
+// override in the findings
+func (d *SpoofDetector) applyDomainThreshold(email *models.Email, result *models.AnalysisResult) {
+	threshold, entry := d.domainThreshold(email)
+	result.Threshold = threshold
+	if entry == "" {
+		return
+	}
+
+	result.AddFinding("domain_threshold", models.SeverityInfo, 0,
+		fmt.Sprintf("Applied threshold %d configured for %s instead of the default %d", threshold, entry, d.config.Threshold))
+}
+
+// applyOffline scales the threshold of an email analyzed in offline mode by the
+// share of the rule weight that was evaluated, so that losing the network checks
+// doesn't make spoofed email harder to flag, and notes the checks skipped
+func (d *SpoofDetector) applyOffline(evaluated, total int, result *models.AnalysisResult) {
+	if !d.config.Offline {
+		return
+	}
+
+	rule, message := "offline_mode", "Offline mode"
+	if d.withoutDNS {
+		rule, message = "dns_unavailable", "DNS unavailable: the resolver doesn't answer, so only the headers and body were checked"
+	}
+	if len(result.NotEvaluated) > 0 {
+		skipped := append([]string{}, result.NotEvaluated...)
+		sort.Strings(skipped)
+		message += "; not evaluated: " + strings.Join(skipped, ", ")
+	}
+
+	if evaluated < total && total > 0 {
+		threshold := (result.Threshold*evaluated + total - 1) / total
+		if threshold < 1 {
+			threshold = 1
+		}
+		if threshold != result.Threshold {
+			message += fmt.Sprintf("; threshold scaled from %d to %d", result.Threshold, threshold)
+			result.Threshold = threshold
+		}
+	}
+
+	result.AddFinding(rule, models.SeverityInfo, 0, message)
+}
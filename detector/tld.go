@@ -0,0 +1,84 @@
+package detector
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/user/email_spoof_detection/models"
+)
+
+// DefaultRiskyTLDs lists top-level domains disproportionately used for abuse,
+// being free or cheap to register, or easily mistaken for file extensions
+var DefaultRiskyTLDs = []string{
+	"tk", "ml", "ga", "cf", "gq",
+	"top", "xyz", "icu", "buzz", "cyou", "rest", "sbs", "cfd",
+	"zip", "mov",
+	"click", "link", "work", "loan", "country", "kim",
+}
+
+// checkRiskyTLDs flags a From domain or link domains under a high-risk TLD. The
+// weight stays low as these TLDs have legitimate users too, unless the email also
+// impersonates a brand, which no brand would do from such a TLD.
+func (d *SpoofDetector) checkRiskyTLDs(email *models.Email, result *models.AnalysisResult) {
+	risky := d.config.RiskyTLDs
+	if len(risky) == 0 {
+		risky = DefaultRiskyTLDs
+	}
+
+	var flagged []string
+	fromDomain := strings.ToLower(models.GetDomain(email.From))
+	fromRisky := false
+	if tld := riskyTLDOf(fromDomain, risky); tld != "" {
+		flagged = append(flagged, "From domain "+fromDomain+" (."+tld+")")
+		fromRisky = true
+	}
+
+	seen := map[string]bool{fromDomain: true}
+	var linkDomains []string
+	for _, link := range email.Links {
+		parsed, err := url.Parse(link.URL)
+		if err != nil {
+			continue
+		}
+		host := strings.ToLower(strings.TrimSuffix(parsed.Hostname(), "."))
+		if seen[host] {
+			continue
+		}
+		seen[host] = true
+		if tld := riskyTLDOf(host, risky); tld != "" {
+			linkDomains = append(linkDomains, host+" (."+tld+")")
+		}
+	}
+	if len(linkDomains) > 0 {
+		sort.Strings(linkDomains)
+		flagged = append(flagged, "link domains "+strings.Join(linkDomains, ", "))
+	}
+
+	if len(flagged) == 0 {
+		return
+	}
+
+	message := "Email uses high-risk TLDs: " + strings.Join(flagged, "; ")
+	if brand := d.ImpersonatedBrand(email); brand != "" && fromRisky {
+		result.AddFinding("risky_tld", models.SeverityMedium, 2, message+", while impersonating "+brand)
+		return
+	}
+	result.AddFinding("risky_tld", models.SeverityLow, 1, message)
+}
+
+// riskyTLDOf returns the TLD of a domain if it is one of the risky TLDs, or an empty string
+func riskyTLDOf(domain string, risky []string) string {
+	idx := strings.LastIndexByte(domain, '.')
+	if idx < 0 {
+		return ""
+	}
+
+	tld := domain[idx+1:]
+	for _, candidate := range risky {
+		if strings.EqualFold(strings.TrimPrefix(candidate, "."), tld) {
+			return tld
+		}
+	}
+	return ""
+}
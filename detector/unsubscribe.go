@@ -0,0 +1,110 @@
+package detector
+
+import (
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/user/email_spoof_detection/models"
+)
+
+// DefaultESPDomains lists email service providers that legitimately host
+// unsubscribe endpoints on behalf of the senders using them
+var DefaultESPDomains = []string{
+	"mailchimp.com",
+	"list-manage.com",
+	"sendgrid.net",
+	"mailgun.org",
+	"amazonses.com",
+	"sparkpostmail.com",
+	"mcsv.net",
+	"mktomail.com",
+	"hubspotemail.net",
+	"constantcontact.com",
+	"createsend.com",
+	"klaviyomail.com",
+}
+
+// checkUnsubscribeTargets flags List-Unsubscribe URIs whose domain is neither
+// related to the From domain nor an email service provider, escalating when the
+// target is a bare IP address or a lookalike of the From domain
+func (d *SpoofDetector) checkUnsubscribeTargets(email *models.Email, result *models.AnalysisResult) {
+	fromDomain := strings.ToLower(models.GetDomain(email.From))
+	if fromDomain == "" || len(email.ListUnsubscribe) == 0 {
+		return
+	}
+
+	esps := d.config.ESPDomains
+	if len(esps) == 0 {
+		esps = DefaultESPDomains
+	}
+
+	// The sender's name is the first label of its registrable domain
+	fromBase := baseDomain(fromDomain)
+	senderName, _, _ := strings.Cut(fromBase, ".")
+
+	var unrelated []string
+	escalate := false
+	for _, target := range email.ListUnsubscribe {
+		host := unsubscribeHost(target)
+		if host == "" || isRelatedDomain(host, fromDomain) || matchesDomainList(host, esps) {
+			continue
+		}
+
+		switch {
+		case net.ParseIP(host) != nil:
+			unrelated = append(unrelated, target+" (bare IP address)")
+			escalate = true
+		case isBrandLookalike(host, senderName, []string{fromBase}):
+			unrelated = append(unrelated, target+" (lookalike of "+fromDomain+")")
+			escalate = true
+		default:
+			unrelated = append(unrelated, target)
+		}
+	}
+
+	if len(unrelated) == 0 {
+		return
+	}
+
+	severity, weight := models.SeverityMedium, 2
+	if escalate {
+		severity, weight = models.SeverityHigh, 4
+	}
+	result.AddFinding("unrelated_unsubscribe", severity, weight,
+		"List-Unsubscribe points away from sender domain "+fromDomain+": "+strings.Join(unrelated, ", "))
+}
+
+// unsubscribeHost returns the lower-case domain or IP address an unsubscribe URI
+// leads to: the host of an HTTP(S) URL or the domain of a mailto address
+func unsubscribeHost(target string) string {
+	parsed, err := url.Parse(target)
+	if err != nil {
+		return ""
+	}
+
+	var host string
+	switch strings.ToLower(parsed.Scheme) {
+	case "http", "https":
+		host = parsed.Hostname()
+	case "mailto":
+		address := parsed.Opaque
+		if at := strings.LastIndex(address, "@"); at >= 0 {
+			host = address[at+1:]
+		}
+		host = strings.Trim(host, "[]")
+	}
+
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+// matchesDomainList checks if a host is one of the domains or a subdomain of one
+func matchesDomainList(host string, domains []string) bool {
+	for _, domain := range domains {
+		domain = strings.ToLower(domain)
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
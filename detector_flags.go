@@ -0,0 +1,239 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/user/email_spoof_detection/detector"
+	"github.com/user/email_spoof_detection/geoip"
+	"github.com/user/email_spoof_detection/qr"
+)
+
+// detectorOptions holds the command line flags that configure the detector
+type detectorOptions struct {
+	threshold       int
+	advisoryBand    int
+	allowlist       string
+	minReceived     int
+	maxHeaderCount  int
+	maxHeaderLength int
+	maxLinkDensity  float64
+	escalation      string
+	actions         string
+	checkAuxDomains bool
+	checkTakeover   bool
+	offline         bool
+	dnsProbeHost    string
+	decodeQR        bool
+	geoIPDB         string
+	riskyCountries  string
+	asnDB           string
+	suspiciousASNs  string
+	rulesFile       string
+	enableRules     string
+	disableRules    string
+	myDomains       string
+	trustedRelays   string
+	trustedAuthServ string
+	pgpKeyring      string
+	badHashes       string
+	urgentReplyTo   bool
+	urgencyKeywords string
+	urlShorteners   string
+	riskyTLDs       string
+	blocklists      string
+	espDomains      string
+	bounceHandling  string
+}
+
+// register adds the detector flags to a subcommand's flag set
+func (o *detectorOptions) register(fs *flag.FlagSet) {
+	fs.IntVar(&o.threshold, "threshold", detector.DefaultThreshold, "Score at or above which an email is considered spoofed")
+	fs.IntVar(&o.advisoryBand, "advisory-band", detector.DefaultAdvisoryBand, "Scores this far below the threshold get the advisory verdict (0 disables)")
+	fs.StringVar(&o.allowlist, "allowlist", "", "Comma-separated sender addresses and domains that never get the advisory verdict")
+	fs.IntVar(&o.minReceived, "min-received", detector.DefaultMinReceivedHeaders, "Minimum number of Received headers expected on email from outside the -my-domains")
+	fs.IntVar(&o.maxHeaderCount, "max-header-count", detector.DefaultMaxHeaderCount, "Occurrences of a header field above which it is flagged (0 disables)")
+	fs.IntVar(&o.maxHeaderLength, "max-header-length", detector.DefaultMaxHeaderLength, "Length in bytes of a header value above which it is flagged (0 disables)")
+	fs.Float64Var(&o.maxLinkDensity, "max-link-density", detector.DefaultMaxLinkDensity, "Links and images per 100 characters of visible body text above which email from an unauthenticated sender is flagged (0 disables)")
+	fs.StringVar(&o.escalation, "escalate", "", "Severity escalation table replacing the threshold, e.g. \"critical=1,medium=2\"")
+	fs.StringVar(&o.actions, "actions", "", "Filter action per score or finding severity, e.g. \"tag=3,quarantine=5,reject=critical\"; the most severe matching action is reported")
+	fs.StringVar(&o.rulesFile, "rules-file", "", "Path to a JSON rules file customizing rule weights, severities and groups")
+	fs.StringVar(&o.enableRules, "enable", "", "Comma-separated rules to apply, leaving out the others listed by the rules subcommand")
+	fs.StringVar(&o.disableRules, "disable", "", "Comma-separated rules listed by the rules subcommand to leave out")
+	fs.StringVar(&o.myDomains, "my-domains", "", "Comma-separated internal domains; unauthenticated email claiming to be from them is critical")
+	fs.StringVar(&o.trustedRelays, "trusted-relays", "", "Comma-separated IPs, CIDR ranges and hostnames of internal relays skipped when locating the sending server, e.g. \"10.0.0.0/8,mx.example.com\"")
+	fs.StringVar(&o.trustedAuthServ, "trusted-authserv-ids", "", "Comma-separated authserv-ids stamped by your receiving servers in Authentication-Results headers, whose verdict is preferred when the headers disagree")
+	fs.StringVar(&o.badHashes, "bad-hashes", "", "Path to a list of known-malicious attachment SHA-256 hashes, one per line")
+	fs.StringVar(&o.pgpKeyring, "pgp-keyring", "", "Path to an OpenPGP keyring of trusted public keys used to verify PGP signed email")
+	fs.BoolVar(&o.urgentReplyTo, "check-urgent-reply-to", false, "Flag a free mail Reply-To, escalated when the subject is urgent")
+	fs.StringVar(&o.urgencyKeywords, "urgency-keywords", "", "Comma-separated subject keywords for -check-urgent-reply-to (default: "+strings.Join(detector.DefaultUrgencyKeywords, ", ")+")")
+	fs.StringVar(&o.urlShorteners, "url-shorteners", "", "Comma-separated URL shortener domains whose links are flagged (default: "+strings.Join(detector.DefaultURLShorteners, ", ")+")")
+	fs.StringVar(&o.riskyTLDs, "risky-tlds", "", "Comma-separated high-risk TLDs whose From and link domains are flagged (default: "+strings.Join(detector.DefaultRiskyTLDs, ", ")+")")
+	fs.StringVar(&o.espDomains, "esp-domains", "", "Comma-separated email service provider domains allowed to send for others and host unsubscribe links (default: "+strings.Join(detector.DefaultESPDomains, ", ")+")")
+	fs.StringVar(&o.bounceHandling, "bounce-handling", detector.BounceRelaxed, "How bounce messages are analyzed: \"relaxed\" ignores rules that misfire on them, \"strict\" applies every rule")
+	fs.StringVar(&o.blocklists, "domain-blocklists", "", "Comma-separated RHSBL zones to look up the From domain in, e.g. \"dbl.spamhaus.org\"")
+	fs.BoolVar(&o.offline, "offline", false, "Skip every check that needs DNS (SPF, DMARC, HELO, blocklists), scaling the threshold to the remaining rules")
+	fs.StringVar(&o.dnsProbeHost, "dns-probe-host", detector.DefaultDNSProbeHost, "Host looked up to check that the resolver answers when lookups fail; DNS checks pause for 30 seconds if it doesn't")
+	fs.BoolVar(&o.decodeQR, "decode-qr", false, "Decode QR codes in image parts and check the links they contain")
+	fs.BoolVar(&o.checkTakeover, "check-takeover", false, "Flag From and Reply-To domains whose CNAME points to a name that no longer exists (subdomain takeover candidates)")
+	fs.BoolVar(&o.checkAuxDomains, "check-aux-domains", false, "Also check SPF and DMARC of the Reply-To and Return-Path domains")
+	fs.StringVar(&o.geoIPDB, "geoip-db", "", "Path to a MaxMind GeoIP2/GeoLite2 Country or City database (.mmdb)")
+	fs.StringVar(&o.asnDB, "asn-db", "", "Path to a MaxMind GeoLite2 ASN database (.mmdb)")
+	fs.StringVar(&o.suspiciousASNs, "suspicious-asns", "", "Comma-separated hosting/bulletproof AS numbers whose senders are flagged, e.g. \"AS64500,64501\"")
+	fs.StringVar(&o.riskyCountries, "high-risk-countries", "", "Comma-separated ISO country codes whose senders are flagged, e.g. \"KP,IR\"")
+}
+
+// newDetector creates a SpoofDetector configured from the parsed flags
+func (o *detectorOptions) newDetector() (*detector.SpoofDetector, error) {
+	config := detector.DefaultConfig()
+	config.Threshold = o.threshold
+	config.AdvisoryBand = o.advisoryBand
+	config.Allowlist = splitList(o.allowlist)
+	config.MinReceivedHeaders = o.minReceived
+	config.MaxHeaderCount = o.maxHeaderCount
+	config.MaxHeaderLength = o.maxHeaderLength
+	config.MaxLinkDensity = o.maxLinkDensity
+	config.CheckAuxiliaryDomains = o.checkAuxDomains
+	config.CheckDanglingCNAME = o.checkTakeover
+	config.Offline = o.offline
+	config.DNSProbeHost = o.dnsProbeHost
+	if o.decodeQR {
+		config.QRDecoder = qr.Decoder{}
+	}
+
+	config.HighRiskCountries = splitList(o.riskyCountries)
+	config.InternalDomains = splitList(o.myDomains)
+	config.TrustedRelays = splitList(o.trustedRelays)
+	for _, relay := range config.TrustedRelays {
+		if _, _, err := net.ParseCIDR(relay); strings.Contains(relay, "/") && err != nil {
+			return nil, fmt.Errorf("invalid trusted relay %q", relay)
+		}
+	}
+	config.TrustedAuthServIDs = splitList(o.trustedAuthServ)
+	config.EnabledRules = splitList(o.enableRules)
+	config.DisabledRules = splitList(o.disableRules)
+	if err := detector.ValidateRuleNames(config.EnabledRules); err != nil {
+		return nil, fmt.Errorf("-enable: %w", err)
+	}
+	if err := detector.ValidateRuleNames(config.DisabledRules); err != nil {
+		return nil, fmt.Errorf("-disable: %w", err)
+	}
+	config.CheckUrgentReplyTo = o.urgentReplyTo
+	config.UrgencyKeywords = splitList(o.urgencyKeywords)
+	config.URLShorteners = splitList(o.urlShorteners)
+	config.RiskyTLDs = splitList(o.riskyTLDs)
+	config.DomainBlocklists = splitList(o.blocklists)
+	config.ESPDomains = splitList(o.espDomains)
+
+	switch o.bounceHandling {
+	case detector.BounceRelaxed, detector.BounceStrict:
+		config.BounceHandling = o.bounceHandling
+	default:
+		return nil, fmt.Errorf("unknown bounce handling %q", o.bounceHandling)
+	}
+
+	if o.geoIPDB != "" {
+		db, err := geoip.Open(o.geoIPDB)
+		if err != nil {
+			return nil, err
+		}
+		config.GeoIP = db
+	}
+
+	if o.asnDB != "" {
+		db, err := geoip.Open(o.asnDB)
+		if err != nil {
+			return nil, err
+		}
+		config.ASN = db
+	}
+
+	for _, item := range splitList(o.suspiciousASNs) {
+		asn, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(item), "AS"), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid AS number %q", item)
+		}
+		config.SuspiciousASNs = append(config.SuspiciousASNs, uint(asn))
+	}
+
+	if o.pgpKeyring != "" {
+		keyring, err := detector.LoadPGPKeyring(o.pgpKeyring)
+		if err != nil {
+			return nil, err
+		}
+		config.PGPKeyring = keyring
+	}
+
+	if o.badHashes != "" {
+		hashes, err := detector.LoadHashList(o.badHashes)
+		if err != nil {
+			return nil, err
+		}
+		config.MaliciousHashes = hashes
+	}
+
+	if o.rulesFile != "" {
+		rulesFile, err := detector.LoadRulesFile(o.rulesFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RulesFile = rulesFile
+		config.DomainThresholds = rulesFile.Thresholds
+
+		config.BrandDomains = mergeBrandLists(config.BrandDomains, rulesFile.Brands)
+		config.BrandKeywords = mergeBrandLists(config.BrandKeywords, rulesFile.BrandKeywords)
+		config.CommonWordBrands = append(append([]string{}, config.CommonWordBrands...), rulesFile.CommonWordBrands...)
+		config.BrandCountries = mergeBrandLists(nil, rulesFile.BrandCountries)
+		config.ExpectedLanguages = mergeBrandLists(nil, rulesFile.ExpectedLanguages)
+		config.AllowlistScopes = rulesFile.Allowlist
+	}
+
+	if o.actions != "" {
+		bands, err := detector.ParseActionBands(o.actions)
+		if err != nil {
+			return nil, err
+		}
+		config.Actions = bands
+	}
+
+	if o.escalation != "" {
+		table, err := detector.ParseEscalationTable(o.escalation)
+		if err != nil {
+			return nil, err
+		}
+		config.Escalation = table
+	}
+
+	return detector.NewSpoofDetectorWithConfig(config), nil
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// mergeBrandLists returns the per-brand lists of base with those of overrides added
+// or replaced, keyed by lower-case brand name
+func mergeBrandLists(base, overrides map[string][]string) map[string][]string {
+	if len(overrides) == 0 {
+		return base
+	}
+
+	merged := make(map[string][]string, len(base)+len(overrides))
+	for brand, values := range base {
+		merged[brand] = values
+	}
+	for brand, values := range overrides {
+		merged[strings.ToLower(brand)] = values
+	}
+	return merged
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/user/email_spoof_detection/models"
+)
+
+// dumpHeaders are the header fields included in an email dump, besides those
+// already parsed into dedicated fields
+var dumpHeaders = []string{
+	"Date",
+	"Sender",
+	"Received",
+	"Received-SPF",
+	"Authentication-Results",
+	"DKIM-Signature",
+	"X-Originating-IP",
+	"Content-Type",
+}
+
+// emailDump is the JSON representation of a parsed email printed by -dump
+type emailDump struct {
+	From          string               `json:"from,omitempty"`
+	FromGroup     string               `json:"from_group,omitempty"`
+	ReplyTo       string               `json:"reply_to,omitempty"`
+	ReturnPath    string               `json:"return_path,omitempty"`
+	Report        bool                 `json:"delivery_report,omitempty"`
+	To            []string             `json:"to,omitempty"`
+	Cc            []string             `json:"cc,omitempty"`
+	MessageID     string               `json:"message_id,omitempty"`
+	InReplyTo     string               `json:"in_reply_to,omitempty"`
+	References    []string             `json:"references,omitempty"`
+	Unsubscribe   []string             `json:"list_unsubscribe,omitempty"`
+	Resent        []resentDump         `json:"resent,omitempty"`
+	Subject       string               `json:"subject"`
+	Mailer        string               `json:"mailer,omitempty"`
+	Boundary      string               `json:"boundary,omitempty"`
+	Headers       map[string][]string  `json:"headers"`
+	HasBody       bool                 `json:"has_body"`
+	BodyLength    int                  `json:"body_length"`
+	Parts         []partDump           `json:"parts,omitempty"`
+	Links         []models.Link        `json:"links,omitempty"`
+	BodyAddresses []models.BodyAddress `json:"body_addresses,omitempty"`
+	MIMEAnomalies []string             `json:"mime_anomalies,omitempty"`
+	DuplicateMIME []string             `json:"duplicate_mime_headers,omitempty"`
+	CharsetErrors []string             `json:"charset_errors,omitempty"`
+	PGP           string               `json:"pgp,omitempty"`
+	Encryption    string               `json:"encryption,omitempty"`
+	ParseError    string               `json:"parse_error,omitempty"`
+	LeadingJunk   string               `json:"leading_junk,omitempty"`
+}
+
+// resentDump describes a block of Resent-* headers in an email dump
+type resentDump struct {
+	From      string   `json:"from,omitempty"`
+	Sender    string   `json:"sender,omitempty"`
+	To        []string `json:"to,omitempty"`
+	Date      string   `json:"date,omitempty"`
+	MessageID string   `json:"message_id,omitempty"`
+}
+
+// partDump describes a single MIME part in an email dump
+type partDump struct {
+	ContentType string `json:"content_type"`
+	Charset     string `json:"charset,omitempty"`
+	Disposition string `json:"disposition,omitempty"`
+	Filename    string `json:"filename,omitempty"`
+	Size        int    `json:"size"`
+	SHA256      string `json:"sha256"`
+	Attachment  bool   `json:"attachment"`
+}
+
+// newEmailDump collects what the parser extracted from an email
+func newEmailDump(email *models.Email) emailDump {
+	dump := emailDump{
+		FromGroup:     email.FromGroup,
+		ReturnPath:    email.ReturnPath,
+		Report:        email.DeliveryReport,
+		MessageID:     email.MessageID,
+		InReplyTo:     email.InReplyTo,
+		References:    email.References,
+		Unsubscribe:   email.ListUnsubscribe,
+		Subject:       email.Subject,
+		Mailer:        email.Mailer,
+		Boundary:      email.Boundary,
+		Headers:       make(map[string][]string),
+		HasBody:       email.HasBody,
+		BodyLength:    len(email.Body),
+		Links:         email.Links,
+		BodyAddresses: email.BodyAddresses,
+		MIMEAnomalies: email.MIMEAnomalies,
+		DuplicateMIME: email.DuplicateMIME,
+		CharsetErrors: email.CharsetErrors,
+		Encryption:    email.Encryption,
+		ParseError:    email.ParseError,
+		LeadingJunk:   email.LeadingJunk,
+	}
+
+	if email.From != nil {
+		dump.From = email.From.String()
+	}
+	if email.ReplyTo != nil {
+		dump.ReplyTo = email.ReplyTo.String()
+	}
+	if email.NullReturnPath {
+		dump.ReturnPath = "<>"
+	}
+	for _, address := range email.To {
+		dump.To = append(dump.To, address.String())
+	}
+	for _, address := range email.Cc {
+		dump.Cc = append(dump.Cc, address.String())
+	}
+	if email.PGP != nil {
+		dump.PGP = email.PGP.Type
+	}
+	for _, block := range email.Resent {
+		resent := resentDump{Date: block.Date, MessageID: block.MessageID}
+		if block.From != nil {
+			resent.From = block.From.String()
+		}
+		if block.Sender != nil {
+			resent.Sender = block.Sender.String()
+		}
+		for _, address := range block.To {
+			resent.To = append(resent.To, address.String())
+		}
+		dump.Resent = append(dump.Resent, resent)
+	}
+
+	for _, name := range dumpHeaders {
+		if values := email.GetAllHeaderValues(name); len(values) > 0 {
+			dump.Headers[name] = values
+		}
+	}
+
+	for _, part := range email.Parts {
+		dump.Parts = append(dump.Parts, partDump{
+			ContentType: part.ContentType,
+			Charset:     part.Charset,
+			Disposition: part.Disposition,
+			Filename:    part.Filename,
+			Size:        len(part.Body),
+			SHA256:      part.SHA256,
+			Attachment:  part.IsAttachment(),
+		})
+	}
+
+	return dump
+}
+
+// printDump prints the parsed email as indented JSON
+func printDump(email *models.Email) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(newEmailDump(email))
+}
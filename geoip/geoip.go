@@ -0,0 +1,54 @@
+package geoip
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+
+	"github.com/user/email_spoof_detection/models"
+)
+
+// DB is an offline MaxMind database (GeoIP2/GeoLite2 Country, City or ASN)
+type DB struct {
+	reader *maxminddb.Reader
+}
+
+// record holds the fields read from the database; country and ASN databases
+// each fill in only their own fields
+type record struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	AutonomousSystemNumber       uint   `maxminddb:"autonomous_system_number"`
+	AutonomousSystemOrganization string `maxminddb:"autonomous_system_organization"`
+}
+
+// Open opens a MaxMind database file (.mmdb)
+func Open(path string) (*DB, error) {
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening GeoIP database %s: %w", path, err)
+	}
+
+	return &DB{reader: reader}, nil
+}
+
+// Lookup returns the country and autonomous system of an IP address
+func (db *DB) Lookup(ip net.IP) (models.IPInfo, error) {
+	var rec record
+	if err := db.reader.Lookup(ip, &rec); err != nil {
+		return models.IPInfo{}, err
+	}
+
+	return models.IPInfo{
+		Country:      rec.Country.ISOCode,
+		ASN:          rec.AutonomousSystemNumber,
+		Organization: rec.AutonomousSystemOrganization,
+	}, nil
+}
+
+// Close releases the database
+func (db *DB) Close() error {
+	return db.reader.Close()
+}
@@ -0,0 +1,181 @@
+package history
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite" // Registers the "sqlite" database/sql driver
+
+	"github.com/user/email_spoof_detection/models"
+)
+
+// schema creates the scan history table on first use
+const schema = `
+CREATE TABLE IF NOT EXISTS scans (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	scanned_at  TEXT NOT NULL,
+	path        TEXT NOT NULL,
+	message_id  TEXT NOT NULL,
+	from_domain TEXT NOT NULL,
+	spoofed     INTEGER NOT NULL,
+	score       INTEGER NOT NULL,
+	findings    TEXT NOT NULL,
+	ruleset_version TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS scans_from_domain ON scans (from_domain);
+CREATE INDEX IF NOT EXISTS scans_scanned_at ON scans (scanned_at);
+`
+
+// timeLayout stores timestamps as UTC text that sorts chronologically
+const timeLayout = "2006-01-02T15:04:05Z"
+
+// DB is a SQLite database of scan results
+type DB struct {
+	db *sql.DB
+}
+
+// Entry is a single recorded scan result
+type Entry struct {
+	ScannedAt  time.Time        `json:"scanned_at"`
+	Path       string           `json:"path"`
+	MessageID  string           `json:"message_id,omitempty"`
+	FromDomain string           `json:"from_domain"`
+	Spoofed    bool             `json:"spoofed"`
+	Score      int              `json:"score"`
+	Findings   []models.Finding `json:"findings"`
+	Ruleset    string           `json:"ruleset_version,omitempty"` // Empty for scans recorded before versioning
+}
+
+// Filter restricts the entries returned by Query; zero fields match everything.
+// Since is inclusive and Until exclusive.
+type Filter struct {
+	Domain string
+	Since  time.Time
+	Until  time.Time
+}
+
+// Open opens or creates a scan history database
+func Open(path string) (*DB, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("opening history database %s: %w", path, err)
+	}
+
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("initializing history database %s: %w", path, err)
+	}
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("upgrading history database %s: %w", path, err)
+	}
+
+	return &DB{db: db}, nil
+}
+
+// migrate adds the columns introduced after a database was created
+func migrate(db *sql.DB) error {
+	rows, err := db.Query("SELECT name FROM pragma_table_info('scans')")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	columns := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		columns[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if !columns["ruleset_version"] {
+		_, err = db.Exec("ALTER TABLE scans ADD COLUMN ruleset_version TEXT NOT NULL DEFAULT ''")
+	}
+	return err
+}
+
+// Record stores the analysis result of an email
+func (h *DB) Record(path string, email *models.Email, result *models.AnalysisResult) error {
+	findings, err := json.Marshal(result.Findings)
+	if err != nil {
+		return err
+	}
+
+	_, err = h.db.Exec(
+		`INSERT INTO scans (scanned_at, path, message_id, from_domain, spoofed, score, findings, ruleset_version)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		time.Now().UTC().Format(timeLayout),
+		path,
+		email.MessageID,
+		strings.ToLower(models.GetDomain(email.From)),
+		result.IsSpoofed,
+		result.Score,
+		string(findings),
+		result.RulesetVersion,
+	)
+	if err != nil {
+		return fmt.Errorf("recording scan of %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// Query returns the recorded entries matching the filter, oldest first
+func (h *DB) Query(filter Filter) ([]Entry, error) {
+	query := "SELECT scanned_at, path, message_id, from_domain, spoofed, score, findings, ruleset_version FROM scans WHERE 1 = 1"
+	var args []interface{}
+
+	if filter.Domain != "" {
+		query += " AND from_domain = ?"
+		args = append(args, strings.ToLower(filter.Domain))
+	}
+	if !filter.Since.IsZero() {
+		query += " AND scanned_at >= ?"
+		args = append(args, filter.Since.UTC().Format(timeLayout))
+	}
+	if !filter.Until.IsZero() {
+		query += " AND scanned_at < ?"
+		args = append(args, filter.Until.UTC().Format(timeLayout))
+	}
+	query += " ORDER BY scanned_at, id"
+
+	rows, err := h.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying history: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []Entry
+	for rows.Next() {
+		var entry Entry
+		var scannedAt, findings string
+		if err := rows.Scan(&scannedAt, &entry.Path, &entry.MessageID, &entry.FromDomain,
+			&entry.Spoofed, &entry.Score, &findings, &entry.Ruleset); err != nil {
+			return nil, fmt.Errorf("reading history: %w", err)
+		}
+
+		if entry.ScannedAt, err = time.Parse(timeLayout, scannedAt); err != nil {
+			return nil, fmt.Errorf("reading history: %w", err)
+		}
+		if err := json.Unmarshal([]byte(findings), &entry.Findings); err != nil {
+			return nil, fmt.Errorf("reading history: %w", err)
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// Close closes the database
+func (h *DB) Close() error {
+	return h.db.Close()
+}
@@ -0,0 +1,231 @@
+// Package imap implements the small read-only subset of an IMAP4rev1 client
+// (RFC 3501) needed to fetch messages for analysis: logging in, examining a
+// folder, searching it and fetching whole messages without marking them as seen.
+package imap
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxLiteralSize limits the size of a message the server may send
+const maxLiteralSize = 64 << 20
+
+// dialTimeout limits how long connecting to the server may take
+const dialTimeout = 30 * time.Second
+
+// literalPattern matches the announcement of a literal ending a response line
+var literalPattern = regexp.MustCompile(`\{(\d+)\}$`)
+
+// Client is a connection to an IMAP server
+type Client struct {
+	conn net.Conn
+	r    *bufio.Reader
+	tag  int
+}
+
+// response is a single server response line, with the literals it contains
+// replaced by their announcements ({N}) and collected in order
+type response struct {
+	text     string
+	literals [][]byte
+}
+
+// Dial connects to an IMAP server at host:port, using TLS unless plaintext is
+// set, and reads its greeting
+func Dial(addr string, plaintext bool) (*Client, error) {
+	dialer := &net.Dialer{Timeout: dialTimeout}
+
+	var conn net.Conn
+	var err error
+	if plaintext {
+		conn, err = dialer.Dial("tcp", addr)
+	} else {
+		host, _, _ := net.SplitHostPort(addr)
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: host})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("connecting to IMAP server %s: %w", addr, err)
+	}
+
+	c := &Client{conn: conn, r: bufio.NewReader(conn)}
+	greeting, err := c.readResponse()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("reading IMAP greeting: %w", err)
+	}
+	if !strings.HasPrefix(greeting.text, "* OK") && !strings.HasPrefix(greeting.text, "* PREAUTH") {
+		conn.Close()
+		return nil, fmt.Errorf("IMAP server refused the connection: %s", greeting.text)
+	}
+
+	return c, nil
+}
+
+// Login authenticates with a user name and password. Neither appears in the
+// errors returned.
+func (c *Client) Login(user, password string) error {
+	quotedUser, err := quote(user)
+	if err != nil {
+		return fmt.Errorf("user name: %w", err)
+	}
+	quotedPassword, err := quote(password)
+	if err != nil {
+		return fmt.Errorf("password: %w", err)
+	}
+
+	if _, err := c.command("LOGIN " + quotedUser + " " + quotedPassword); err != nil {
+		return fmt.Errorf("IMAP login failed: %w", err)
+	}
+	return nil
+}
+
+// Examine opens a folder read-only
+func (c *Client) Examine(folder string) error {
+	quoted, err := quote(folder)
+	if err != nil {
+		return fmt.Errorf("folder: %w", err)
+	}
+
+	if _, err := c.command("EXAMINE " + quoted); err != nil {
+		return fmt.Errorf("opening folder %s: %w", folder, err)
+	}
+	return nil
+}
+
+// Search returns the UIDs of the messages in the open folder matching the
+// criteria, such as "UNSEEN" or "SINCE", SearchDate(t), in ascending order.
+// All messages match when no criteria are given.
+func (c *Client) Search(criteria ...string) ([]uint32, error) {
+	if len(criteria) == 0 {
+		criteria = []string{"ALL"}
+	}
+
+	responses, err := c.command("UID SEARCH " + strings.Join(criteria, " "))
+	if err != nil {
+		return nil, fmt.Errorf("searching: %w", err)
+	}
+
+	var uids []uint32
+	for _, resp := range responses {
+		fields := strings.Fields(resp.text)
+		if len(fields) < 2 || fields[0] != "*" || !strings.EqualFold(fields[1], "SEARCH") {
+			continue
+		}
+		for _, field := range fields[2:] {
+			uid, err := strconv.ParseUint(field, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("searching: invalid UID %q", field)
+			}
+			uids = append(uids, uint32(uid))
+		}
+	}
+
+	return uids, nil
+}
+
+// SearchDate formats a date for the SINCE and BEFORE search criteria
+func SearchDate(t time.Time) string {
+	return t.Format("2-Jan-2006")
+}
+
+// Fetch returns the full raw content of a message by UID, without setting its \Seen flag
+func (c *Client) Fetch(uid uint32) ([]byte, error) {
+	responses, err := c.command(fmt.Sprintf("UID FETCH %d (BODY.PEEK[])", uid))
+	if err != nil {
+		return nil, fmt.Errorf("fetching message %d: %w", uid, err)
+	}
+
+	for _, resp := range responses {
+		if strings.Contains(strings.ToUpper(resp.text), " FETCH ") && len(resp.literals) > 0 {
+			return resp.literals[0], nil
+		}
+	}
+
+	return nil, fmt.Errorf("fetching message %d: no such message", uid)
+}
+
+// Logout ends the session and closes the connection
+func (c *Client) Logout() error {
+	_, err := c.command("LOGOUT")
+	if closeErr := c.conn.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// command sends a command and returns the untagged responses that preceded its
+// completion, or an error with the server's text if it didn't complete with OK
+func (c *Client) command(command string) ([]response, error) {
+	c.tag++
+	tag := "a" + strconv.Itoa(c.tag)
+	if _, err := io.WriteString(c.conn, tag+" "+command+"\r\n"); err != nil {
+		return nil, err
+	}
+
+	var untagged []response
+	for {
+		resp, err := c.readResponse()
+		if err != nil {
+			return nil, err
+		}
+
+		status, found := strings.CutPrefix(resp.text, tag+" ")
+		if !found {
+			untagged = append(untagged, resp)
+			continue
+		}
+
+		if strings.HasPrefix(strings.ToUpper(status), "OK") {
+			return untagged, nil
+		}
+		return nil, errors.New(status)
+	}
+}
+
+// readResponse reads a response line along with any literals it contains
+func (c *Client) readResponse() (response, error) {
+	var resp response
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			return resp, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		resp.text += line
+
+		match := literalPattern.FindStringSubmatch(line)
+		if match == nil {
+			return resp, nil
+		}
+
+		size, err := strconv.Atoi(match[1])
+		if err != nil || size > maxLiteralSize {
+			return resp, fmt.Errorf("literal of %s bytes exceeds the limit of %d", match[1], maxLiteralSize)
+		}
+		literal := make([]byte, size)
+		if _, err := io.ReadFull(c.r, literal); err != nil {
+			return resp, err
+		}
+		resp.literals = append(resp.literals, literal)
+	}
+}
+
+// quote encodes a string as an IMAP quoted string
+func quote(value string) (string, error) {
+	if strings.ContainsAny(value, "\r\n\x00") {
+		return "", errors.New("contains a line break or NUL")
+	}
+
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `"`, `\"`)
+	return `"` + value + `"`, nil
+}
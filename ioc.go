@@ -0,0 +1,107 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/user/email_spoof_detection/detector"
+	"github.com/user/email_spoof_detection/models"
+)
+
+// Indicator types of an IOC report, named after the matching STIX 2.1 objects
+const (
+	iocIPv4      = "ipv4-addr"
+	iocIPv6      = "ipv6-addr"
+	iocDomain    = "domain-name"
+	iocEmail     = "email-addr"
+	iocURL       = "url"
+	iocFileHash  = "file:hashes.'SHA-256'"
+	iocBrand     = "impersonated-brand"
+	iocRuleMatch = "rule"
+)
+
+// iocReport is the indicator record printed by -format ioc for a suspicious email
+type iocReport struct {
+	Source      string         `json:"source"`
+	MessageID   string         `json:"message_id,omitempty"`
+	Verdict     models.Verdict `json:"verdict"`
+	Score       int            `json:"score"`
+	Ruleset     string         `json:"ruleset_version"`
+	Fingerprint string         `json:"fingerprint"`
+	Indicators  []indicator    `json:"indicators"`
+}
+
+// indicator is a single artifact of a suspicious email
+type indicator struct {
+	Type    string `json:"type"`
+	Value   string `json:"value"`
+	Context string `json:"context,omitempty"` // Where the artifact was found, e.g. "Reply-To"
+}
+
+// newIOCReport collects the artifacts of an analyzed email that are worth sharing
+// as indicators: the sending server, sender domains and addresses, impersonated
+// brand, links, attachment hashes and the rules that fired
+func newIOCReport(name string, email *models.Email, result *models.AnalysisResult, brand string) iocReport {
+	report := iocReport{
+		Source:      name,
+		MessageID:   email.MessageID,
+		Verdict:     result.Verdict,
+		Score:       result.Score,
+		Ruleset:     result.RulesetVersion,
+		Fingerprint: result.Fingerprint,
+	}
+
+	seen := map[string]bool{}
+	add := func(kind, value, context string) {
+		// Free mail providers are shared by everyone and make useless indicators
+		if kind == iocDomain && detector.IsFreeMailDomain(value) {
+			return
+		}
+		if value == "" || seen[kind+"\x00"+value] {
+			return
+		}
+		seen[kind+"\x00"+value] = true
+		report.Indicators = append(report.Indicators, indicator{Type: kind, Value: value, Context: context})
+	}
+
+	if result.Origin != nil {
+		kind := iocIPv4
+		if strings.Contains(result.Origin.IP, ":") {
+			kind = iocIPv6
+		}
+		add(kind, result.Origin.IP, "sending server")
+	}
+
+	if email.From != nil {
+		add(iocEmail, strings.ToLower(email.From.Address), "From")
+		add(iocDomain, strings.ToLower(models.GetDomain(email.From)), "From")
+	}
+	if email.ReplyTo != nil {
+		add(iocEmail, strings.ToLower(email.ReplyTo.Address), "Reply-To")
+		add(iocDomain, strings.ToLower(models.GetDomain(email.ReplyTo)), "Reply-To")
+	}
+	if !email.NullReturnPath && email.ReturnPath != "" {
+		add(iocEmail, strings.ToLower(email.ReturnPath), "Return-Path")
+	}
+
+	add(iocBrand, brand, "")
+
+	for _, link := range email.Links {
+		add(iocURL, link.URL, "body")
+	}
+
+	for _, link := range result.QRLinks {
+		add(iocURL, link, "QR code")
+	}
+
+	for _, attachment := range email.Attachments() {
+		add(iocFileHash, attachment.SHA256, attachment.Filename)
+	}
+
+	for _, finding := range result.Findings {
+		if finding.Weight > 0 {
+			add(iocRuleMatch, finding.Rule, finding.Severity.String())
+		}
+	}
+
+	return report
+}
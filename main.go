@@ -1,89 +1,95 @@
 package main
 
 import (
-	"flag"
 	"fmt"
 	"log"
 	"os"
-	"path/filepath"
-
-	"github.com/user/email_spoof_detection/detector"
-	"github.com/user/email_spoof_detection/utils"
+	"strings"
 )
 
-func main() {
-	// Define command line flags
-	filePath := flag.String("file", "", "Path to a single email file to analyze")
-	dirPath := flag.String("dir", "", "Path to a directory of email files to analyze")
-	verbose := flag.Bool("verbose", false, "Enable verbose output")
-	flag.Parse()
+// command is a single CLI subcommand
+type command struct {
+	Name        string
+	Description string
+	Run         func(args []string) error
+}
 
-	// Configure logging
-	if *verbose {
-		log.SetFlags(log.LstdFlags | log.Lshortfile)
-	} else {
-		log.SetFlags(0)
+// commands returns all available subcommands in the order they are listed in the usage text
+func commands() []command {
+	return []command{
+		{
+			Name:        "analyze",
+			Description: "Analyze a single email file, a directory of files, or stdin",
+			Run:         runAnalyze,
+		},
+		{
+			Name:        "serve",
+			Description: "Run an HTTP server that analyzes posted emails",
+			Run:         runServe,
+		},
+		{
+			Name:        "report",
+			Description: "Print aggregate statistics for a directory of emails",
+			Run:         runReport,
+		},
+		{
+			Name:        "imap",
+			Description: "Analyze the messages of an IMAP mailbox folder, read-only",
+			Run:         runIMAP,
+		},
+		{
+			Name:        "query",
+			Description: "Query the verdicts recorded in a scan history database",
+			Run:         runQuery,
+		},
+		{
+			Name:        "rules",
+			Description: "List or export the detection rules",
+			Run:         runRules,
+		},
 	}
+}
 
-	// Validate input
-	if *filePath == "" && *dirPath == "" {
-		log.Fatal("Error: You must specify either -file or -dir flag")
-	}
+func main() {
+	log.SetFlags(0)
 
-	// Process a single file
-	if *filePath != "" {
-		processEmailFile(*filePath, *verbose)
-		return
+	args := os.Args[1:]
+	if len(args) == 0 {
+		usage()
+		os.Exit(2)
 	}
 
-	// Process a directory of files
-	if *dirPath != "" {
-		files, err := os.ReadDir(*dirPath)
-		if err != nil {
-			log.Fatalf("Error reading directory: %v", err)
+	// Preserve the original flat interface: flags without a subcommand mean "analyze"
+	name := args[0]
+	if strings.HasPrefix(name, "-") {
+		if name == "-h" || name == "-help" || name == "--help" {
+			usage()
+			return
 		}
+		name = "analyze"
+	} else {
+		args = args[1:]
+	}
 
-		for _, file := range files {
-			if !file.IsDir() {
-				fullPath := filepath.Join(*dirPath, file.Name())
-				processEmailFile(fullPath, *verbose)
+	for _, cmd := range commands() {
+		if cmd.Name == name {
+			if err := cmd.Run(args); err != nil {
+				log.Fatalf("Error: %v", err)
 			}
+			return
 		}
 	}
-}
 
-func processEmailFile(filePath string, verbose bool) {
-	fmt.Printf("Analyzing email: %s\n", filePath)
-
-	// Read the email file
-	emailData, err := os.ReadFile(filePath)
-	if err != nil {
-		log.Printf("Error reading file %s: %v\n", filePath, err)
-		return
-	}
-
-	// Parse the email
-	email, err := utils.ParseEmail(emailData)
-	if err != nil {
-		log.Printf("Error parsing email %s: %v\n", filePath, err)
-		return
-	}
-
-	// Create a detector
-	spfDetector := detector.NewSpoofDetector()
-
-	// Analyze the email
-	results := spfDetector.Analyze(email)
+	log.Printf("Error: unknown command %q\n", name)
+	usage()
+	os.Exit(2)
+}
 
-	// Print results
-	if results.IsSpoofed {
-		fmt.Printf("⚠️  SPOOFED EMAIL DETECTED: %s\n", filePath)
-		for _, reason := range results.Reasons {
-			fmt.Printf("  - %s\n", reason)
-		}
-	} else if verbose {
-		fmt.Printf("✓ Email appears legitimate: %s\n", filePath)
+// usage prints the list of subcommands
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags]\n\nCommands:\n", os.Args[0])
+	for _, cmd := range commands() {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.Name, cmd.Description)
 	}
-
-	fmt.Println()
+	fmt.Fprintf(os.Stderr, "\nRun '%s <command> -h' for the flags of a command.\n", os.Args[0])
 }
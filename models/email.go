@@ -1,46 +1,403 @@
 package models
 
 import (
+	"net"
 	"net/mail"
+	"net/textproto"
+	"sort"
 	"strings"
+	"time"
 )
 
 // Email represents a parsed email with relevant header information
 type Email struct {
-	From       *mail.Address
-	ReplyTo    *mail.Address
-	ReturnPath string
-	MessageID  string
-	Subject    string
-	Body       string
-	Headers    map[string][]string
-	RawContent []byte
+	From            *mail.Address
+	FromGroup       string          // Group name when From uses group syntax ("Team: a@x.com, b@y.com;")
+	FromMembers     []*mail.Address // All addresses of a group-syntax From; From is the first of them
+	ReplyTo         *mail.Address
+	ReturnPath      string
+	NullReturnPath  bool // Set for the null sender "<>" used by bounce messages
+	DeliveryReport  bool // Set for multipart/report delivery status notifications
+	To              []*mail.Address
+	Cc              []*mail.Address
+	MessageID       string
+	InReplyTo       string
+	References      []string
+	ListUnsubscribe []string      // URIs of the List-Unsubscribe header, without angle brackets
+	Resent          []Resent      // Resent-* blocks, most recent first
+	Received        []ReceivedHop // Parsed Received headers, most recent first
+	Subject         string
+	Mailer          string // Sending software from the X-Mailer or User-Agent header
+	Boundary        string // Boundary of a multipart message body
+	Body            string
+	HasBody         bool     // False for headers-only messages; body-based checks don't apply
+	Parts           []Part   // Leaf MIME parts, or the single body part of a non-multipart email
+	MIMEAnomalies   []string // Structural problems found while parsing the MIME tree
+	DuplicateMIME   []string // Content headers repeated within a MIME entity, e.g. "part 2: Content-Type (2 times, conflicting)"
+	CharsetErrors   []string // Text parts left undecoded because their charset is unknown or invalid
+	PGP             *PGPSignature
+	Encryption      string        // One of the Encryption* types when the body is encrypted
+	Links           []Link        // Links found in the text and HTML parts
+	BodyAddresses   []BodyAddress // Email addresses mentioned in the text and HTML parts
+	Headers         map[string][]string
+	RawContent      []byte
+	ParseError      string // Set when the message was malformed and only partially parsed
+	LeadingJunk     string // What was stripped before the headers, e.g. "a UTF-8 BOM and blank lines"
+}
+
+// Part is a single leaf part of a MIME message
+type Part struct {
+	ContentType string // Lower-case media type such as "text/html"
+	Charset     string
+	Disposition string // "inline", "attachment" or empty
+	Filename    string
+	Headers     map[string][]string
+	Body        []byte // Content with the transfer encoding decoded; displayed text is converted to UTF-8
+	SHA256      string // Hex SHA-256 of Body
+}
+
+// Link is a URL found in the email body
+type Link struct {
+	URL  string `json:"url"`
+	Text string `json:"text,omitempty"` // Visible text of an HTML anchor, empty for links in plain text
+}
+
+// Resent is one block of Resent-* headers added when a message is re-sent as is
+type Resent struct {
+	From      *mail.Address
+	Sender    *mail.Address
+	To        []*mail.Address
+	Date      string
+	MessageID string
+}
+
+// ReceivedHop holds the fields of a Received header, each recording one hop of
+// the path an email took
+type ReceivedHop struct {
+	FromHELO string    // HELO/EHLO name the client announced, the first word of the "from" clause
+	FromHost string    // Reverse DNS name the receiving server recorded for the client, e.g. "unknown"
+	FromIP   net.IP    // First bracketed IP address of the "from" clause
+	ByHost   string    // Receiving server, the first word of the "by" clause
+	Protocol string    // First word of the "with" clause, e.g. "ESMTPS"
+	For      string    // Envelope recipient of the "for" clause, without angle brackets
+	Time     time.Time // Timestamp after the last semicolon; zero when missing or unparsable
+}
+
+// BodyAddress is an email address mentioned in the email body
+type BodyAddress struct {
+	Address string `json:"address"`
+	Domain  string `json:"domain"`
+}
+
+// PGP signature types
+const (
+	PGPMIME   = "pgp-mime" // multipart/signed with an application/pgp-signature part
+	PGPInline = "inline"   // Cleartext signed text body
+)
+
+// Encryption types of an encrypted body
+const (
+	EncryptionPGPMIME   = "pgp-mime"   // multipart/encrypted with application/pgp-encrypted
+	EncryptionPGPInline = "pgp-inline" // Text body holding a PGP MESSAGE block
+	EncryptionSMIME     = "smime"      // application/pkcs7-mime enveloped data
+)
+
+// PGPSignature holds a PGP signature found in the email and the content it covers
+type PGPSignature struct {
+	Type      string
+	Signed    []byte // Signed content; for inline signatures, the whole cleartext signed block
+	Signature []byte // Armored detached signature, empty for inline signatures
+}
+
+// IsAttachment checks if the part is an attachment rather than displayed content
+func (p *Part) IsAttachment() bool {
+	return p.Disposition == "attachment" || p.Filename != ""
+}
+
+// Finding is a single triggered detection rule
+type Finding struct {
+	Rule     string   `json:"rule"`
+	Severity Severity `json:"severity"`
+	Message  string   `json:"message"`
+	Weight   int      `json:"weight"`
+}
+
+// AttachmentInfo identifies an attachment of an analyzed email
+type AttachmentInfo struct {
+	Filename    string `json:"filename,omitempty"`
+	ContentType string `json:"content_type"`
+	Size        int    `json:"size"`
+	SHA256      string `json:"sha256"`
+}
+
+// IPInfo describes the location and network of an IP address
+type IPInfo struct {
+	Country      string `json:"country,omitempty"` // ISO 3166-1 alpha-2 code
+	ASN          uint   `json:"asn,omitempty"`
+	Organization string `json:"organization,omitempty"`
+}
+
+// OriginInfo describes the server that handed the email to the receiving infrastructure
+type OriginInfo struct {
+	IP  string `json:"ip"`
+	Hop int    `json:"hop"` // Received header recording the origin, counting from the most recent (1)
+	IPInfo
+}
+
+// AuthenticationResults summarizes the SPF, DKIM and DMARC evaluation of the From domain
+type AuthenticationResults struct {
+	SPF   string `json:"spf,omitempty"`   // RFC 7208 result, empty when the sending IP is unknown
+	DKIM  string `json:"dkim,omitempty"`  // "none", "misaligned", "fail" or "unverified"
+	DMARC string `json:"dmarc,omitempty"` // Published policy, "missing" or "error"
+	PGP   string `json:"pgp,omitempty"`   // Set only for PGP signed email
+	BIMI  string `json:"bimi,omitempty"`  // Set only for brand domains: "present", "absent", "invalid", "not-enforced" or "error"
+
+	DMARCSource string `json:"dmarc_source,omitempty"` // Tag and domain of the applied policy, e.g. "sp= of example.com, no record at _dmarc.mail.example.com"
+	SPFUsedPTR  bool   `json:"spf_used_ptr,omitempty"` // SPF evaluation relied on the deprecated ptr mechanism
+
+	SPFRedirects []string `json:"spf_redirects,omitempty"` // Domains whose SPF records were evaluated through redirect= modifiers, in order
+}
+
+// Verdict is the overall classification of an analyzed email
+type Verdict string
+
+// Verdicts
+const (
+	VerdictLegitimate Verdict = "legitimate"
+	VerdictAdvisory   Verdict = "advisory" // Score just below the threshold; worth a manual look
+	VerdictSpoofed    Verdict = "spoofed"
+)
+
+// Action is what a mail filter should do with an analyzed email
+type Action string
+
+// Actions, from least to most severe
+const (
+	ActionAccept     Action = "accept"
+	ActionTag        Action = "tag" // Deliver with a header marking the verdict
+	ActionQuarantine Action = "quarantine"
+	ActionReject     Action = "reject"
+)
+
+// Rank orders actions by severity, returning -1 for an unknown action
+func (a Action) Rank() int {
+	for i, action := range []Action{ActionAccept, ActionTag, ActionQuarantine, ActionReject} {
+		if a == action {
+			return i
+		}
+	}
+	return -1
 }
 
 // AnalysisResult contains the results of spoofing detection analysis
 type AnalysisResult struct {
-	IsSpoofed bool
-	Reasons   []string
-	Score     int // Higher score means higher probability of spoofing
+	IsSpoofed bool        `json:"is_spoofed"`
+	Verdict   Verdict     `json:"verdict"`
+	Reasons   []string    `json:"reasons"`
+	Findings  []Finding   `json:"findings"`
+	Score     int         `json:"score"`     // Higher score means higher probability of spoofing
+	Threshold int         `json:"threshold"` // Score threshold applied to this email
+	Origin    *OriginInfo `json:"origin,omitempty"`
+
+	// PrimaryReason is the reason of the finding that weighs most, empty when no
+	// finding counts towards the score
+	PrimaryReason string `json:"primary_reason,omitempty"`
+
+	// Action is set when action bands are configured
+	Action Action `json:"action,omitempty"`
+
+	// RulesetVersion identifies the rule set that produced the verdict
+	RulesetVersion string `json:"ruleset_version"`
+
+	// Fingerprint identifies the content of the message, shared by the copies
+	// of a campaign message sent to different recipients (see Email.Fingerprint)
+	Fingerprint string `json:"fingerprint"`
+
+	// NotEvaluated lists the checks skipped because they need network access
+	NotEvaluated []string `json:"not_evaluated,omitempty"`
+
+	// Diagnostics lists the infrastructure problems met during the analysis, such
+	// as DNS lookups that timed out or failed with SERVFAIL. They leave the checks
+	// involved inconclusive and don't count towards the score.
+	Diagnostics []string `json:"diagnostics,omitempty"`
+
+	// Encryption is set for an encrypted body, whose content couldn't be checked
+	Encryption string `json:"encryption,omitempty"`
+
+	Attachments []AttachmentInfo `json:"attachments,omitempty"`
+
+	// QRLinks lists the links decoded from QR codes in images
+	QRLinks []string `json:"qr_links,omitempty"`
+
+	Authentication AuthenticationResults `json:"authentication"`
+}
+
+// AddFinding records a triggered rule and adds its weight to the score
+func (r *AnalysisResult) AddFinding(rule string, severity Severity, weight int, message string) {
+	r.Score += weight
+	r.Reasons = append(r.Reasons, message)
+	r.Findings = append(r.Findings, Finding{
+		Rule:     rule,
+		Severity: severity,
+		Message:  message,
+		Weight:   weight,
+	})
+}
+
+// AddDiagnostic records an infrastructure problem, leaving the score and findings alone
+func (r *AnalysisResult) AddDiagnostic(message string) {
+	r.Diagnostics = append(r.Diagnostics, message)
 }
 
-// GetDomain extracts the domain part from an email address
+// RemoveFindings removes the findings of the given rules along with their reasons
+// and weight, returning the names of the rules removed
+func (r *AnalysisResult) RemoveFindings(rules ...string) []string {
+	return r.RemoveFindingsFunc(func(finding Finding) bool {
+		for _, rule := range rules {
+			if finding.Rule == rule {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// RemoveFindingsFunc removes the findings for which drop returns true along with
+// their reasons and weight, returning the names of the rules removed
+func (r *AnalysisResult) RemoveFindingsFunc(drop func(Finding) bool) []string {
+	var removed []string
+	findings, reasons := r.Findings[:0], r.Reasons[:0]
+	for i, finding := range r.Findings {
+		if drop(finding) {
+			removed = append(removed, finding.Rule)
+			r.Score -= finding.Weight
+			continue
+		}
+		findings = append(findings, finding)
+		reasons = append(reasons, r.Reasons[i])
+	}
+
+	r.Findings, r.Reasons = findings, reasons
+	return removed
+}
+
+// CountAtLeast returns the number of findings with at least the given severity
+func (r *AnalysisResult) CountAtLeast(severity Severity) int {
+	count := 0
+	for _, finding := range r.Findings {
+		if finding.Severity >= severity {
+			count++
+		}
+	}
+	return count
+}
+
+// SortFindings puts the findings, and their reasons, in canonical order and sets
+// the primary reason. Findings are ranked by weight times severity, so the one
+// that matters most comes first, then by severity, rule name and message. Checks
+// run in an order that changes as rules are added, reordered or run concurrently;
+// sorting makes the result of analyzing an email the same on every run. The
+// primary reason is that of the first finding that adds to the score.
+func (r *AnalysisResult) SortFindings() {
+	order := make([]int, len(r.Findings))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := r.Findings[order[i]], r.Findings[order[j]]
+		if rankA, rankB := a.Weight*int(a.Severity), b.Weight*int(b.Severity); rankA != rankB {
+			return rankA > rankB
+		}
+		if a.Severity != b.Severity {
+			return a.Severity > b.Severity
+		}
+		if a.Rule != b.Rule {
+			return a.Rule < b.Rule
+		}
+		return a.Message < b.Message
+	})
+
+	findings := make([]Finding, len(order))
+	reasons := make([]string, len(order))
+	for i, index := range order {
+		findings[i], reasons[i] = r.Findings[index], r.Reasons[index]
+	}
+	r.Findings, r.Reasons = findings, reasons
+
+	// Scored findings of info severity rank with the notes, so the first finding
+	// that adds to the score is the primary reason rather than the first one
+	r.PrimaryReason = ""
+	for i, finding := range r.Findings {
+		if finding.Weight > 0 {
+			r.PrimaryReason = r.Reasons[i]
+			break
+		}
+	}
+}
+
+// GetDomain extracts the domain part from an email address. The domain follows
+// the last @, as a quoted local part may contain one, e.g. "a@b"@example.com.
+// Domain literals such as [203.0.113.5] are returned with their brackets; see
+// DomainIP.
 func GetDomain(address *mail.Address) string {
 	if address == nil {
 		return ""
 	}
-	
-	parts := strings.Split(address.Address, "@")
-	if len(parts) != 2 {
+
+	at := strings.LastIndex(address.Address, "@")
+	if at < 0 || at == len(address.Address)-1 {
+		return ""
+	}
+
+	return address.Address[at+1:]
+}
+
+// DomainIP returns the IP address that stands in for a domain name, either as a
+// domain literal ([203.0.113.5] or [IPv6:2001:db8::1]) or as a bare dotted-quad
+// hostname (203.0.113.5), or nil for a domain name
+func DomainIP(domain string) net.IP {
+	domain = strings.TrimSuffix(domain, ".")
+	if strings.HasPrefix(domain, "[") && strings.HasSuffix(domain, "]") {
+		literal := domain[1 : len(domain)-1]
+		if len(literal) > len("IPv6:") && strings.EqualFold(literal[:len("IPv6:")], "IPv6:") {
+			if ip := net.ParseIP(literal[len("IPv6:"):]); ip != nil && ip.To4() == nil {
+				return ip
+			}
+			return nil
+		}
+		return net.ParseIP(literal).To4()
+	}
+	return net.ParseIP(domain).To4()
+}
+
+// GetMessageIDDomain extracts the domain part from a Message-ID such as <id@host.example.com>
+func GetMessageIDDomain(messageID string) string {
+	messageID = strings.TrimSpace(messageID)
+	messageID = strings.TrimPrefix(messageID, "<")
+	messageID = strings.TrimSuffix(messageID, ">")
+
+	at := strings.LastIndex(messageID, "@")
+	if at < 0 || at == len(messageID)-1 {
 		return ""
 	}
-	
-	return parts[1]
+
+	return strings.ToLower(messageID[at+1:])
+}
+
+// Attachments returns the parts of the email that are attachments
+func (e *Email) Attachments() []Part {
+	var attachments []Part
+	for _, part := range e.Parts {
+		if part.IsAttachment() {
+			attachments = append(attachments, part)
+		}
+	}
+	return attachments
 }
 
 // GetHeaderValue returns the first value of a header field
 func (e *Email) GetHeaderValue(name string) string {
-	values, exists := e.Headers[name]
+	values, exists := e.Headers[textproto.CanonicalMIMEHeaderKey(name)]
 	if !exists || len(values) == 0 {
 		return ""
 	}
@@ -49,11 +406,11 @@ func (e *Email) GetHeaderValue(name string) string {
 
 // GetAllHeaderValues returns all values of a header field
 func (e *Email) GetAllHeaderValues(name string) []string {
-	return e.Headers[name]
+	return e.Headers[textproto.CanonicalMIMEHeaderKey(name)]
 }
 
 // HasHeader checks if a header exists
 func (e *Email) HasHeader(name string) bool {
-	_, exists := e.Headers[name]
+	_, exists := e.Headers[textproto.CanonicalMIMEHeaderKey(name)]
 	return exists
 }
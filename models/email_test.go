@@ -0,0 +1,82 @@
+package models
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestSortFindings(t *testing.T) {
+	findings := []Finding{
+		{Rule: "spf", Severity: SeverityMedium, Weight: 3, Message: "SPF fail"},
+		{Rule: "brand_impersonation", Severity: SeverityHigh, Weight: 4, Message: "Display name presents PayPal"},
+		{Rule: "brand_impersonation", Severity: SeverityHigh, Weight: 4, Message: "Domain looks like paypal.com"},
+		{Rule: "dkim", Severity: SeverityMedium, Weight: 3, Message: "No DKIM signature"},
+		{Rule: "risky_tld", Severity: SeverityMedium, Weight: 2, Message: "From domain is on .top"},
+		{Rule: "dmarc", Severity: SeverityLow, Weight: 2, Message: "No DMARC record"},
+		// Ranked the same as a weight of 2 at medium severity, but less severe
+		{Rule: "bcc_recipient", Severity: SeverityLow, Weight: 4, Message: "Delivered as Bcc"},
+		{Rule: "bimi", Severity: SeverityInfo, Weight: 0, Message: "No BIMI record"},
+		{Rule: "smtp_smuggling", Severity: SeverityCritical, Weight: 5, Message: "Dot line with a bare LF"},
+	}
+	want := []string{
+		"Dot line with a bare LF",
+		"Display name presents PayPal",
+		"Domain looks like paypal.com",
+		"No DKIM signature",
+		"SPF fail",
+		"From domain is on .top",
+		"Delivered as Bcc",
+		"No DMARC record",
+		"No BIMI record",
+	}
+
+	// Findings added in any order end up in the same order
+	random := rand.New(rand.NewSource(1))
+	for run := 0; run < 50; run++ {
+		result := &AnalysisResult{}
+		for _, i := range random.Perm(len(findings)) {
+			result.AddFinding(findings[i].Rule, findings[i].Severity, findings[i].Weight, findings[i].Message)
+		}
+		result.SortFindings()
+
+		if !reflect.DeepEqual(result.Reasons, want) {
+			t.Fatalf("run %d: reasons in order %q, want %q", run, result.Reasons, want)
+		}
+		for i, finding := range result.Findings {
+			if finding.Message != result.Reasons[i] {
+				t.Fatalf("run %d: finding %d %q is out of step with reason %q", run, i, finding.Message, result.Reasons[i])
+			}
+		}
+		if result.PrimaryReason != want[0] {
+			t.Fatalf("run %d: primary reason %q, want %q", run, result.PrimaryReason, want[0])
+		}
+	}
+}
+
+func TestSortFindingsPrimaryReason(t *testing.T) {
+	// Notes that don't add to the score aren't a reason to flag an email
+	result := &AnalysisResult{}
+	result.AddFinding("offline_mode", SeverityInfo, 0, "Offline mode")
+	result.SortFindings()
+	if result.PrimaryReason != "" {
+		t.Errorf("primary reason %q with only a note, want none", result.PrimaryReason)
+	}
+
+	// A scored info finding ranks behind a note it ties with, and is still the
+	// primary reason
+	result = &AnalysisResult{}
+	result.AddFinding("allowlist_suppression", SeverityInfo, 0, "Allowlisted sender")
+	result.AddFinding("custom_info", SeverityInfo, 1, "Custom info check")
+	result.AddFinding("dns_unavailable", SeverityLow, 0, "DNS unavailable")
+	result.SortFindings()
+	if result.PrimaryReason != "Custom info check" {
+		t.Errorf("primary reason %q with findings %v, want the scored info finding", result.PrimaryReason, result.Findings)
+	}
+
+	result = &AnalysisResult{}
+	result.SortFindings()
+	if result.PrimaryReason != "" || len(result.Findings) != 0 {
+		t.Errorf("empty result sorted into %v with primary reason %q", result.Findings, result.PrimaryReason)
+	}
+}
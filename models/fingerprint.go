@@ -0,0 +1,53 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// Fingerprint returns a hex SHA-256 identifying the content of an email, the same
+// for every copy of a campaign message however it was re-encoded in transit and
+// whoever received it. It hashes, one per line:
+//
+//   - the From and Reply-To addresses, lower-cased
+//   - the decoded Subject with runs of whitespace collapsed to one space
+//   - for each leaf MIME part in order, its media type and the SHA-256 of its
+//     decoded content; text parts are hashed after conversion to UTF-8 with runs
+//     of whitespace, including line breaks, collapsed to one space
+//
+// Recipients, dates, message IDs, Received headers, MIME boundaries, transfer
+// encodings and attachment file names are left out, as they change between
+// copies of the same message.
+func (e *Email) Fingerprint() string {
+	var canonical strings.Builder
+	address := func(a string) {
+		canonical.WriteString(strings.ToLower(a))
+		canonical.WriteByte('\n')
+	}
+
+	if e.From != nil {
+		address(e.From.Address)
+	} else {
+		address("")
+	}
+	if e.ReplyTo != nil {
+		address(e.ReplyTo.Address)
+	} else {
+		address("")
+	}
+	canonical.WriteString(strings.Join(strings.Fields(e.Subject), " "))
+	canonical.WriteByte('\n')
+
+	for _, part := range e.Parts {
+		digest := part.SHA256
+		if strings.HasPrefix(part.ContentType, "text/") && part.Disposition != "attachment" {
+			sum := sha256.Sum256([]byte(strings.Join(strings.Fields(string(part.Body)), " ")))
+			digest = hex.EncodeToString(sum[:])
+		}
+		canonical.WriteString(part.ContentType + " " + digest + "\n")
+	}
+
+	sum := sha256.Sum256([]byte(canonical.String()))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,59 @@
+package qr
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif" // Register the image formats found in email
+	_ "image/jpeg"
+	_ "image/png"
+
+	"github.com/makiuchi-d/gozxing"
+	multiqr "github.com/makiuchi-d/gozxing/multi/qrcode"
+)
+
+// maxPixels bounds the size of decoded images, so that a small compressed image
+// can't expand into gigabytes of memory
+const maxPixels = 25_000_000
+
+// Decoder reads QR codes from PNG, JPEG and GIF images
+type Decoder struct{}
+
+// Decode returns the text of every QR code found in an image, or none if the
+// image doesn't contain a readable one
+func (Decoder) Decode(data []byte) ([]string, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("reading image: %w", err)
+	}
+	if config.Width*config.Height > maxPixels {
+		return nil, fmt.Errorf("image of %dx%d pixels is too large", config.Width, config.Height)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("decoding image: %w", err)
+	}
+
+	bitmap, err := gozxing.NewBinaryBitmapFromImage(img)
+	if err != nil {
+		return nil, fmt.Errorf("binarizing image: %w", err)
+	}
+
+	hints := map[gozxing.DecodeHintType]interface{}{gozxing.DecodeHintType_TRY_HARDER: true}
+	results, err := multiqr.NewQRCodeMultiReader().DecodeMultiple(bitmap, hints)
+	var notFound gozxing.ReaderException
+	if errors.As(err, &notFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("decoding QR code: %w", err)
+	}
+
+	var texts []string
+	for _, result := range results {
+		texts = append(texts, result.GetText())
+	}
+	return texts, nil
+}
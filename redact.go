@@ -0,0 +1,272 @@
+package main
+
+import (
+	"net"
+	"net/mail"
+	"regexp"
+	"strings"
+
+	"github.com/user/email_spoof_detection/detector"
+	"github.com/user/email_spoof_detection/models"
+)
+
+// Placeholders that replace redacted values
+const (
+	redactedAddress = "redacted@redacted.invalid"
+	redactedIP      = "[internal-ip]"
+	redactedHost    = "[internal-host]"
+)
+
+// redactIPPattern matches IPv4 and IPv6 address candidates
+var redactIPPattern = regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b|\b[0-9a-fA-F]{0,4}(?::[0-9a-fA-F]{0,4}){2,7}\b`)
+
+// redactHostPattern matches host names of two or more labels
+var redactHostPattern = regexp.MustCompile(`(?i)\b[a-z0-9](?:[a-z0-9-]*[a-z0-9])?(?:\.[a-z0-9](?:[a-z0-9-]*[a-z0-9])?)+\b`)
+
+// redactForPattern matches the "for" clause of a Received header, whose address
+// may be wrapped in angle brackets and folded onto the next line
+var redactForPattern = regexp.MustCompile(`(?i)(\bfor\s+)<?[^\s<>;]+@[^\s<>;]+>?`)
+
+// recipientHeaders are the headers whose addresses identify the recipients
+var recipientHeaders = []string{"To", "Cc", "Resent-To", "Delivered-To", "X-Original-To", "Envelope-To"}
+
+// redactor masks recipients, internal IPs and the host names of the internal
+// domains so results can be shared without leaking victim data, while keeping the
+// sender details intact
+type redactor struct {
+	recipients      []*regexp.Regexp // Case-insensitive recipient address patterns
+	internalDomains []string         // Lower-case domains whose host names are masked
+}
+
+// newRedactor collects the recipient addresses of an email. Host names under the
+// internal domains, such as those of the organization's mail servers, are masked too.
+func newRedactor(email *models.Email, internalDomains []string) *redactor {
+	sender := ""
+	if email.From != nil {
+		sender = strings.ToLower(email.From.Address)
+	}
+
+	r := &redactor{}
+	for _, domain := range internalDomains {
+		r.internalDomains = append(r.internalDomains, strings.ToLower(strings.Trim(domain, ".")))
+	}
+	seen := map[string]bool{}
+	add := func(address string) {
+		lower := strings.ToLower(address)
+		if lower != "" && lower != sender && !seen[lower] {
+			seen[lower] = true
+			r.recipients = append(r.recipients, regexp.MustCompile("(?i)"+regexp.QuoteMeta(lower)))
+		}
+	}
+	for _, name := range recipientHeaders {
+		for _, value := range email.GetAllHeaderValues(name) {
+			addresses, err := mail.ParseAddressList(value)
+			if err != nil {
+				continue
+			}
+			for _, address := range addresses {
+				add(address.Address)
+			}
+		}
+	}
+	// Envelope recipients include those sent a Bcc, who appear in no header
+	for _, hop := range email.Received {
+		add(hop.For)
+	}
+
+	return r
+}
+
+// text masks the recipient addresses, internal host names and internal IPs in a string
+func (r *redactor) text(value string) string {
+	for _, recipient := range r.recipients {
+		value = recipient.ReplaceAllLiteralString(value, redactedAddress)
+	}
+
+	if len(r.internalDomains) > 0 {
+		value = redactHostPattern.ReplaceAllStringFunc(value, r.host)
+	}
+
+	return redactIPPattern.ReplaceAllStringFunc(value, func(match string) string {
+		if ip := net.ParseIP(match); ip != nil && !detector.IsPublicIP(ip) {
+			return redactedIP
+		}
+		return match
+	})
+}
+
+// host masks a host name that is a subdomain of one of the internal domains.
+// The domains themselves are left alone, as they name the organization rather
+// than a machine in it.
+func (r *redactor) host(name string) string {
+	lower := strings.ToLower(name)
+	for _, domain := range r.internalDomains {
+		if strings.HasSuffix(lower, "."+domain) {
+			return redactedHost
+		}
+	}
+	return name
+}
+
+// header masks a header value. Recipient headers are replaced whole, so display
+// names go too, and the for clause of Received headers names the recipient.
+func (r *redactor) header(name, value string) string {
+	if isRecipientHeader(name) {
+		return redactRecipients(value)
+	}
+	if strings.EqualFold(name, "Received") {
+		value = redactForPattern.ReplaceAllString(value, "${1}<"+redactedAddress+">")
+	}
+	return r.text(value)
+}
+
+// rawHeaders masks the header fields in the header section of a raw message as
+// header does, keeping the line endings of the message
+func (r *redactor) rawHeaders(raw string) string {
+	lines := strings.SplitAfter(raw, "\n")
+
+	var out strings.Builder
+	i := 0
+	for i < len(lines) && strings.TrimRight(lines[i], "\r\n") != "" {
+		// A field runs on over the lines starting with white space
+		field := lines[i]
+		for i++; i < len(lines) && (strings.HasPrefix(lines[i], " ") || strings.HasPrefix(lines[i], "\t")); i++ {
+			field += lines[i]
+		}
+
+		name, value, found := strings.Cut(field, ":")
+		switch {
+		case !found:
+			out.WriteString(field)
+		case isRecipientHeader(name):
+			eol := field[len(strings.TrimRight(field, "\r\n")):]
+			out.WriteString(name + ": " + redactRecipients(value) + eol)
+		default:
+			out.WriteString(name + ":" + r.header(name, value))
+		}
+	}
+
+	for ; i < len(lines); i++ {
+		out.WriteString(lines[i])
+	}
+	return out.String()
+}
+
+// email returns a copy of an email with recipients and internal IPs masked
+func (r *redactor) email(email *models.Email) *models.Email {
+	redacted := *email
+	redacted.To = redactAddresses(email.To)
+	redacted.Cc = redactAddresses(email.Cc)
+
+	redacted.Resent = make([]models.Resent, len(email.Resent))
+	for i, block := range email.Resent {
+		block.To = redactAddresses(block.To)
+		redacted.Resent[i] = block
+	}
+	redacted.Received = make([]models.ReceivedHop, len(email.Received))
+	for i, hop := range email.Received {
+		if hop.For != "" {
+			hop.For = redactedAddress
+		}
+		hop.FromHELO = r.host(hop.FromHELO)
+		hop.FromHost = r.host(hop.FromHost)
+		hop.ByHost = r.host(hop.ByHost)
+		redacted.Received[i] = hop
+	}
+	redacted.Subject = r.text(email.Subject)
+	redacted.Body = r.text(email.Body)
+	redacted.RawContent = []byte(r.text(r.rawHeaders(string(email.RawContent))))
+
+	redacted.Headers = make(map[string][]string, len(email.Headers))
+	for name, values := range email.Headers {
+		masked := make([]string, len(values))
+		for i, value := range values {
+			masked[i] = r.header(name, value)
+		}
+		redacted.Headers[name] = masked
+	}
+
+	redacted.ListUnsubscribe = make([]string, len(email.ListUnsubscribe))
+	for i, uri := range email.ListUnsubscribe {
+		redacted.ListUnsubscribe[i] = r.text(uri)
+	}
+
+	redacted.Links = make([]models.Link, len(email.Links))
+	for i, link := range email.Links {
+		redacted.Links[i] = models.Link{URL: r.text(link.URL), Text: r.text(link.Text)}
+	}
+
+	redacted.BodyAddresses = nil
+	for _, address := range email.BodyAddresses {
+		masked := r.text(address.Address)
+		if masked != address.Address {
+			address = models.BodyAddress{Address: masked, Domain: "redacted.invalid"}
+		}
+		redacted.BodyAddresses = append(redacted.BodyAddresses, address)
+	}
+
+	redacted.Parts = make([]models.Part, len(email.Parts))
+	for i, part := range email.Parts {
+		part.Body = []byte(r.text(string(part.Body)))
+		redacted.Parts[i] = part
+	}
+
+	return &redacted
+}
+
+// result returns a copy of an analysis result with its messages masked
+func (r *redactor) result(result *models.AnalysisResult) *models.AnalysisResult {
+	redacted := *result
+	redacted.Reasons = make([]string, len(result.Reasons))
+	for i, reason := range result.Reasons {
+		redacted.Reasons[i] = r.text(reason)
+	}
+
+	redacted.PrimaryReason = r.text(result.PrimaryReason)
+
+	redacted.Diagnostics = make([]string, len(result.Diagnostics))
+	for i, diagnostic := range result.Diagnostics {
+		redacted.Diagnostics[i] = r.text(diagnostic)
+	}
+
+	redacted.Findings = make([]models.Finding, len(result.Findings))
+	for i, finding := range result.Findings {
+		finding.Message = r.text(finding.Message)
+		redacted.Findings[i] = finding
+	}
+
+	return &redacted
+}
+
+// redactAddresses replaces each address of a list with the placeholder
+func redactAddresses(addresses []*mail.Address) []*mail.Address {
+	var redacted []*mail.Address
+	for range addresses {
+		redacted = append(redacted, &mail.Address{Address: redactedAddress})
+	}
+	return redacted
+}
+
+// redactRecipients replaces a recipient header value with a placeholder for each
+// of its addresses, dropping the display names
+func redactRecipients(value string) string {
+	addresses, err := mail.ParseAddressList(strings.TrimSpace(value))
+	if err != nil || len(addresses) == 0 {
+		return redactedAddress
+	}
+	placeholders := make([]string, len(addresses))
+	for i := range placeholders {
+		placeholders[i] = redactedAddress
+	}
+	return strings.Join(placeholders, ", ")
+}
+
+// isRecipientHeader checks if a header field is one of the recipientHeaders
+func isRecipientHeader(name string) bool {
+	for _, recipient := range recipientHeaders {
+		if strings.EqualFold(strings.TrimSpace(name), recipient) {
+			return true
+		}
+	}
+	return false
+}
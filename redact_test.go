@@ -0,0 +1,89 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/user/email_spoof_detection/models"
+	"github.com/user/email_spoof_detection/utils"
+)
+
+const redactSample = "Received: from mail.attacker.example (mail.attacker.example [203.0.113.9])\r\n" +
+	"\tby mx1.corp.example (Postfix) with ESMTPS id 4F2A\r\n" +
+	"\tfor <bcc.victim@corp.example>; Mon, 3 Jun 2024 10:00:05 +0000\r\n" +
+	"Received: from relay.corp.example ([10.1.2.3]) by mx1.corp.example with SMTP; Mon, 3 Jun 2024 10:00:06 +0000\r\n" +
+	"From: Billing <billing@attacker.example>\r\n" +
+	"To: Jane Victim <jane@corp.example>,\r\n" +
+	" \"Doe, John\" <john@corp.example>\r\n" +
+	"Cc: Finance Team <finance@corp.example>\r\n" +
+	"Subject: Invoice for jane@corp.example\r\n" +
+	"Date: Mon, 3 Jun 2024 10:00:00 +0000\r\n" +
+	"Message-ID: <1@attacker.example>\r\n" +
+	"\r\n" +
+	"Jane, pay via https://pay.attacker.example or ask helpdesk.corp.example.\r\n"
+
+func TestRedactor(t *testing.T) {
+	email, err := utils.ParseEmail([]byte(redactSample))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := &models.AnalysisResult{
+		Findings: []models.Finding{{Rule: "bcc_recipient", Message: "Delivered by mx1.corp.example to bcc.victim@corp.example"}},
+	}
+
+	r := newRedactor(email, []string{"corp.example"})
+	redacted, redactedResult := r.email(email), r.result(result)
+
+	var texts []string
+	texts = append(texts, string(redacted.RawContent), redacted.Subject, redacted.Body, redactedResult.Findings[0].Message)
+	for _, values := range redacted.Headers {
+		texts = append(texts, values...)
+	}
+	for _, hop := range redacted.Received {
+		texts = append(texts, hop.FromHELO, hop.FromHost, hop.ByHost, hop.For)
+	}
+	all := strings.Join(texts, "\n")
+
+	// Recipient addresses and display names, the envelope recipient, internal
+	// hosts and internal IPs are gone
+	for _, leak := range []string{"jane@", "john@", "finance@", "bcc.victim", "Jane Victim", "Doe, John", "Finance Team",
+		"mx1.corp.example", "relay.corp.example", "helpdesk.corp.example", "10.1.2.3"} {
+		if strings.Contains(all, leak) {
+			t.Errorf("%q not redacted", leak)
+		}
+	}
+
+	// The sender, public hosts and IPs stay
+	for _, kept := range []string{"billing@attacker.example", "Billing", "mail.attacker.example", "203.0.113.9", "pay.attacker.example"} {
+		if !strings.Contains(all, kept) {
+			t.Errorf("%q redacted", kept)
+		}
+	}
+
+	if to := redacted.Headers["To"][0]; to != redactedAddress+", "+redactedAddress {
+		t.Errorf("To = %q, want a placeholder per recipient", to)
+	}
+	if raw := string(redacted.RawContent); !strings.Contains(raw, "for <"+redactedAddress+">;") || !strings.Contains(raw, "\r\nCc: "+redactedAddress+"\r\n") {
+		t.Errorf("raw headers not redacted in place:\n%s", raw)
+	}
+	if redacted.Received[0].ByHost != redactedHost {
+		t.Errorf("ByHost = %q, want %q", redacted.Received[0].ByHost, redactedHost)
+	}
+}
+
+// TestRedactorWithoutInternalDomains checks that host names are kept when no
+// internal domains are configured
+func TestRedactorWithoutInternalDomains(t *testing.T) {
+	email, err := utils.ParseEmail([]byte(redactSample))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	redacted := newRedactor(email, nil).email(email)
+	if !strings.Contains(string(redacted.RawContent), "by mx1.corp.example") {
+		t.Error("host name of the receiving server masked without internal domains")
+	}
+	if strings.Contains(string(redacted.RawContent), "Jane Victim") {
+		t.Error("display name of a recipient not redacted")
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"math/rand"
+	"os"
+	"sort"
+)
+
+// emailFiles returns the regular entries of a directory listing, skipping
+// subdirectories
+func emailFiles(entries []os.DirEntry) []os.DirEntry {
+	var files []os.DirEntry
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			files = append(files, entry)
+		}
+	}
+	return files
+}
+
+// sampleFiles selects size of the files using a generator seeded with seed, so
+// the same directory, size and seed always give the same sample. The sample keeps
+// the directory order; all files are returned when size isn't below their number.
+func sampleFiles(files []os.DirEntry, size int, seed int64) []os.DirEntry {
+	if size <= 0 || size >= len(files) {
+		return files
+	}
+
+	picked := rand.New(rand.NewSource(seed)).Perm(len(files))[:size]
+	sort.Ints(picked)
+
+	sample := make([]os.DirEntry, 0, size)
+	for _, i := range picked {
+		sample = append(sample, files[i])
+	}
+	return sample
+}
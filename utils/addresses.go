@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"html"
+	"regexp"
+	"strings"
+
+	"github.com/user/email_spoof_detection/models"
+)
+
+// bodyAddressPattern matches an email address in text
+var bodyAddressPattern = regexp.MustCompile(`(?i)\b[a-z0-9._%+\-]+@[a-z0-9\-]+(?:\.[a-z0-9\-]+)*\.[a-z]{2,}\b`)
+
+// extractBodyAddresses collects the distinct email addresses mentioned in the
+// text and HTML parts of an email, in the order they first appear
+func extractBodyAddresses(email *models.Email) {
+	seen := map[string]bool{}
+
+	for _, part := range email.Parts {
+		if part.IsAttachment() {
+			continue
+		}
+
+		var text string
+		switch part.ContentType {
+		case "text/html":
+			// mailto: links and visible text both count
+			text = html.UnescapeString(string(part.Body))
+		case "text/plain":
+			text = string(part.Body)
+		default:
+			continue
+		}
+
+		for _, address := range bodyAddressPattern.FindAllString(text, -1) {
+			address = strings.ToLower(address)
+			if seen[address] {
+				continue
+			}
+			seen[address] = true
+
+			email.BodyAddresses = append(email.BodyAddresses, models.BodyAddress{
+				Address: address,
+				Domain:  address[strings.LastIndex(address, "@")+1:],
+			})
+		}
+	}
+}
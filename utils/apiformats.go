@@ -0,0 +1,267 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"strings"
+	"time"
+)
+
+// Input formats accepted by ConvertToMIME
+const (
+	FormatAuto  = "auto"  // Sniff .json files, treat everything else as MIME
+	FormatMIME  = "eml"   // Raw RFC 5322 message
+	FormatGmail = "gmail" // Gmail API Message resource
+	FormatGraph = "graph" // Microsoft Graph message resource
+)
+
+// gmailMessage is the part of a Gmail API Message resource needed to rebuild the email
+type gmailMessage struct {
+	Raw     string            `json:"raw"` // Whole message, base64url encoded, for format=raw
+	Payload *gmailMessagePart `json:"payload"`
+}
+
+// gmailMessagePart is a node of the MIME tree of a Gmail API message
+type gmailMessagePart struct {
+	MimeType string `json:"mimeType"`
+	Headers  []struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	} `json:"headers"`
+	Body struct {
+		Data string `json:"data"` // Decoded content, base64url encoded
+	} `json:"body"`
+	Parts []gmailMessagePart `json:"parts"`
+}
+
+// graphMessage is the part of a Microsoft Graph message resource needed to rebuild the email
+type graphMessage struct {
+	InternetMessageHeaders []struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	} `json:"internetMessageHeaders"`
+	InternetMessageID string            `json:"internetMessageId"`
+	Subject           string            `json:"subject"`
+	From              *graphRecipient   `json:"from"`
+	Sender            *graphRecipient   `json:"sender"`
+	ToRecipients      []graphRecipient  `json:"toRecipients"`
+	CcRecipients      []graphRecipient  `json:"ccRecipients"`
+	ReplyTo           []graphRecipient  `json:"replyTo"`
+	SentDateTime      string            `json:"sentDateTime"`
+	Body              *graphMessageBody `json:"body"`
+}
+
+// graphRecipient is a Microsoft Graph recipient
+type graphRecipient struct {
+	EmailAddress struct {
+		Name    string `json:"name"`
+		Address string `json:"address"`
+	} `json:"emailAddress"`
+}
+
+// graphMessageBody is the body of a Microsoft Graph message
+type graphMessageBody struct {
+	ContentType string `json:"contentType"` // "text" or "html"
+	Content     string `json:"content"`
+}
+
+// ConvertToMIME turns an input in the given format into a raw MIME message that
+// ParseEmail accepts. In FormatAuto, files named *.json are identified as Gmail or
+// Graph messages by their fields and anything else is returned unchanged.
+func ConvertToMIME(name string, data []byte, format string) ([]byte, error) {
+	switch format {
+	case "", FormatAuto:
+		if !strings.HasSuffix(strings.ToLower(name), ".json") {
+			return data, nil
+		}
+		return ConvertToMIME(name, data, sniffAPIFormat(data))
+	case FormatMIME:
+		return data, nil
+	case FormatGmail:
+		return ConvertGmailMessage(data)
+	case FormatGraph:
+		return ConvertGraphMessage(data)
+	default:
+		return nil, fmt.Errorf("unknown input format %q", format)
+	}
+}
+
+// sniffAPIFormat identifies a JSON message as a Gmail or Graph resource
+func sniffAPIFormat(data []byte) string {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return FormatMIME
+	}
+
+	switch {
+	case fields["payload"] != nil || fields["raw"] != nil:
+		return FormatGmail
+	case fields["internetMessageHeaders"] != nil || fields["body"] != nil || fields["from"] != nil:
+		return FormatGraph
+	}
+	return FormatMIME
+}
+
+// ConvertGmailMessage rebuilds the raw message of a Gmail API Message resource,
+// fetched with format=raw or format=full
+func ConvertGmailMessage(data []byte) ([]byte, error) {
+	var message gmailMessage
+	if err := json.Unmarshal(data, &message); err != nil {
+		return nil, fmt.Errorf("parsing Gmail message: %w", err)
+	}
+
+	if message.Raw != "" {
+		raw, err := decodeBase64URL(message.Raw)
+		if err != nil {
+			return nil, fmt.Errorf("decoding Gmail raw message: %w", err)
+		}
+		return raw, nil
+	}
+
+	if message.Payload == nil {
+		return nil, errors.New("Gmail message has neither a raw message nor a payload")
+	}
+
+	var buf bytes.Buffer
+	if err := writeGmailPart(&buf, message.Payload); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeGmailPart writes a Gmail message part with its headers. Leaf content is
+// written base64 encoded, since the API has already decoded any transfer encoding.
+func writeGmailPart(buf *bytes.Buffer, part *gmailMessagePart) error {
+	for _, header := range part.Headers {
+		if strings.EqualFold(header.Name, "Content-Transfer-Encoding") {
+			continue
+		}
+		fmt.Fprintf(buf, "%s: %s\r\n", header.Name, header.Value)
+	}
+
+	if !strings.HasPrefix(strings.ToLower(part.MimeType), "multipart/") {
+		content, err := decodeBase64URL(part.Body.Data)
+		if err != nil {
+			return fmt.Errorf("decoding Gmail part body: %w", err)
+		}
+		buf.WriteString("Content-Transfer-Encoding: base64\r\n\r\n")
+		writeBase64Lines(buf, content)
+		return nil
+	}
+	buf.WriteString("\r\n")
+
+	// Multipart parts carry their boundary in their own Content-Type header
+	boundary := ""
+	for _, header := range part.Headers {
+		if strings.EqualFold(header.Name, "Content-Type") {
+			if _, params, err := mime.ParseMediaType(header.Value); err == nil {
+				boundary = params["boundary"]
+			}
+		}
+	}
+	if boundary == "" {
+		return fmt.Errorf("Gmail %s part has no boundary", part.MimeType)
+	}
+
+	for i := range part.Parts {
+		fmt.Fprintf(buf, "--%s\r\n", boundary)
+		if err := writeGmailPart(buf, &part.Parts[i]); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(buf, "--%s--\r\n", boundary)
+	return nil
+}
+
+// ConvertGraphMessage rebuilds a raw message from a Microsoft Graph message
+// resource. The original headers are used when the resource was fetched with
+// internetMessageHeaders selected; otherwise they are rebuilt from its fields.
+func ConvertGraphMessage(data []byte) ([]byte, error) {
+	var message graphMessage
+	if err := json.Unmarshal(data, &message); err != nil {
+		return nil, fmt.Errorf("parsing Graph message: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if len(message.InternetMessageHeaders) > 0 {
+		for _, header := range message.InternetMessageHeaders {
+			// The body below is written in its own encoding
+			switch strings.ToLower(header.Name) {
+			case "content-type", "content-transfer-encoding", "mime-version":
+				continue
+			}
+			fmt.Fprintf(&buf, "%s: %s\r\n", header.Name, header.Value)
+		}
+	} else {
+		if message.From != nil {
+			writeGraphAddresses(&buf, "From", []graphRecipient{*message.From})
+			if message.Sender != nil &&
+				!strings.EqualFold(message.Sender.EmailAddress.Address, message.From.EmailAddress.Address) {
+				writeGraphAddresses(&buf, "Sender", []graphRecipient{*message.Sender})
+			}
+		}
+		writeGraphAddresses(&buf, "Reply-To", message.ReplyTo)
+		writeGraphAddresses(&buf, "To", message.ToRecipients)
+		writeGraphAddresses(&buf, "Cc", message.CcRecipients)
+		if message.Subject != "" {
+			fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", message.Subject))
+		}
+		if message.InternetMessageID != "" {
+			fmt.Fprintf(&buf, "Message-ID: %s\r\n", message.InternetMessageID)
+		}
+		if sent, err := time.Parse(time.RFC3339, message.SentDateTime); err == nil {
+			fmt.Fprintf(&buf, "Date: %s\r\n", sent.Format(time.RFC1123Z))
+		}
+	}
+
+	contentType := "text/plain"
+	content := ""
+	if message.Body != nil {
+		if strings.EqualFold(message.Body.ContentType, "html") {
+			contentType = "text/html"
+		}
+		content = message.Body.Content
+	}
+	fmt.Fprintf(&buf, "MIME-Version: 1.0\r\nContent-Type: %s; charset=utf-8\r\nContent-Transfer-Encoding: base64\r\n\r\n", contentType)
+	writeBase64Lines(&buf, []byte(content))
+
+	return buf.Bytes(), nil
+}
+
+// writeGraphAddresses writes an address header for Graph recipients, if there are any
+func writeGraphAddresses(buf *bytes.Buffer, name string, recipients []graphRecipient) {
+	var addresses []string
+	for _, recipient := range recipients {
+		if recipient.EmailAddress.Address == "" {
+			continue
+		}
+		address := "<" + recipient.EmailAddress.Address + ">"
+		if recipient.EmailAddress.Name != "" {
+			address = mime.QEncoding.Encode("utf-8", recipient.EmailAddress.Name) + " " + address
+		}
+		addresses = append(addresses, address)
+	}
+
+	if len(addresses) > 0 {
+		fmt.Fprintf(buf, "%s: %s\r\n", name, strings.Join(addresses, ", "))
+	}
+}
+
+// writeBase64Lines writes content base64 encoded in 76 character lines
+func writeBase64Lines(buf *bytes.Buffer, content []byte) {
+	encoded := base64.StdEncoding.EncodeToString(content)
+	for len(encoded) > 76 {
+		buf.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	buf.WriteString(encoded + "\r\n")
+}
+
+// decodeBase64URL decodes the URL-safe base64 used by the Gmail API, with or without padding
+func decodeBase64URL(value string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+}
@@ -0,0 +1,30 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// decodeCharset converts text in the given charset, such as ISO-8859-1, Windows-1252
+// or Shift_JIS, to UTF-8. Text without a charset or already in UTF-8 is returned as
+// is, as is text whose charset is unknown or that can't be decoded, with an error.
+func decodeCharset(charset string, text []byte) ([]byte, error) {
+	charset = strings.ToLower(strings.Trim(strings.TrimSpace(charset), `"`))
+	switch charset {
+	case "", "utf-8", "utf8", "us-ascii", "ascii":
+		return text, nil
+	}
+
+	encoding, err := htmlindex.Get(charset)
+	if err != nil {
+		return text, fmt.Errorf("unknown charset %q", charset)
+	}
+
+	decoded, err := encoding.NewDecoder().Bytes(text)
+	if err != nil {
+		return text, fmt.Errorf("invalid %s text: %w", charset, err)
+	}
+	return decoded, nil
+}
@@ -0,0 +1,51 @@
+package utils
+
+import (
+	"html"
+	"regexp"
+	"strings"
+
+	"github.com/user/email_spoof_detection/models"
+)
+
+var (
+	// anchorPattern matches an HTML anchor, capturing its href and its content
+	anchorPattern = regexp.MustCompile(`(?is)<a\s[^>]*?href\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s>]+))[^>]*>(.*?)</a>`)
+	// urlPattern matches a URL in plain text
+	urlPattern = regexp.MustCompile(`(?i)\bhttps?://[^\s<>"'()]+`)
+	// tagPattern matches an HTML tag
+	tagPattern = regexp.MustCompile(`<[^>]*>`)
+)
+
+// extractLinks collects the links of the text and HTML parts of an email
+func extractLinks(email *models.Email) {
+	seen := map[models.Link]bool{}
+	add := func(link models.Link) {
+		if link.URL != "" && !seen[link] {
+			seen[link] = true
+			email.Links = append(email.Links, link)
+		}
+	}
+
+	for _, part := range email.Parts {
+		if part.IsAttachment() {
+			continue
+		}
+
+		switch part.ContentType {
+		case "text/html":
+			for _, match := range anchorPattern.FindAllStringSubmatch(string(part.Body), -1) {
+				href := match[1] + match[2] + match[3]
+				text := tagPattern.ReplaceAllString(match[4], "")
+				add(models.Link{
+					URL:  strings.TrimSpace(html.UnescapeString(href)),
+					Text: strings.Join(strings.Fields(html.UnescapeString(text)), " "),
+				})
+			}
+		case "text/plain":
+			for _, url := range urlPattern.FindAllString(string(part.Body), -1) {
+				add(models.Link{URL: strings.TrimRight(url, ".,;:!?")})
+			}
+		}
+	}
+}
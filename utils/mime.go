@@ -0,0 +1,374 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"strings"
+
+	"github.com/user/email_spoof_detection/models"
+)
+
+// maxMIMEDepth is the deepest multipart nesting that is parsed
+const maxMIMEDepth = 10
+
+// contentHeaders are the MIME headers that decide how an entity is decoded and
+// displayed; parsers disagree on which copy wins when one is repeated
+var contentHeaders = []string{"Content-Type", "Content-Disposition", "Content-Transfer-Encoding"}
+
+// mimeWalker collects the leaf parts and structural anomalies of a MIME message
+type mimeWalker struct {
+	email      *models.Email
+	boundaries []string // Boundaries of the enclosing multiparts
+}
+
+// parseMIME walks the MIME structure of a message body, storing its leaf parts
+// and any structural anomalies on the email
+func parseMIME(email *models.Email, header map[string][]string, body []byte) {
+	walker := &mimeWalker{email: email}
+	walker.walk(textproto.MIMEHeader(header), body, "")
+}
+
+// walk processes a single entity, recursing into multipart containers. The
+// section numbers the entity like IMAP does, e.g. "2.1" for the first part of
+// the second part, and is empty for the message itself.
+func (w *mimeWalker) walk(header textproto.MIMEHeader, body []byte, section string) {
+	w.checkDuplicateHeaders(header, section)
+	depth := 0
+	if section != "" {
+		depth = strings.Count(section, ".") + 1
+	}
+
+	contentType := header.Get("Content-Type")
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		if isSMIMEEncrypted(mediaType, params) && w.email.Encryption == "" {
+			w.email.Encryption = models.EncryptionSMIME
+		}
+		w.addPart(header, mediaType, params, body)
+		return
+	}
+
+	if depth >= maxMIMEDepth {
+		w.addAnomaly(fmt.Sprintf("multipart nesting deeper than %d levels", maxMIMEDepth))
+		return
+	}
+
+	boundary := params["boundary"]
+	if boundary == "" {
+		w.addAnomaly(mediaType + " declared without a boundary")
+		return
+	}
+	for _, enclosing := range w.boundaries {
+		if enclosing == boundary {
+			w.addAnomaly("nested " + mediaType + " reuses the boundary of an enclosing part")
+			return
+		}
+	}
+
+	w.boundaries = append(w.boundaries, boundary)
+	defer func() { w.boundaries = w.boundaries[:len(w.boundaries)-1] }()
+
+	if mediaType == "multipart/signed" && strings.EqualFold(params["protocol"], "application/pgp-signature") {
+		w.addPGPMIMESignature(boundary, body)
+	}
+	if mediaType == "multipart/encrypted" && strings.EqualFold(params["protocol"], "application/pgp-encrypted") &&
+		w.email.Encryption == "" {
+		w.email.Encryption = models.EncryptionPGPMIME
+	}
+
+	reader := multipart.NewReader(bytes.NewReader(body), boundary)
+	parts := 0
+	for {
+		part, err := reader.NextRawPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// Failing to find the first part is reported below as an empty multipart
+			if parts > 0 {
+				w.addAnomaly("malformed " + mediaType + " part: " + err.Error())
+			}
+			break
+		}
+
+		partBody, err := io.ReadAll(part)
+		if err != nil {
+			w.addAnomaly("malformed " + mediaType + " part: " + err.Error())
+			break
+		}
+
+		parts++
+		if section == "" {
+			w.walk(part.Header, partBody, fmt.Sprint(parts))
+		} else {
+			w.walk(part.Header, partBody, fmt.Sprintf("%s.%d", section, parts))
+		}
+	}
+
+	if parts == 0 {
+		w.addAnomaly(mediaType + " declared with no parsable parts")
+	}
+	w.checkEpilogue(mediaType, boundary, body)
+}
+
+// checkEpilogue reports a missing terminating boundary and content hidden after it
+func (w *mimeWalker) checkEpilogue(mediaType, boundary string, body []byte) {
+	terminator := []byte("--" + boundary + "--")
+
+	end := -1
+	for offset := 0; offset < len(body); {
+		idx := bytes.Index(body[offset:], terminator)
+		if idx < 0 {
+			break
+		}
+		idx += offset
+		// The terminating boundary must start a line
+		if idx == 0 || body[idx-1] == '\n' {
+			end = idx + len(terminator)
+			break
+		}
+		offset = idx + len(terminator)
+	}
+
+	if end < 0 {
+		w.addAnomaly(mediaType + " is missing its terminating boundary")
+		return
+	}
+
+	if len(bytes.TrimSpace(body[end:])) > 0 {
+		w.addAnomaly("content after the terminating boundary of " + mediaType)
+	}
+}
+
+// addPart stores a leaf part with its transfer encoding decoded and, for displayed
+// text, converted to UTF-8 from its charset
+func (w *mimeWalker) addPart(header textproto.MIMEHeader, mediaType string, params map[string]string, body []byte) {
+	part := models.Part{
+		ContentType: mediaType,
+		Charset:     strings.ToLower(params["charset"]),
+		Headers:     header,
+		Body:        decodeTransferEncoding(header.Get("Content-Transfer-Encoding"), body),
+	}
+	sum := sha256.Sum256(part.Body)
+	part.SHA256 = hex.EncodeToString(sum[:])
+
+	disposition, dispositionParams, err := mime.ParseMediaType(header.Get("Content-Disposition"))
+	if err == nil {
+		part.Disposition = disposition
+		part.Filename = dispositionParams["filename"]
+	}
+	if part.Filename == "" {
+		part.Filename = params["name"]
+	}
+
+	// PGP signatures cover the text in its original charset
+	original := part.Body
+	if strings.HasPrefix(mediaType, "text/") && !part.IsAttachment() {
+		decoded, err := decodeCharset(part.Charset, part.Body)
+		if err != nil {
+			w.email.CharsetErrors = append(w.email.CharsetErrors, fmt.Sprintf("%s part: %v", mediaType, err))
+		}
+		part.Body = decoded
+	}
+
+	w.email.Parts = append(w.email.Parts, part)
+
+	// Inline PGP encrypted messages replace the text itself
+	if w.email.Encryption == "" && mediaType == "text/plain" && bytes.Contains(part.Body, []byte(pgpMessageHeader)) {
+		w.email.Encryption = models.EncryptionPGPInline
+	}
+
+	// Inline (cleartext) PGP signatures are part of the text itself
+	if w.email.PGP == nil && mediaType == "text/plain" && bytes.Contains(part.Body, []byte(pgpSignedMessageHeader)) {
+		w.email.PGP = &models.PGPSignature{
+			Type:   models.PGPInline,
+			Signed: original,
+		}
+	}
+}
+
+// pgpMessageHeader starts an inline PGP encrypted message
+const pgpMessageHeader = "-----BEGIN PGP MESSAGE-----"
+
+// isSMIMEEncrypted checks if a part is S/MIME enveloped (encrypted) data rather
+// than an opaque signature, per RFC 8551 section 3.2
+func isSMIMEEncrypted(mediaType string, params map[string]string) bool {
+	if mediaType != "application/pkcs7-mime" && mediaType != "application/x-pkcs7-mime" {
+		return false
+	}
+	smimeType := strings.ToLower(params["smime-type"])
+	return smimeType == "" || smimeType == "enveloped-data" || smimeType == "authenveloped-data"
+}
+
+// pgpSignedMessageHeader starts an inline (cleartext) PGP signed message
+const pgpSignedMessageHeader = "-----BEGIN PGP SIGNED MESSAGE-----"
+
+// addPGPMIMESignature records the signed content and detached signature of a
+// multipart/signed entity as described in RFC 3156
+func (w *mimeWalker) addPGPMIMESignature(boundary string, body []byte) {
+	parts := splitRawParts(body, boundary)
+	if len(parts) != 2 || w.email.PGP != nil {
+		return
+	}
+
+	// The signature covers the first part, headers included, with CRLF line endings
+	signed := bytes.ReplaceAll(parts[0], []byte("\r\n"), []byte("\n"))
+	signed = bytes.ReplaceAll(signed, []byte("\n"), []byte("\r\n"))
+
+	// The second part is the armored signature after a blank line ending its headers
+	signature := parts[1]
+	if idx := bytes.Index(signature, []byte("-----BEGIN PGP SIGNATURE-----")); idx >= 0 {
+		signature = signature[idx:]
+	}
+
+	w.email.PGP = &models.PGPSignature{
+		Type:      models.PGPMIME,
+		Signed:    signed,
+		Signature: signature,
+	}
+}
+
+// splitRawParts returns the raw entities (headers and body) of a multipart body,
+// excluding the line break that precedes each boundary delimiter
+func splitRawParts(body []byte, boundary string) [][]byte {
+	delimiter := []byte("--" + boundary)
+
+	var parts [][]byte
+	start := -1
+	for offset := 0; offset < len(body); {
+		lineEnd := bytes.IndexByte(body[offset:], '\n')
+		if lineEnd < 0 {
+			lineEnd = len(body)
+		} else {
+			lineEnd += offset
+		}
+		line := bytes.TrimRight(body[offset:lineEnd], " \t\r")
+
+		if bytes.HasPrefix(line, delimiter) {
+			if start >= 0 {
+				end := offset
+				if end > start && body[end-1] == '\n' {
+					end--
+				}
+				if end > start && body[end-1] == '\r' {
+					end--
+				}
+				parts = append(parts, body[start:end])
+			}
+			if bytes.Equal(line, append(delimiter, '-', '-')) {
+				break
+			}
+			start = lineEnd + 1
+		}
+
+		offset = lineEnd + 1
+	}
+
+	return parts
+}
+
+// checkDuplicateHeaders records the content headers an entity repeats: a scanner
+// reading one copy and a mail client the other see different content
+func (w *mimeWalker) checkDuplicateHeaders(header textproto.MIMEHeader, section string) {
+	entity := "message headers"
+	if section != "" {
+		entity = "part " + section
+	}
+
+	for _, name := range contentHeaders {
+		values := header[name]
+		if len(values) < 2 {
+			continue
+		}
+
+		duplicate := fmt.Sprintf("%s: %s (%d times", entity, name, len(values))
+		for _, value := range values[1:] {
+			if !strings.EqualFold(strings.TrimSpace(value), strings.TrimSpace(values[0])) {
+				duplicate += ", conflicting"
+				break
+			}
+		}
+		w.email.DuplicateMIME = append(w.email.DuplicateMIME, duplicate+")")
+	}
+}
+
+// addAnomaly records a structural MIME anomaly
+func (w *mimeWalker) addAnomaly(anomaly string) {
+	w.email.MIMEAnomalies = append(w.email.MIMEAnomalies, anomaly)
+}
+
+// decodeTransferEncoding decodes base64 and quoted-printable content, returning
+// the content unchanged for other encodings or if it can't be decoded
+func decodeTransferEncoding(encoding string, body []byte) []byte {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		cleaned := bytes.Map(func(r rune) rune {
+			if r == '\r' || r == '\n' || r == ' ' || r == '\t' {
+				return -1
+			}
+			return r
+		}, body)
+		decoded := make([]byte, base64.StdEncoding.DecodedLen(len(cleaned)))
+		n, err := base64.StdEncoding.Decode(decoded, cleaned)
+		if err != nil {
+			return body
+		}
+		return decoded[:n]
+
+	case "quoted-printable":
+		decoded, err := io.ReadAll(quotedprintable.NewReader(bytes.NewReader(body)))
+		if err != nil {
+			// A stray control byte mustn't leave soft-broken URLs split apart
+			return decodeQuotedPrintableLeniently(body)
+		}
+		return decoded
+	}
+
+	return body
+}
+
+// decodeQuotedPrintableLeniently decodes quoted-printable content that the standard
+// decoder rejects: soft line breaks are removed and =XX escapes decoded, while any
+// other byte, including an invalid escape, is kept as is
+func decodeQuotedPrintableLeniently(body []byte) []byte {
+	decoded := make([]byte, 0, len(body))
+	for i := 0; i < len(body); i++ {
+		if body[i] != '=' {
+			decoded = append(decoded, body[i])
+			continue
+		}
+
+		// Soft line break: "=" followed by optional whitespace and a line ending
+		rest := bytes.TrimLeft(body[i+1:], " \t")
+		switch {
+		case bytes.HasPrefix(rest, []byte("\r\n")):
+			i = len(body) - len(rest) + 1
+			continue
+		case bytes.HasPrefix(rest, []byte("\n")):
+			i = len(body) - len(rest)
+			continue
+		}
+
+		if i+2 < len(body) {
+			if b, err := hex.DecodeString(string(body[i+1 : i+3])); err == nil {
+				decoded = append(decoded, b[0])
+				i += 2
+				continue
+			}
+		}
+		decoded = append(decoded, '=')
+	}
+	return decoded
+}
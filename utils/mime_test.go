@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"testing"
+)
+
+func TestDecodeQuotedPrintableLeniently(t *testing.T) {
+	tests := []struct {
+		name    string
+		encoded string
+		decoded string
+	}{
+		{
+			"URL split across three lines",
+			"Log in at https://secure-pay=\r\npal.example.net/ac=\r\ncount/verify now\r\n",
+			"Log in at https://secure-paypal.example.net/account/verify now\r\n",
+		},
+		{
+			"bare LF and whitespace after the soft breaks",
+			"https://secure-pay= \t\npal.example.net/ac=\ncount/verify\n",
+			"https://secure-paypal.example.net/account/verify\n",
+		},
+		{
+			"escapes within the URL",
+			"https://secure-pay=\r\npal.example.net/a=3Fid=3D=\r\n42\r\n",
+			"https://secure-paypal.example.net/a?id=42\r\n",
+		},
+		{
+			"invalid escapes kept",
+			"100% =ZZ off =\r\nnow \x01=",
+			"100% =ZZ off now \x01=",
+		},
+	}
+
+	for _, tt := range tests {
+		if decoded := string(decodeQuotedPrintableLeniently([]byte(tt.encoded))); decoded != tt.decoded {
+			t.Errorf("%s: decoded %q, want %q", tt.name, decoded, tt.decoded)
+		}
+	}
+}
+
+// TestParseEmailQuotedPrintableLinks checks that links split by soft line breaks
+// are extracted whole, also from bodies the standard decoder rejects
+func TestParseEmailQuotedPrintableLinks(t *testing.T) {
+	const header = "From: PayPal <service@paypal.example.net>\r\n" +
+		"Subject: Verify your account\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n" +
+		"\r\n"
+	const want = "https://secure-paypal.example.net/account/verify"
+
+	for name, body := range map[string]string{
+		"valid":               "Log in at https://secure-pay=\r\npal.example.net/ac=\r\ncount/verify today.\r\n",
+		"with a control byte": "Log in at https://secure-pay=\r\npal.example.net/ac=\r\ncount/verify today \x01\r\n",
+	} {
+		email, err := ParseEmail([]byte(header + body))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		found := false
+		for _, link := range email.Links {
+			if link.URL == want {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("%s: links %v, want %s", name, email.Links, want)
+		}
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"bytes"
 	"errors"
 	"io"
+	"mime"
 	"net/mail"
+	"net/textproto"
 	"strings"
 
 	"github.com/user/email_spoof_detection/models"
@@ -16,25 +18,100 @@ func ParseEmail(data []byte) (*models.Email, error) {
 		return nil, errors.New("empty email data")
 	}
 
-	// Parse the email message
-	reader := bytes.NewReader(data)
+	// Exports sometimes put a BOM or blank lines before the headers, which would
+	// otherwise end the headers before they start
+	content, stripped := stripLeadingJunk(data)
+	if len(content) == 0 {
+		return nil, errors.New("empty email data")
+	}
+
+	// Parse the email message, recovering what headers we can if it is malformed
+	reader := bytes.NewReader(content)
 	msg, err := mail.ReadMessage(reader)
+	parseError := ""
+	if err != nil {
+		var recovered bool
+		if msg, recovered = readMessageLeniently(content); !recovered {
+			return nil, err
+		}
+		parseError = err.Error()
+	}
+
+	email, err := ParseMessage(msg)
 	if err != nil {
 		return nil, err
 	}
+	email.RawContent = data
+	email.ParseError = parseError
+	email.LeadingJunk = stripped
+
+	return email, nil
+}
+
+// utf8BOM is the byte order mark some editors put at the start of UTF-8 files
+var utf8BOM = []byte("\xEF\xBB\xBF")
+
+// utf16BOMs are the byte order marks of UTF-16 files by the charset they start
+var utf16BOMs = map[string][]byte{
+	"utf-16le": []byte("\xFF\xFE"),
+	"utf-16be": []byte("\xFE\xFF"),
+}
+
+// stripLeadingJunk removes a UTF-8 BOM and any whitespace, including blank lines,
+// from the start of a message, describing what was removed. Messages saved as
+// UTF-16 are converted to UTF-8 along with removing their BOM.
+func stripLeadingJunk(data []byte) ([]byte, string) {
+	var removed []string
+	if bytes.HasPrefix(data, utf8BOM) {
+		data = data[len(utf8BOM):]
+		removed = append(removed, "a UTF-8 BOM")
+	}
+	for charset, bom := range utf16BOMs {
+		if !bytes.HasPrefix(data, bom) {
+			continue
+		}
+		if decoded, err := decodeCharset(charset, data[len(bom):]); err == nil {
+			data = decoded
+			removed = append(removed, "a UTF-16 BOM")
+		}
+		break
+	}
+
+	trimmed := bytes.TrimLeft(data, " \t\r\n")
+	switch leading := data[:len(data)-len(trimmed)]; {
+	case bytes.ContainsAny(leading, "\n"):
+		removed = append(removed, "blank lines")
+	case len(leading) > 0:
+		removed = append(removed, "whitespace")
+	}
+
+	return trimmed, strings.Join(removed, " and ")
+}
+
+// ParseMessage builds an Email from a message already parsed by net/mail, reading
+// its body. RawContent is left empty since the original bytes aren't available.
+func ParseMessage(msg *mail.Message) (*models.Email, error) {
+	if msg == nil {
+		return nil, errors.New("nil message")
+	}
 
 	// Create a new Email object
 	email := &models.Email{
-		Headers:    msg.Header,
-		RawContent: data,
+		Headers: msg.Header,
 	}
 
-	// Parse From header
+	// Parse From header, falling back to the members of a group
 	from := msg.Header.Get("From")
 	if from != "" {
 		fromAddr, err := mail.ParseAddress(from)
 		if err == nil {
 			email.From = fromAddr
+		} else if group, ok := ParseGroupName(from); ok {
+			email.FromGroup = group
+			email.FromMembers = parseAddressList(from)
+			if len(email.FromMembers) > 0 {
+				email.From = email.FromMembers[0]
+			}
 		}
 	}
 
@@ -50,36 +127,284 @@ func ParseEmail(data []byte) (*models.Email, error) {
 	// Parse Return-Path header
 	returnPath := msg.Header.Get("Return-Path")
 	if returnPath != "" {
-		// Remove the angle brackets if present
-		returnPath = strings.TrimPrefix(returnPath, "<")
-		returnPath = strings.TrimSuffix(returnPath, ">")
-		email.ReturnPath = returnPath
+		email.ReturnPath, email.NullReturnPath = ParseReturnPath(returnPath)
 	}
 
+	// Parse To and Cc headers
+	email.To = parseAddressList(msg.Header.Get("To"))
+	email.Cc = parseAddressList(msg.Header.Get("Cc"))
+
 	// Parse Message-ID
 	email.MessageID = msg.Header.Get("Message-ID")
 
-	// Parse Subject
-	email.Subject = msg.Header.Get("Subject")
+	// Parse the thread headers
+	if inReplyTo := ParseMessageIDs(msg.Header.Get("In-Reply-To")); len(inReplyTo) > 0 {
+		email.InReplyTo = inReplyTo[0]
+	}
+	email.References = ParseMessageIDs(msg.Header.Get("References"))
+
+	// Recognize delivery status notifications, and note the boundary, which
+	// mailing tools generate in their own recognizable way
+	if mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type")); err == nil {
+		email.DeliveryReport = mediaType == "multipart/report" &&
+			strings.EqualFold(params["report-type"], "delivery-status")
+		if strings.HasPrefix(mediaType, "multipart/") {
+			email.Boundary = params["boundary"]
+		}
+	}
+
+	// Identify the sending software
+	email.Mailer = strings.TrimSpace(msg.Header.Get("X-Mailer"))
+	if email.Mailer == "" {
+		email.Mailer = strings.TrimSpace(msg.Header.Get("User-Agent"))
+	}
+
+	// Parse each Received header once for the checks of the delivery path
+	for _, header := range msg.Header["Received"] {
+		email.Received = append(email.Received, ParseReceived(header))
+	}
+
+	// Parse the Resent-* blocks of re-sent messages
+	email.Resent = parseResentBlocks(msg.Header)
+
+	// Parse the unsubscribe URIs of mailing list headers
+	email.ListUnsubscribe = ParseListURIs(msg.Header.Get("List-Unsubscribe"))
+
+	// Parse Subject, decoding RFC 2047 encoded words
+	email.Subject = decodeHeader(msg.Header.Get("Subject"))
 
 	// Read the message body
 	body, err := io.ReadAll(msg.Body)
 	if err == nil {
 		email.Body = string(body)
+		// Headers-only captures have no body to take apart
+		email.HasBody = len(body) > 0
+	}
+	if email.HasBody {
+		parseMIME(email, msg.Header, body)
+		extractLinks(email)
+		extractBodyAddresses(email)
 	}
 
 	return email, nil
 }
 
+// parseResentBlocks groups the Resent-* headers into blocks. Each re-send prepends
+// a block, so the n-th value of every Resent-* field belongs to the n-th block.
+func parseResentBlocks(header mail.Header) []models.Resent {
+	count := 0
+	for _, name := range []string{"Resent-From", "Resent-Sender", "Resent-To", "Resent-Date", "Resent-Message-Id"} {
+		if n := len(header[name]); n > count {
+			count = n
+		}
+	}
+
+	nth := func(name string, i int) string {
+		if values := header[name]; i < len(values) {
+			return values[i]
+		}
+		return ""
+	}
+
+	var blocks []models.Resent
+	for i := 0; i < count; i++ {
+		block := models.Resent{
+			To:        parseAddressList(nth("Resent-To", i)),
+			Date:      nth("Resent-Date", i),
+			MessageID: nth("Resent-Message-Id", i),
+		}
+		if from, err := mail.ParseAddress(nth("Resent-From", i)); err == nil {
+			block.From = from
+		}
+		if sender, err := mail.ParseAddress(nth("Resent-Sender", i)); err == nil {
+			block.Sender = sender
+		}
+		blocks = append(blocks, block)
+	}
+
+	return blocks
+}
+
+// readMessageLeniently splits a message that net/mail rejected into its header and
+// body, skipping header lines that are malformed. It reports false if no header
+// could be recovered.
+func readMessageLeniently(data []byte) (*mail.Message, bool) {
+	header := mail.Header{}
+	var lastKey string
+
+	rest := data
+	for len(rest) > 0 {
+		var line []byte
+		if idx := bytes.IndexByte(rest, '\n'); idx >= 0 {
+			line, rest = rest[:idx], rest[idx+1:]
+		} else {
+			line, rest = rest, nil
+		}
+		line = bytes.TrimRight(line, "\r")
+
+		// A blank line ends the header
+		if len(line) == 0 {
+			break
+		}
+
+		// Folded continuation of the previous field
+		if line[0] == ' ' || line[0] == '\t' {
+			if lastKey != "" {
+				values := header[lastKey]
+				values[len(values)-1] += " " + strings.TrimSpace(string(line))
+			}
+			continue
+		}
+
+		name, value, found := strings.Cut(string(line), ":")
+		if !found || name == "" || strings.ContainsAny(name, " \t") {
+			lastKey = ""
+			continue
+		}
+
+		lastKey = textproto.CanonicalMIMEHeaderKey(name)
+		header[lastKey] = append(header[lastKey], strings.TrimSpace(value))
+	}
+
+	if len(header) == 0 {
+		return nil, false
+	}
+
+	return &mail.Message{Header: header, Body: bytes.NewReader(rest)}, true
+}
+
+// decodeHeader decodes RFC 2047 encoded words such as =?UTF-8?B?...?= in a header
+// value, returning the value unchanged if it can't be decoded
+func decodeHeader(value string) string {
+	decoded, err := new(mime.WordDecoder).DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
+
+// ParseReturnPath extracts the address from a Return-Path header value. It accepts
+// the usual <local@domain> form, a bare address, and forms with a display name such
+// as "Mailer <bounce@domain>", dropping an RFC 5321 source route such as
+// <@relay:local@domain>. The second return value reports the null sender <>
+// used by bounce messages.
+func ParseReturnPath(value string) (string, bool) {
+	value = strings.TrimSpace(value)
+	if strings.ReplaceAll(value, " ", "") == "<>" {
+		return "", true
+	}
+
+	if address, err := mail.ParseAddress(value); err == nil {
+		return address.Address, false
+	}
+
+	// Fall back to the contents of the last pair of angle brackets, or what
+	// follows an opening bracket that isn't closed
+	if start := strings.LastIndex(value, "<"); start >= 0 {
+		if end := strings.Index(value[start:], ">"); end >= 0 {
+			value = value[start+1 : start+end]
+		} else {
+			value = value[start+1:]
+		}
+	}
+	value = strings.Trim(value, "<> ")
+
+	// The mailbox follows the colon ending a source route
+	if strings.HasPrefix(value, "@") {
+		if _, mailbox, found := strings.Cut(value, ":"); found {
+			value = mailbox
+		}
+	}
+
+	return value, value == ""
+}
+
+// parseAddressList parses an address list header, returning nil if it is empty or malformed
+func parseAddressList(value string) []*mail.Address {
+	if value == "" {
+		return nil
+	}
+
+	addresses, err := mail.ParseAddressList(value)
+	if err != nil {
+		return nil
+	}
+
+	return addresses
+}
+
+// ParseGroupName detects RFC 5322 group syntax ("name: member, member;") in an
+// address header value and returns the group name
+func ParseGroupName(value string) (string, bool) {
+	inQuotes := false
+	comments := 0
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; {
+		case c == '\\' && (inQuotes || comments > 0):
+			i++
+		case c == '"' && comments == 0:
+			inQuotes = !inQuotes
+		case inQuotes:
+		case c == '(':
+			comments++
+		case c == ')' && comments > 0:
+			comments--
+		case comments > 0:
+		case c == '<' || c == '@':
+			// A mailbox starts before any group name was closed
+			return "", false
+		case c == ':':
+			name := strings.TrimSpace(value[:i])
+			return strings.Trim(name, "\""), true
+		}
+	}
+
+	return "", false
+}
+
+// ParseMessageIDs extracts the <id@domain> message identifiers from an
+// In-Reply-To or References header value, in the order they appear
+func ParseMessageIDs(value string) []string {
+	var ids []string
+
+	for {
+		start := strings.Index(value, "<")
+		if start < 0 {
+			break
+		}
+		end := strings.Index(value[start:], ">")
+		if end < 0 {
+			break
+		}
+
+		ids = append(ids, value[start:start+end+1])
+		value = value[start+end+1:]
+	}
+
+	return ids
+}
+
+// ParseListURIs extracts the <uri> entries of an RFC 2369 list header such as
+// List-Unsubscribe, in the order they appear
+func ParseListURIs(value string) []string {
+	var uris []string
+	for _, id := range ParseMessageIDs(value) {
+		if uri := strings.TrimSpace(strings.Trim(id, "<>")); uri != "" {
+			uris = append(uris, uri)
+		}
+	}
+	return uris
+}
+
 // ExtractEmailParts extracts the local part and domain from an email address
 func ExtractEmailParts(email string) (string, string, error) {
 	parts := strings.Split(email, "@")
 	if len(parts) != 2 {
 		return "", "", errors.New("invalid email format")
 	}
-	
+
 	localPart := parts[0]
 	domain := parts[1]
-	
+
 	return localPart, domain, nil
 }
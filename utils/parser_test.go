@@ -0,0 +1,199 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseReturnPath(t *testing.T) {
+	tests := []struct {
+		value      string
+		address    string
+		nullSender bool
+	}{
+		{"<bounce@example.com>", "bounce@example.com", false},
+		{"bounce@example.com", "bounce@example.com", false},
+		{"Mailer <bounce@example.com>", "bounce@example.com", false},
+		{"  <bounce@example.com>  ", "bounce@example.com", false},
+
+		// The null sender of bounces
+		{"<>", "", true},
+		{"< >", "", true},
+		{"", "", true},
+
+		// Source routes
+		{"<@relay.example.net:bounce@example.com>", "bounce@example.com", false},
+		{"<@relay.example.net,@mx.example.org:bounce@example.com>", "bounce@example.com", false},
+
+		// Missing brackets
+		{"<bounce@example.com", "bounce@example.com", false},
+		{"bounce@example.com>", "bounce@example.com", false},
+		{"Mailer <bounce@example.com", "bounce@example.com", false},
+	}
+
+	for _, tt := range tests {
+		address, nullSender := ParseReturnPath(tt.value)
+		if address != tt.address || nullSender != tt.nullSender {
+			t.Errorf("ParseReturnPath(%q) = %q, %v, want %q, %v",
+				tt.value, address, nullSender, tt.address, tt.nullSender)
+		}
+	}
+}
+
+func TestParseGroupName(t *testing.T) {
+	tests := []struct {
+		value string
+		name  string
+		group bool
+	}{
+		{"undisclosed-recipients:;", "undisclosed-recipients", true},
+		{"Support Team: alice@example.com, Bob <bob@example.com>;", "Support Team", true},
+		{`"Billing" : alice@example.com;`, "Billing", true},
+
+		// Colons within mailboxes, quotes and comments don't start a group
+		{"alice@example.com", "", false},
+		{"Alice <alice@example.com>", "", false},
+		{`"Sales: EU" <sales@example.com>`, "", false},
+		{"Alice (re: invoice) <alice@example.com>", "", false},
+		{"<@relay.example.net:alice@example.com>", "", false},
+		{"", "", false},
+	}
+
+	for _, tt := range tests {
+		name, group := ParseGroupName(tt.value)
+		if name != tt.name || group != tt.group {
+			t.Errorf("ParseGroupName(%q) = %q, %v, want %q, %v", tt.value, name, group, tt.name, tt.group)
+		}
+	}
+}
+
+func TestParseEmailFromGroup(t *testing.T) {
+	tests := []struct {
+		from    string
+		group   string
+		members []string
+	}{
+		{"undisclosed-recipients:;", "undisclosed-recipients", nil},
+		{"Support Team: alice@example.com, Bob <bob@example.com>;", "Support Team",
+			[]string{"alice@example.com", "bob@example.com"}},
+	}
+
+	for _, tt := range tests {
+		email, err := ParseEmail([]byte("From: " + tt.from + "\r\nSubject: Hello\r\n\r\nHello\r\n"))
+		if err != nil {
+			t.Fatalf("ParseEmail with From %q: %v", tt.from, err)
+		}
+
+		if email.FromGroup != tt.group {
+			t.Errorf("From %q: FromGroup = %q, want %q", tt.from, email.FromGroup, tt.group)
+		}
+		var members []string
+		for _, member := range email.FromMembers {
+			members = append(members, member.Address)
+		}
+		if !reflect.DeepEqual(members, tt.members) {
+			t.Errorf("From %q: FromMembers = %q, want %q", tt.from, members, tt.members)
+		}
+
+		// The first member stands in as the sender
+		switch {
+		case len(tt.members) == 0 && email.From != nil:
+			t.Errorf("From %q: From = %v, want none", tt.from, email.From)
+		case len(tt.members) > 0 && (email.From == nil || email.From.Address != tt.members[0]):
+			t.Errorf("From %q: From = %v, want %s", tt.from, email.From, tt.members[0])
+		}
+	}
+}
+
+func TestParseEmailLeadingJunk(t *testing.T) {
+	const message = "From: Alice <alice@example.com>\r\nSubject: Hello\r\n\r\nHello\r\n"
+
+	// utf16 encodes the message as UTF-16 with its BOM
+	utf16 := func(bigEndian bool) string {
+		var encoded []byte
+		if bigEndian {
+			encoded = append(encoded, 0xFE, 0xFF)
+		} else {
+			encoded = append(encoded, 0xFF, 0xFE)
+		}
+		for _, c := range []byte(message) {
+			if bigEndian {
+				encoded = append(encoded, 0, c)
+			} else {
+				encoded = append(encoded, c, 0)
+			}
+		}
+		return string(encoded)
+	}
+
+	tests := []struct {
+		name string
+		data string
+		junk string
+	}{
+		{"clean", message, ""},
+		{"UTF-8 BOM", "\xEF\xBB\xBF" + message, "a UTF-8 BOM"},
+		{"UTF-16LE BOM", utf16(false), "a UTF-16 BOM"},
+		{"UTF-16BE BOM", utf16(true), "a UTF-16 BOM"},
+		{"leading whitespace", "  \t" + message, "whitespace"},
+		{"blank lines", "\r\n\r\n" + message, "blank lines"},
+		{"BOM and blank lines", "\xEF\xBB\xBF\n \n" + message, "a UTF-8 BOM and blank lines"},
+	}
+
+	for _, tt := range tests {
+		email, err := ParseEmail([]byte(tt.data))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+
+		if email.LeadingJunk != tt.junk {
+			t.Errorf("%s: LeadingJunk = %q, want %q", tt.name, email.LeadingJunk, tt.junk)
+		}
+		if email.From == nil || email.From.Address != "alice@example.com" {
+			t.Errorf("%s: From = %v, want alice@example.com", tt.name, email.From)
+		}
+		if email.Subject != "Hello" {
+			t.Errorf("%s: Subject = %q, want Hello", tt.name, email.Subject)
+		}
+	}
+
+	// Only whitespace is no email at all
+	if _, err := ParseEmail([]byte("\xEF\xBB\xBF\r\n\r\n")); err == nil {
+		t.Error("ParseEmail of a BOM and blank lines succeeded, want an error")
+	}
+}
+
+func TestParseEmailHeadersOnly(t *testing.T) {
+	const header = "From: Alice <alice@example.com>\r\n" +
+		"Subject: Hello\r\n" +
+		"Content-Type: text/html\r\n"
+
+	for name, data := range map[string]string{
+		"truncated at the body": header,
+		"empty body":            header + "\r\n",
+	} {
+		email, err := ParseEmail([]byte(data))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if email.HasBody || email.Body != "" {
+			t.Errorf("%s: HasBody = %v with body %q, want no body", name, email.HasBody, email.Body)
+		}
+		if email.Subject != "Hello" || email.From == nil {
+			t.Errorf("%s: headers not parsed: subject %q, from %v", name, email.Subject, email.From)
+		}
+		if len(email.Links) > 0 || len(email.Parts) > 0 {
+			t.Errorf("%s: body parts found: links %v, parts %v", name, email.Links, email.Parts)
+		}
+	}
+
+	email, err := ParseEmail([]byte(header + "\r\n<a href=\"https://example.com/\">Hello</a>\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !email.HasBody || len(email.Links) != 1 {
+		t.Errorf("with a body: HasBody = %v with links %v, want a body with one link", email.HasBody, email.Links)
+	}
+}
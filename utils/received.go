@@ -0,0 +1,146 @@
+package utils
+
+import (
+	"net"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/user/email_spoof_detection/models"
+)
+
+// receivedIPPattern matches a bracketed IP address such as [203.0.113.5] or [IPv6:2001:db8::1]
+var receivedIPPattern = regexp.MustCompile(`\[(?i:IPv6:)?([0-9a-fA-F:.]+)\]`)
+
+// receivedHostPattern matches the reverse DNS name that starts a "from" clause
+// comment such as "(mail.example.com [203.0.113.5])"
+var receivedHostPattern = regexp.MustCompile(`^\s*([A-Za-z0-9.-]+)\s+\[`)
+
+// ParseReceived splits a Received header into its fields in a single pass over
+// its words and comments, as described in RFC 5321 section 4.4:
+//
+//	from mail.example.com (mail.example.com [203.0.113.5]) by mx.example.net
+//	    (Postfix) with ESMTPS id 4F2A1 for <user@example.net>; Mon, 1 Jan 2024 10:00:00 +0000
+func ParseReceived(header string) models.ReceivedHop {
+	var hop models.ReceivedHop
+
+	clauses := header
+	if idx := strings.LastIndexByte(header, ';'); idx >= 0 {
+		clauses = header[:idx]
+		hop.Time = parseReceivedDate(strings.TrimSpace(header[idx+1:]))
+	}
+
+	clause, first := "", false
+	for i := 0; i < len(clauses); {
+		switch c := clauses[i]; {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+
+		case c == '(':
+			end := commentEnd(clauses, i)
+			if clause == "from" {
+				addFromComment(&hop, clauses[i+1:end])
+			}
+			i = end + 1
+
+		default:
+			end := i
+			for end < len(clauses) && !strings.ContainsRune(" \t\r\n(", rune(clauses[end])) {
+				end++
+			}
+			word := clauses[i:end]
+			i = end
+
+			switch keyword := strings.ToLower(word); keyword {
+			case "from", "by", "via", "with", "id", "for":
+				clause, first = keyword, true
+				continue
+			}
+			if !first {
+				if clause == "from" && hop.FromIP == nil {
+					hop.FromIP = bracketedIP(word)
+				}
+				continue
+			}
+			first = false
+
+			switch clause {
+			case "from":
+				hop.FromHELO = strings.TrimSuffix(word, ".")
+				if hop.FromIP == nil {
+					hop.FromIP = bracketedIP(word)
+				}
+			case "by":
+				hop.ByHost = strings.TrimSuffix(word, ".")
+			case "with":
+				hop.Protocol = word
+			case "for":
+				// Servers write "for <user@example.net>" or "for user@example.net",
+				// but also "for multiple recipients"
+				if recipient := strings.Trim(word, "<>"); strings.Contains(recipient, "@") {
+					hop.For = recipient
+				}
+			}
+		}
+	}
+
+	return hop
+}
+
+// receivedDateLayout is the date format almost every server writes in Received headers
+const receivedDateLayout = "Mon, 2 Jan 2006 15:04:05 -0700"
+
+// parseReceivedDate parses the timestamp of a Received header, trying the common
+// layout before the slower RFC 5322 parser, or returns the zero time
+func parseReceivedDate(value string) time.Time {
+	if timestamp, err := time.Parse(receivedDateLayout, value); err == nil {
+		return timestamp
+	}
+	if timestamp, err := mail.ParseDate(value); err == nil {
+		return timestamp
+	}
+	return time.Time{}
+}
+
+// addFromComment records the reverse DNS name and IP address of a "from" clause comment
+func addFromComment(hop *models.ReceivedHop, comment string) {
+	if hop.FromHost == "" {
+		if match := receivedHostPattern.FindStringSubmatch(comment); match != nil {
+			hop.FromHost = strings.TrimSuffix(strings.ToLower(match[1]), ".")
+		}
+	}
+	if hop.FromIP == nil {
+		hop.FromIP = bracketedIP(comment)
+	}
+}
+
+// commentEnd returns the index of the parenthesis closing the comment opened at
+// start, allowing nested comments, or the end of the text if it isn't closed
+func commentEnd(text string, start int) int {
+	depth := 0
+	for i := start; i < len(text); i++ {
+		switch text[i] {
+		case '\\':
+			i++
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return len(text)
+}
+
+// bracketedIP returns the first bracketed IP address in a text, or nil
+func bracketedIP(text string) net.IP {
+	for _, match := range receivedIPPattern.FindAllStringSubmatch(text, -1) {
+		if ip := net.ParseIP(match[1]); ip != nil {
+			return ip
+		}
+	}
+	return nil
+}
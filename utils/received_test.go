@@ -0,0 +1,72 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseReceived(t *testing.T) {
+	header := "from mail.example.com (mail.example.com [203.0.113.5]) by mx.example.net\r\n" +
+		"    (Postfix) with ESMTPS id 4F2A1 for <user@example.net>; Mon, 1 Jan 2024 10:00:00 +0000"
+	hop := ParseReceived(header)
+
+	if hop.FromHELO != "mail.example.com" || hop.FromHost != "mail.example.com" {
+		t.Errorf("from = %q (host %q), want mail.example.com", hop.FromHELO, hop.FromHost)
+	}
+	if hop.FromIP.String() != "203.0.113.5" {
+		t.Errorf("FromIP = %v, want 203.0.113.5", hop.FromIP)
+	}
+	if hop.ByHost != "mx.example.net" || hop.Protocol != "ESMTPS" || hop.For != "user@example.net" {
+		t.Errorf("by %q with %q for %q, want mx.example.net, ESMTPS, user@example.net", hop.ByHost, hop.Protocol, hop.For)
+	}
+	if want := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC); !hop.Time.Equal(want) {
+		t.Errorf("Time = %v, want %v", hop.Time, want)
+	}
+
+	// An IPv6 literal announced as HELO, and a clause without a date
+	hop = ParseReceived("from [IPv6:2001:db8::1] (unknown [IPv6:2001:db8::1]) by mx.example.net with SMTP")
+	if hop.FromIP.String() != "2001:db8::1" || hop.FromHost != "unknown" || !hop.Time.IsZero() {
+		t.Errorf("IPv6 hop = %+v", hop)
+	}
+}
+
+// receivedChain builds the Received headers of a message relayed through n hops
+func receivedChain(n int) []string {
+	headers := make([]string, n)
+	for i := range headers {
+		headers[i] = fmt.Sprintf("from relay%d.example.com (relay%d.example.com [198.51.100.%d]) by relay%d.example.com\r\n"+
+			"    (Postfix) with ESMTPS id %X for <user@example.net>; Mon, 1 Jan 2024 10:%02d:00 +0000",
+			i+1, i+1, i+1, i, i, 59-i)
+	}
+	return headers
+}
+
+func BenchmarkParseReceived(b *testing.B) {
+	headers := receivedChain(40)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for _, header := range headers {
+			ParseReceived(header)
+		}
+	}
+}
+
+// BenchmarkParseEmailReceivedChain parses a message with dozens of Received
+// headers, each of which is parsed once for all checks of the delivery path
+func BenchmarkParseEmailReceivedChain(b *testing.B) {
+	var message strings.Builder
+	for _, header := range receivedChain(40) {
+		message.WriteString("Received: " + header + "\r\n")
+	}
+	message.WriteString("From: alice@example.com\r\nSubject: Hello\r\n\r\nHello\r\n")
+	data := []byte(message.String())
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := ParseEmail(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/user/email_spoof_detection/models"
+)
+
+// verdictFiles appends the path of each analyzed email to the file of its
+// verdict, so a scan sorts its input for quarantine or triage pipelines
+type verdictFiles struct {
+	mu    sync.Mutex // Keeps the lines of concurrent analyses whole
+	files map[models.Verdict]*os.File
+}
+
+// openVerdictFiles opens the output files given for each verdict for appending,
+// creating them as needed; verdicts with an empty path aren't written. It
+// returns nil when no path is given.
+func openVerdictFiles(paths map[models.Verdict]string) (*verdictFiles, error) {
+	v := &verdictFiles{files: map[models.Verdict]*os.File{}}
+	for verdict, path := range paths {
+		if path == "" {
+			continue
+		}
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+		if err != nil {
+			v.Close()
+			return nil, fmt.Errorf("opening %s output file: %w", verdict, err)
+		}
+		v.files[verdict] = file
+	}
+
+	if len(v.files) == 0 {
+		return nil, nil
+	}
+	return v, nil
+}
+
+// Record appends the path of an email to the file of its verdict, if any
+func (v *verdictFiles) Record(path string, verdict models.Verdict) error {
+	file, found := v.files[verdict]
+	if !found {
+		return nil
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if _, err := file.WriteString(path + "\n"); err != nil {
+		return fmt.Errorf("writing %s output file: %w", verdict, err)
+	}
+	return nil
+}
+
+// Close closes the output files
+func (v *verdictFiles) Close() error {
+	var firstErr error
+	for _, file := range v.files {
+		if err := file.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}